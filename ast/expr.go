@@ -6,18 +6,30 @@ type ExprVisitor interface {
 	VisitAssignmentExpr(*AssignmentExpr) interface{}
 	VisitBinaryExpr(*BinaryExpr) interface{}
 	VisitCallExpr(*CallExpr) interface{}
+	VisitFunctionExpr(*FunctionExpr) interface{}
+	VisitGetExpr(*GetExpr) interface{}
 	VisitGroupingExpr(*GroupingExpr) interface{}
+	VisitIndexExpr(*IndexExpr) interface{}
+	VisitListExpr(*ListExpr) interface{}
 	VisitLiteralExpr(*LiteralExpr) interface{}
 	VisitLogicalExpr(*LogicalExpr) interface{}
+	VisitMapExpr(*MapExpr) interface{}
+	VisitSetExpr(*SetExpr) interface{}
+	VisitSetIndexExpr(*SetIndexExpr) interface{}
+	VisitSuperExpr(*SuperExpr) interface{}
+	VisitThisExpr(*ThisExpr) interface{}
 	VisitUnaryExpr(*UnaryExpr) interface{}
 	VisitVarExpr(*VarExpr) interface{}
 }
 
 type Expr interface {
 	AcceptExpr(ExprVisitor) interface{}
+	Start() token.Position
+	End() token.Position
 }
 
 type AssignmentExpr struct {
+	Node
 	Name  token.Token
 	Value Expr
 }
@@ -27,6 +39,7 @@ func (e *AssignmentExpr) AcceptExpr(v ExprVisitor) interface{} {
 }
 
 type BinaryExpr struct {
+	Node
 	Left     Expr
 	Operator token.Token
 	Right    Expr
@@ -37,6 +50,7 @@ func (e *BinaryExpr) AcceptExpr(v ExprVisitor) interface{} {
 }
 
 type CallExpr struct {
+	Node
 	Callee    Expr
 	Paren     token.Token
 	Arguments []Expr
@@ -46,7 +60,29 @@ func (e *CallExpr) AcceptExpr(v ExprVisitor) interface{} {
 	return v.VisitCallExpr(e)
 }
 
+type FunctionExpr struct {
+	Node
+	Keyword token.Token
+	Params  []token.Token
+	Body    *BlockStmt
+}
+
+func (e *FunctionExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitFunctionExpr(e)
+}
+
+type GetExpr struct {
+	Node
+	Object Expr
+	Name   token.Token
+}
+
+func (e *GetExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitGetExpr(e)
+}
+
 type GroupingExpr struct {
+	Node
 	Expression Expr
 }
 
@@ -54,7 +90,29 @@ func (e *GroupingExpr) AcceptExpr(v ExprVisitor) interface{} {
 	return v.VisitGroupingExpr(e)
 }
 
+type IndexExpr struct {
+	Node
+	Object  Expr
+	Bracket token.Token
+	Index   Expr
+}
+
+func (e *IndexExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitIndexExpr(e)
+}
+
+type ListExpr struct {
+	Node
+	Bracket  token.Token
+	Elements []Expr
+}
+
+func (e *ListExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitListExpr(e)
+}
+
 type LiteralExpr struct {
+	Node
 	Value interface{}
 }
 
@@ -63,6 +121,7 @@ func (e *LiteralExpr) AcceptExpr(v ExprVisitor) interface{} {
 }
 
 type LogicalExpr struct {
+	Node
 	Left     Expr
 	Operator token.Token
 	Right    Expr
@@ -72,7 +131,62 @@ func (e *LogicalExpr) AcceptExpr(v ExprVisitor) interface{} {
 	return v.VisitLogicalExpr(e)
 }
 
+// MapExpr is a map literal, where each key is paired with the value at the same position.
+type MapExpr struct {
+	Node
+	Brace  token.Token
+	Keys   []Expr
+	Values []Expr
+}
+
+func (e *MapExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitMapExpr(e)
+}
+
+type SetExpr struct {
+	Node
+	Object Expr
+	Name   token.Token
+	Value  Expr
+}
+
+func (e *SetExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitSetExpr(e)
+}
+
+type SetIndexExpr struct {
+	Node
+	Object  Expr
+	Bracket token.Token
+	Index   Expr
+	Value   Expr
+}
+
+func (e *SetIndexExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitSetIndexExpr(e)
+}
+
+type SuperExpr struct {
+	Node
+	Keyword token.Token
+	Method  token.Token
+}
+
+func (e *SuperExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitSuperExpr(e)
+}
+
+type ThisExpr struct {
+	Node
+	Keyword token.Token
+}
+
+func (e *ThisExpr) AcceptExpr(v ExprVisitor) interface{} {
+	return v.VisitThisExpr(e)
+}
+
 type UnaryExpr struct {
+	Node
 	Operator token.Token
 	Right    Expr
 }
@@ -82,6 +196,7 @@ func (e *UnaryExpr) AcceptExpr(v ExprVisitor) interface{} {
 }
 
 type VarExpr struct {
+	Node
 	Name token.Token
 }
 
@@ -0,0 +1,47 @@
+package ast
+
+import "lox/token"
+
+// Node is embedded in every expression and statement to record the source it was parsed from.
+type Node struct {
+	Span token.Span
+}
+
+// Start returns the position of the first token of the node.
+func (n *Node) Start() token.Position {
+	return n.Span.Start
+}
+
+// End returns the position right after the last token of the node.
+func (n *Node) End() token.Position {
+	return n.Span.End
+}
+
+// Spanned is implemented by every expression and statement.
+type Spanned interface {
+	Start() token.Position
+	End() token.Position
+}
+
+// SpanOf returns the span of source a node was parsed from.
+func SpanOf(node Spanned) token.Span {
+	return token.Span{Start: node.Start(), End: node.End()}
+}
+
+// IsDeclaration tells whether a statement declares a name.
+func IsDeclaration(stmt Stmt) bool {
+	switch stmt.(type) {
+	case *VarDeclStmt, *FunDeclStmt, *ClassStmt:
+		return true
+	}
+	return false
+}
+
+// Program is a whole script: its top-level statements in order, without the EndStmt the parser
+// returns once there is nothing left, and all of its comments. Its span goes from its first
+// statement to the end of the input.
+type Program struct {
+	Node
+	Statements []Stmt
+	Comments   []token.Comment
+}
@@ -5,21 +5,30 @@ import "lox/token"
 type StmtVisitor interface {
 	VisitVarDeclStmt(*VarDeclStmt) interface{}
 	VisitFunDeclStmt(*FunDeclStmt) interface{}
+	VisitClassStmt(*ClassStmt) interface{}
 	VisitBlockStmt(*BlockStmt) interface{}
 	VisitExprStmt(*ExprStmt) interface{}
 	VisitIfStmt(*IfStmt) interface{}
 	VisitAssertStmt(*AssertStmt) interface{}
 	VisitPrintStmt(*PrintStmt) interface{}
 	VisitWhileStmt(*WhileStmt) interface{}
+	VisitForStmt(*ForStmt) interface{}
+	VisitBreakStmt(*BreakStmt) interface{}
+	VisitContinueStmt(*ContinueStmt) interface{}
+	VisitThrowStmt(*ThrowStmt) interface{}
+	VisitTryStmt(*TryStmt) interface{}
 	VisitReturnStmt(*ReturnStmt) interface{}
 	VisitEndStmt(*EndStmt) interface{}
 }
 
 type Stmt interface {
 	AcceptStmt(StmtVisitor) interface{}
+	Start() token.Position
+	End() token.Position
 }
 
 type VarDeclStmt struct {
+	Node
 	Name        token.Token
 	Initializer *Expr
 }
@@ -29,6 +38,7 @@ func (s *VarDeclStmt) AcceptStmt(v StmtVisitor) interface{} {
 }
 
 type FunDeclStmt struct {
+	Node
 	Name   token.Token
 	Params []token.Token
 	Body   *BlockStmt
@@ -38,7 +48,19 @@ func (s *FunDeclStmt) AcceptStmt(v StmtVisitor) interface{} {
 	return v.VisitFunDeclStmt(s)
 }
 
+type ClassStmt struct {
+	Node
+	Name       token.Token
+	Superclass *VarExpr
+	Methods    []*FunDeclStmt
+}
+
+func (s *ClassStmt) AcceptStmt(v StmtVisitor) interface{} {
+	return v.VisitClassStmt(s)
+}
+
 type BlockStmt struct {
+	Node
 	Statements []Stmt
 }
 
@@ -47,6 +69,7 @@ func (s *BlockStmt) AcceptStmt(v StmtVisitor) interface{} {
 }
 
 type ExprStmt struct {
+	Node
 	Expression Expr
 }
 
@@ -55,6 +78,7 @@ func (s *ExprStmt) AcceptStmt(v StmtVisitor) interface{} {
 }
 
 type IfStmt struct {
+	Node
 	Condition  Expr
 	ThenBranch *Stmt
 	ElseBranch *Stmt
@@ -65,6 +89,8 @@ func (s *IfStmt) AcceptStmt(v StmtVisitor) interface{} {
 }
 
 type AssertStmt struct {
+	Node
+	Keyword    token.Token
 	Expression Expr
 }
 
@@ -73,6 +99,7 @@ func (s *AssertStmt) AcceptStmt(v StmtVisitor) interface{} {
 }
 
 type PrintStmt struct {
+	Node
 	Expression Expr
 }
 
@@ -81,6 +108,7 @@ func (s *PrintStmt) AcceptStmt(v StmtVisitor) interface{} {
 }
 
 type WhileStmt struct {
+	Node
 	Condition Expr
 	Body      Stmt
 }
@@ -89,7 +117,65 @@ func (s *WhileStmt) AcceptStmt(v StmtVisitor) interface{} {
 	return v.VisitWhileStmt(s)
 }
 
+// ForStmt is a C-style for loop. The initializer, condition and increment are optional and nil
+// when missing. The initializer is scoped to the loop.
+type ForStmt struct {
+	Node
+	Keyword     token.Token
+	Initializer Stmt
+	Condition   Expr
+	Increment   Expr
+	Body        Stmt
+}
+
+func (s *ForStmt) AcceptStmt(v StmtVisitor) interface{} {
+	return v.VisitForStmt(s)
+}
+
+type BreakStmt struct {
+	Node
+	Keyword token.Token
+}
+
+func (s *BreakStmt) AcceptStmt(v StmtVisitor) interface{} {
+	return v.VisitBreakStmt(s)
+}
+
+type ContinueStmt struct {
+	Node
+	Keyword token.Token
+}
+
+func (s *ContinueStmt) AcceptStmt(v StmtVisitor) interface{} {
+	return v.VisitContinueStmt(s)
+}
+
+type ThrowStmt struct {
+	Node
+	Keyword token.Token
+	Value   Expr
+}
+
+func (s *ThrowStmt) AcceptStmt(v StmtVisitor) interface{} {
+	return v.VisitThrowStmt(s)
+}
+
+// TryStmt has a catch clause, a finally clause or both; Catch and Finally are nil when missing.
+type TryStmt struct {
+	Node
+	Keyword   token.Token
+	Body      *BlockStmt
+	CatchName token.Token
+	Catch     *BlockStmt
+	Finally   *BlockStmt
+}
+
+func (s *TryStmt) AcceptStmt(v StmtVisitor) interface{} {
+	return v.VisitTryStmt(s)
+}
+
 type ReturnStmt struct {
+	Node
 	Keyword token.Token
 	Value   *Expr
 }
@@ -99,6 +185,7 @@ func (s *ReturnStmt) AcceptStmt(v StmtVisitor) interface{} {
 }
 
 type EndStmt struct {
+	Node
 }
 
 func (s *EndStmt) AcceptStmt(v StmtVisitor) interface{} {
@@ -0,0 +1,30 @@
+package builtin
+
+import (
+	"fmt"
+	"lox/token"
+)
+
+// Error is what a runtime error turns into when a script catches it. Scripts can only read
+// the message and the line, but the span is kept for when the error is thrown again.
+type Error struct {
+	Message string
+	Line    int
+	Span    token.Span
+}
+
+func (e *Error) String() string {
+	return fmt.Sprintf("error on line %d: %s", e.Line, e.Message)
+}
+
+// Get returns the value of one of the properties scripts can read from an error.
+func (e *Error) Get(name string) (interface{}, error) {
+	switch name {
+	case "message":
+		return e.Message, nil
+	case "line":
+		return float64(e.Line), nil
+	default:
+		return nil, fmt.Errorf("undefined property '%s'", name)
+	}
+}
@@ -0,0 +1,85 @@
+package builtin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The errors a script fails with when it goes over one of its limits. Runtime errors wrap
+// them, so hosts can tell them apart with errors.Is.
+var (
+	ErrCanceled  = errors.New("execution canceled")
+	ErrStepLimit = errors.New("step limit exceeded")
+	ErrCallDepth = errors.New("stack overflow")
+)
+
+// checkInterval is how many steps a script takes between checks of its context, as checking
+// it on every step would slow everything down.
+const checkInterval = 1024
+
+// DefaultMaxCallDepth is how deeply calls can be nested when the host sets no limit. Both
+// backends share it, so that scripts recursing deeply run the same on either.
+const DefaultMaxCallDepth = 1024
+
+// Limits bound the resources a script can use. Zero values mean no limit, except for
+// MaxCallDepth, which then defaults to DefaultMaxCallDepth.
+type Limits struct {
+	// Context stops the script once it is done, e.g. when its deadline passes.
+	Context context.Context
+	// MaxSteps is how many steps the script can take. A step is a statement or an expression
+	// for the tree-walking interpreter, and an instruction for the virtual machine.
+	MaxSteps int
+	// MaxCallDepth is how deeply calls can be nested.
+	MaxCallDepth int
+}
+
+// CallDepth returns the maximum call depth, or the given default if there is none.
+func (l Limits) CallDepth(max int) int {
+	if l.MaxCallDepth > 0 {
+		return l.MaxCallDepth
+	}
+	return max
+}
+
+// Budget counts the steps a script takes, to stop it once it goes over its limits.
+type Budget struct {
+	limits Limits
+	steps  int
+	next   int
+}
+
+// NewBudget creates a budget checking the limits on the first step.
+func NewBudget(limits Limits) Budget {
+	return Budget{limits: limits, next: 1}
+}
+
+// Step counts one more step, failing if the script ran out of steps or its context is done.
+func (b *Budget) Step() error {
+	b.steps++
+	if b.steps < b.next {
+		return nil
+	}
+	if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+		return ErrStepLimit
+	}
+	if b.limits.Context != nil {
+		if err := b.limits.Context.Err(); err != nil {
+			return fmt.Errorf("%w: %w", ErrCanceled, err)
+		}
+	}
+	b.schedule()
+	return nil
+}
+
+// schedule sets the step at which the limits are checked next.
+func (b *Budget) schedule() {
+	b.next = math.MaxInt
+	if b.limits.Context != nil {
+		b.next = b.steps + checkInterval
+	}
+	if b.limits.MaxSteps > 0 && b.limits.MaxSteps+1 < b.next {
+		b.next = b.limits.MaxSteps + 1
+	}
+}
@@ -0,0 +1,125 @@
+package builtin
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type List struct {
+	Elements []interface{}
+}
+
+func NewList(elements []interface{}) *List {
+	return &List{Elements: elements}
+}
+
+func (l *List) String() string {
+	return Repr(l)
+}
+
+// Repr renders a value as it appears inside a collection, where strings are quoted.
+func Repr(value interface{}) string {
+	return repr(value, make(map[interface{}]bool))
+}
+
+// repr renders a value like Repr does, given the lists and maps being rendered around it. A
+// collection that contains itself, directly or not, is rendered as "[...]" or "{...}" where it
+// appears again, rather than forever.
+func repr(value interface{}, enclosing map[interface{}]bool) string {
+	switch value := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", value)
+	case *List:
+		if enclosing[value] {
+			return "[...]"
+		}
+		enclosing[value] = true
+		defer delete(enclosing, value)
+		builder := strings.Builder{}
+		builder.WriteRune('[')
+		for i, element := range value.Elements {
+			if i > 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteString(repr(element, enclosing))
+		}
+		builder.WriteRune(']')
+		return builder.String()
+	case *Map:
+		if enclosing[value] {
+			return "{...}"
+		}
+		enclosing[value] = true
+		defer delete(enclosing, value)
+		builder := strings.Builder{}
+		builder.WriteRune('{')
+		for i, key := range value.keys {
+			if i > 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteString(repr(key, enclosing))
+			builder.WriteString(": ")
+			builder.WriteString(repr(value.entries[key], enclosing))
+		}
+		builder.WriteRune('}')
+		return builder.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// Index returns the element at the given index of a list, or the value of the given key of a map.
+func Index(object interface{}, index interface{}) (interface{}, error) {
+	switch x := object.(type) {
+	case *List:
+		i, err := x.checkIndex(index, len(x.Elements)-1)
+		if err != nil {
+			return nil, err
+		}
+		return x.Elements[i], nil
+	case *Map:
+		return x.Get(index)
+	default:
+		return nil, fmt.Errorf("only lists and maps can be indexed")
+	}
+}
+
+// SetIndex replaces the element at the given index of a list, or sets the given key of a map.
+func SetIndex(object interface{}, index interface{}, value interface{}) error {
+	switch x := object.(type) {
+	case *List:
+		i, err := x.checkIndex(index, len(x.Elements)-1)
+		if err != nil {
+			return err
+		}
+		x.Elements[i] = value
+		return nil
+	case *Map:
+		return x.Set(index, value)
+	default:
+		return fmt.Errorf("only lists and maps can be indexed")
+	}
+}
+
+// checkIndex returns the index as an int, if it is an integer between zero and max.
+func (l *List) checkIndex(index interface{}, max int) (int, error) {
+	i, err := integer(index, "index")
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 || i > max {
+		return 0, fmt.Errorf("index %d out of bounds for list of length %d", i, len(l.Elements))
+	}
+	return i, nil
+}
+
+func integer(value interface{}, what string) (int, error) {
+	x, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s must be a number", what)
+	}
+	if x != math.Trunc(x) || math.IsInf(x, 0) {
+		return 0, fmt.Errorf("%s must be an integer", what)
+	}
+	return int(x), nil
+}
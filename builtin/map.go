@@ -0,0 +1,103 @@
+package builtin
+
+import (
+	"fmt"
+	"math"
+)
+
+// Map associates hashable values (numbers, strings, booleans and nil) with arbitrary values.
+// Keys are kept in insertion order, so that iterating over them is deterministic.
+type Map struct {
+	keys    []interface{}
+	entries map[interface{}]interface{}
+}
+
+func NewMap() *Map {
+	return &Map{entries: make(map[interface{}]interface{})}
+}
+
+func (m *Map) String() string {
+	return Repr(m)
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the keys of the map, in the order in which they were first inserted.
+func (m *Map) Keys() []interface{} {
+	keys := make([]interface{}, len(m.keys))
+	copy(keys, m.keys)
+	return keys
+}
+
+// Values returns the values of the map, in the same order as Keys.
+func (m *Map) Values() []interface{} {
+	values := make([]interface{}, len(m.keys))
+	for i, key := range m.keys {
+		values[i] = m.entries[key]
+	}
+	return values
+}
+
+func (m *Map) Get(key interface{}) (interface{}, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	value, ok := m.entries[key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in map", Repr(key))
+	}
+	return value, nil
+}
+
+func (m *Map) Set(key interface{}, value interface{}) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if _, ok := m.entries[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.entries[key] = value
+	return nil
+}
+
+func (m *Map) Has(key interface{}) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	_, ok := m.entries[key]
+	return ok, nil
+}
+
+// Delete removes a key from the map, returning whether it was there.
+func (m *Map) Delete(key interface{}) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	if _, ok := m.entries[key]; !ok {
+		return false, nil
+	}
+	delete(m.entries, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	return true, nil
+}
+
+// checkKey fails unless the key is hashable. NaN is a number, but is not equal to itself, so
+// it could never be looked up again.
+func checkKey(key interface{}) error {
+	switch x := key.(type) {
+	case nil, bool, string:
+		return nil
+	case float64:
+		if !math.IsNaN(x) {
+			return nil
+		}
+	}
+	return fmt.Errorf("map keys must be numbers, strings, booleans or nil")
+}
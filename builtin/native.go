@@ -0,0 +1,165 @@
+package builtin
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Native is a function implemented in Go, available to scripts as a global. Variadic natives
+// take at least Arity arguments.
+type Native struct {
+	Name     string
+	Arity    int
+	Variadic bool
+	Call     func([]interface{}) (interface{}, error)
+}
+
+// CheckArity fails unless the native can be called with the given number of arguments.
+func (n *Native) CheckArity(count int) error {
+	if n.Variadic && count < n.Arity {
+		return fmt.Errorf("expected at least %d arguments but got %d", n.Arity, count)
+	}
+	if !n.Variadic && count != n.Arity {
+		return fmt.Errorf("expected %d arguments but got %d", n.Arity, count)
+	}
+	return nil
+}
+
+// Natives are the functions both backends define in the global scope.
+var Natives = []Native{
+	{Name: "clock", Arity: 0, Call: clock},
+	{Name: "len", Arity: 1, Call: length},
+	{Name: "push", Arity: 2, Call: push},
+	{Name: "pop", Arity: 1, Call: pop},
+	{Name: "insert", Arity: 3, Call: insert},
+	{Name: "slice", Arity: 3, Call: slice},
+	{Name: "has", Arity: 2, Call: has},
+	{Name: "delete", Arity: 2, Call: remove},
+	{Name: "keys", Arity: 1, Call: keys},
+	{Name: "values", Arity: 1, Call: values},
+}
+
+func clock(arguments []interface{}) (interface{}, error) {
+	return float64(time.Now().Unix()), nil
+}
+
+func length(arguments []interface{}) (interface{}, error) {
+	switch x := arguments[0].(type) {
+	case *List:
+		return float64(len(x.Elements)), nil
+	case *Map:
+		return float64(x.Len()), nil
+	case string:
+		return float64(utf8.RuneCountInString(x)), nil
+	default:
+		return nil, fmt.Errorf("argument to 'len' must be a list, a map or a string")
+	}
+}
+
+func push(arguments []interface{}) (interface{}, error) {
+	list, err := listArgument("push", arguments)
+	if err != nil {
+		return nil, err
+	}
+	list.Elements = append(list.Elements, arguments[1])
+	return nil, nil
+}
+
+func pop(arguments []interface{}) (interface{}, error) {
+	list, err := listArgument("pop", arguments)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Elements) == 0 {
+		return nil, fmt.Errorf("cannot pop from an empty list")
+	}
+	last := list.Elements[len(list.Elements)-1]
+	list.Elements = list.Elements[:len(list.Elements)-1]
+	return last, nil
+}
+
+func insert(arguments []interface{}) (interface{}, error) {
+	list, err := listArgument("insert", arguments)
+	if err != nil {
+		return nil, err
+	}
+	// Inserting right after the last element is allowed, and appends.
+	i, err := list.checkIndex(arguments[1], len(list.Elements))
+	if err != nil {
+		return nil, err
+	}
+	list.Elements = append(list.Elements, nil)
+	copy(list.Elements[i+1:], list.Elements[i:])
+	list.Elements[i] = arguments[2]
+	return nil, nil
+}
+
+func slice(arguments []interface{}) (interface{}, error) {
+	list, err := listArgument("slice", arguments)
+	if err != nil {
+		return nil, err
+	}
+	start, err := integer(arguments[1], "start of slice")
+	if err != nil {
+		return nil, err
+	}
+	end, err := integer(arguments[2], "end of slice")
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 || end > len(list.Elements) || start > end {
+		return nil, fmt.Errorf("slice [%d, %d) out of bounds for list of length %d", start, end, len(list.Elements))
+	}
+	elements := make([]interface{}, end-start)
+	copy(elements, list.Elements[start:end])
+	return NewList(elements), nil
+}
+
+func has(arguments []interface{}) (interface{}, error) {
+	m, err := mapArgument("has", arguments)
+	if err != nil {
+		return nil, err
+	}
+	return m.Has(arguments[1])
+}
+
+func remove(arguments []interface{}) (interface{}, error) {
+	m, err := mapArgument("delete", arguments)
+	if err != nil {
+		return nil, err
+	}
+	return m.Delete(arguments[1])
+}
+
+func keys(arguments []interface{}) (interface{}, error) {
+	m, err := mapArgument("keys", arguments)
+	if err != nil {
+		return nil, err
+	}
+	return NewList(m.Keys()), nil
+}
+
+func values(arguments []interface{}) (interface{}, error) {
+	m, err := mapArgument("values", arguments)
+	if err != nil {
+		return nil, err
+	}
+	return NewList(m.Values()), nil
+}
+
+func listArgument(name string, arguments []interface{}) (*List, error) {
+	list, ok := arguments[0].(*List)
+	if !ok {
+		return nil, fmt.Errorf("first argument to '%s' must be a list", name)
+	}
+	return list, nil
+}
+
+func mapArgument(name string, arguments []interface{}) (*Map, error) {
+	m, ok := arguments[0].(*Map)
+	if !ok {
+		return nil, fmt.Errorf("first argument to '%s' must be a map", name)
+	}
+	return m, nil
+}
@@ -0,0 +1,57 @@
+package builtin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Streams are the standard streams of a script: print writes to Stdout, and natives like
+// readLine read from Stdin. Stderr is where the host reports errors.
+type Streams struct {
+	Stdin  *bufio.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewStreams wraps stdin in a buffered reader, unless it already is one, so that it can be
+// shared with whatever else reads from the same source.
+func NewStreams(stdin io.Reader, stdout io.Writer, stderr io.Writer) Streams {
+	return Streams{Stdin: bufio.NewReader(stdin), Stdout: stdout, Stderr: stderr}
+}
+
+func StandardStreams() Streams {
+	return NewStreams(os.Stdin, os.Stdout, os.Stderr)
+}
+
+// Natives returns the natives using the streams. They always use the current streams, so
+// that these can be replaced after the natives are defined.
+func (s *Streams) Natives() []Native {
+	return []Native{
+		{Name: "readLine", Arity: 0, Call: func(arguments []interface{}) (interface{}, error) {
+			return s.readLine()
+		}},
+		{Name: "input", Arity: 1, Call: func(arguments []interface{}) (interface{}, error) {
+			if _, err := fmt.Fprint(s.Stdout, arguments[0]); err != nil {
+				return nil, err
+			}
+			return s.readLine()
+		}},
+	}
+}
+
+// readLine reads a line from stdin, without its line ending. It returns nil at the end of the input.
+func (s *Streams) readLine() (interface{}, error) {
+	line, err := s.Stdin.ReadString('\n')
+	if err == io.EOF {
+		if line == "" {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
@@ -0,0 +1,52 @@
+package builtin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Frame is a call that was in progress when a runtime error happened: the function being
+// called, or "script" for top-level code, and the line it had reached.
+type Frame struct {
+	Function string
+	Line     int
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("[line %d] in %s", f.Line, f.Function)
+}
+
+// FunctionName returns how a function appears in traces, given its name.
+func FunctionName(name string) string {
+	if name == "" {
+		return "<fn>"
+	}
+	return name
+}
+
+// maxRepeatedFrames is how many identical frames in a row traces show before summing up the
+// rest, so that deep recursion does not bury the error under a thousand lines.
+const maxRepeatedFrames = 3
+
+// TraceNote returns the note describing a trace in diagnostics. There is none for traces with
+// a single frame, since the location of the error already says as much.
+func TraceNote(trace []Frame) string {
+	if len(trace) <= 1 {
+		return ""
+	}
+	lines := []string{"stack trace:"}
+	for start := 0; start < len(trace); {
+		end := start + 1
+		for end < len(trace) && trace[end] == trace[start] {
+			end++
+		}
+		for _, frame := range trace[start:min(end, start+maxRepeatedFrames)] {
+			lines = append(lines, frame.String())
+		}
+		if repeated := end - start - maxRepeatedFrames; repeated > 0 {
+			lines = append(lines, fmt.Sprintf("... repeated %d more times", repeated))
+		}
+		start = end
+	}
+	return strings.Join(lines, "\n")
+}
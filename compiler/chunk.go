@@ -0,0 +1,285 @@
+package compiler
+
+import (
+	"fmt"
+	"lox/token"
+	"strings"
+)
+
+type OpCode byte
+
+const (
+	CONSTANT OpCode = iota
+	NIL
+	TRUE
+	FALSE
+	POP
+	GET_LOCAL
+	SET_LOCAL
+	GET_GLOBAL
+	DEFINE_GLOBAL
+	SET_GLOBAL
+	GET_UPVALUE
+	SET_UPVALUE
+	GET_PROPERTY
+	SET_PROPERTY
+	GET_SUPER
+	BUILD_LIST
+	BUILD_MAP
+	GET_INDEX
+	SET_INDEX
+	EQUAL
+	NOT_EQUAL
+	GREATER
+	GREATER_EQUAL
+	LESS
+	LESS_EQUAL
+	ADD
+	SUBTRACT
+	MULTIPLY
+	DIVIDE
+	NOT
+	NEGATE
+	PRINT
+	ASSERT
+	JUMP
+	JUMP_IF_FALSE
+	LOOP
+	TRY
+	FINALLY
+	END_TRY
+	THROW
+	RETHROW
+	CALL
+	INVOKE
+	SUPER_INVOKE
+	CLOSURE
+	CLOSE_UPVALUE
+	RETURN
+	CLASS
+	INHERIT
+	METHOD
+)
+
+func (op OpCode) String() string {
+	switch op {
+	case CONSTANT:
+		return "CONSTANT"
+	case NIL:
+		return "NIL"
+	case TRUE:
+		return "TRUE"
+	case FALSE:
+		return "FALSE"
+	case POP:
+		return "POP"
+	case GET_LOCAL:
+		return "GET_LOCAL"
+	case SET_LOCAL:
+		return "SET_LOCAL"
+	case GET_GLOBAL:
+		return "GET_GLOBAL"
+	case DEFINE_GLOBAL:
+		return "DEFINE_GLOBAL"
+	case SET_GLOBAL:
+		return "SET_GLOBAL"
+	case GET_UPVALUE:
+		return "GET_UPVALUE"
+	case SET_UPVALUE:
+		return "SET_UPVALUE"
+	case GET_PROPERTY:
+		return "GET_PROPERTY"
+	case SET_PROPERTY:
+		return "SET_PROPERTY"
+	case GET_SUPER:
+		return "GET_SUPER"
+	case BUILD_LIST:
+		return "BUILD_LIST"
+	case BUILD_MAP:
+		return "BUILD_MAP"
+	case GET_INDEX:
+		return "GET_INDEX"
+	case SET_INDEX:
+		return "SET_INDEX"
+	case EQUAL:
+		return "EQUAL"
+	case NOT_EQUAL:
+		return "NOT_EQUAL"
+	case GREATER:
+		return "GREATER"
+	case GREATER_EQUAL:
+		return "GREATER_EQUAL"
+	case LESS:
+		return "LESS"
+	case LESS_EQUAL:
+		return "LESS_EQUAL"
+	case ADD:
+		return "ADD"
+	case SUBTRACT:
+		return "SUBTRACT"
+	case MULTIPLY:
+		return "MULTIPLY"
+	case DIVIDE:
+		return "DIVIDE"
+	case NOT:
+		return "NOT"
+	case NEGATE:
+		return "NEGATE"
+	case PRINT:
+		return "PRINT"
+	case ASSERT:
+		return "ASSERT"
+	case JUMP:
+		return "JUMP"
+	case JUMP_IF_FALSE:
+		return "JUMP_IF_FALSE"
+	case LOOP:
+		return "LOOP"
+	case TRY:
+		return "TRY"
+	case FINALLY:
+		return "FINALLY"
+	case END_TRY:
+		return "END_TRY"
+	case THROW:
+		return "THROW"
+	case RETHROW:
+		return "RETHROW"
+	case CALL:
+		return "CALL"
+	case INVOKE:
+		return "INVOKE"
+	case SUPER_INVOKE:
+		return "SUPER_INVOKE"
+	case CLOSURE:
+		return "CLOSURE"
+	case CLOSE_UPVALUE:
+		return "CLOSE_UPVALUE"
+	case RETURN:
+		return "RETURN"
+	case CLASS:
+		return "CLASS"
+	case INHERIT:
+		return "INHERIT"
+	case METHOD:
+		return "METHOD"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Chunk is a sequence of instructions, each made of a one-byte opcode followed by its operands.
+// Constant indexes and jump offsets take two bytes (big endian), every other operand takes one.
+// Each byte is paired with the span of the source it was compiled from.
+type Chunk struct {
+	Code      []byte
+	Spans     []token.Span
+	Constants []interface{}
+	indexes   map[interface{}]int
+}
+
+func (c *Chunk) write(b byte, span token.Span) {
+	c.Code = append(c.Code, b)
+	c.Spans = append(c.Spans, span)
+}
+
+// Line returns the line of the source the byte at the given offset was compiled from.
+func (c *Chunk) Line(offset int) int {
+	return c.Spans[offset].Start.Line
+}
+
+func (c *Chunk) addConstant(value interface{}) int {
+	if index, ok := c.indexes[value]; ok {
+		return index
+	}
+	if c.indexes == nil {
+		c.indexes = make(map[interface{}]int)
+	}
+	c.Constants = append(c.Constants, value)
+	c.indexes[value] = len(c.Constants) - 1
+	return len(c.Constants) - 1
+}
+
+// Function is the compiled form of a function declaration or expression, or of a top-level statement.
+type Function struct {
+	Name         string
+	Arity        int
+	UpvalueCount int
+	Chunk        Chunk
+	script       bool
+}
+
+func (f *Function) String() string {
+	if f.script {
+		return "<script>"
+	}
+	if f.Name == "" {
+		return "<fn>"
+	}
+	return fmt.Sprintf("<fn %s>", f.Name)
+}
+
+// Disassemble renders the instructions of the function, followed by those of any function nested in it.
+func (f *Function) Disassemble() string {
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("== %v ==\n", f))
+	c := &f.Chunk
+	for offset := 0; offset < len(c.Code); {
+		builder.WriteString(fmt.Sprintf("%04d ", offset))
+		if offset > 0 && c.Line(offset) == c.Line(offset-1) {
+			builder.WriteString("   | ")
+		} else {
+			builder.WriteString(fmt.Sprintf("%4d ", c.Line(offset)))
+		}
+		op := OpCode(c.Code[offset])
+		builder.WriteString(fmt.Sprintf("%-16s", op))
+		switch op {
+		case CONSTANT, GET_GLOBAL, DEFINE_GLOBAL, SET_GLOBAL, GET_PROPERTY, SET_PROPERTY, GET_SUPER, CLASS, METHOD:
+			index := c.readShort(offset + 1)
+			builder.WriteString(fmt.Sprintf(" %4d '%v'", index, c.Constants[index]))
+			offset += 3
+		case GET_LOCAL, SET_LOCAL, GET_UPVALUE, SET_UPVALUE, CALL:
+			builder.WriteString(fmt.Sprintf(" %4d", c.Code[offset+1]))
+			offset += 2
+		case BUILD_LIST, BUILD_MAP:
+			builder.WriteString(fmt.Sprintf(" %4d", c.readShort(offset+1)))
+			offset += 3
+		case JUMP, JUMP_IF_FALSE, TRY, FINALLY:
+			builder.WriteString(fmt.Sprintf(" %4d -> %d", offset, offset+3+c.readShort(offset+1)))
+			offset += 3
+		case LOOP:
+			builder.WriteString(fmt.Sprintf(" %4d -> %d", offset, offset+3-c.readShort(offset+1)))
+			offset += 3
+		case INVOKE, SUPER_INVOKE:
+			index := c.readShort(offset + 1)
+			builder.WriteString(fmt.Sprintf(" (%d args) %4d '%v'", c.Code[offset+3], index, c.Constants[index]))
+			offset += 4
+		case CLOSURE:
+			index := c.readShort(offset + 1)
+			function := c.Constants[index].(*Function)
+			builder.WriteString(fmt.Sprintf(" %4d %v", index, function))
+			offset += 3
+			for i := 0; i < function.UpvalueCount; i++ {
+				kind := "upvalue"
+				if c.Code[offset] == 1 {
+					kind = "local"
+				}
+				builder.WriteString(fmt.Sprintf("\n%04d    |                     %s %d", offset, kind, c.Code[offset+1]))
+				offset += 2
+			}
+		default:
+			offset += 1
+		}
+		builder.WriteRune('\n')
+	}
+	for _, constant := range c.Constants {
+		if function, ok := constant.(*Function); ok {
+			builder.WriteString(function.Disassemble())
+		}
+	}
+	return builder.String()
+}
+
+func (c *Chunk) readShort(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
@@ -0,0 +1,854 @@
+package compiler
+
+import (
+	"fmt"
+	"lox/ast"
+	"lox/diagnostics"
+	"lox/token"
+)
+
+type CompileError struct {
+	span    token.Span
+	message string
+}
+
+func (e CompileError) Error() string {
+	return fmt.Sprintf("compile error on line %d: %s", e.span.Start.Line, e.message)
+}
+
+func (e CompileError) Line() int {
+	return e.span.Start.Line
+}
+
+func (e CompileError) Column() int {
+	return e.span.Start.Column
+}
+
+func (e CompileError) Code() string {
+	return diagnostics.CodeCompile
+}
+
+func (e CompileError) Diagnostic() diagnostics.Diagnostic {
+	return diagnostics.Diagnostic{Kind: "compile", Code: e.Code(), Message: e.message, Span: e.span}
+}
+
+type functionType int
+
+const (
+	script functionType = iota
+	function
+	method
+	initializer
+)
+
+type local struct {
+	name     string
+	depth    int
+	captured bool
+}
+
+type upvalue struct {
+	index   byte
+	isLocal bool
+}
+
+// loop tracks the innermost loop being compiled, so that break and continue know which locals
+// to discard, which try statements they leave and where to jump.
+type loop struct {
+	enclosing  *loop
+	scopeDepth int
+	try        *tryStatement
+	breaks     []int
+	continues  []int
+}
+
+// tryStatement tracks a try statement being compiled, so that jumping out of it with break,
+// continue or return can remove the handlers it installed and run its finally block.
+type tryStatement struct {
+	enclosing *tryStatement
+	finally   *ast.BlockStmt
+	handlers  int
+}
+
+type classCompiler struct {
+	enclosing     *classCompiler
+	hasSuperclass bool
+}
+
+// compiler holds the state needed to compile a single function. Nested functions are compiled
+// by compilers chained through enclosing, which is what allows resolving upvalues.
+type compiler struct {
+	enclosing  *compiler
+	function   *Function
+	kind       functionType
+	locals     []local
+	upvalues   []upvalue
+	scopeDepth int
+	class      *classCompiler
+	loop       *loop
+	try        *tryStatement
+	span       token.Span
+}
+
+func newCompiler(enclosing *compiler, kind functionType, name string) *compiler {
+	c := &compiler{enclosing: enclosing, function: &Function{Name: name}, kind: kind}
+	if enclosing != nil {
+		c.class = enclosing.class
+		c.span = enclosing.span
+	}
+	// Slot zero holds the callee, or the receiver when compiling a method.
+	receiver := ""
+	if kind == method || kind == initializer {
+		receiver = "this"
+	}
+	c.locals = append(c.locals, local{name: receiver})
+	return c
+}
+
+// Compile turns a top-level statement into a function with no parameters. Running the
+// function yields the value of the statement if it is an expression, nil otherwise.
+// Statements are expected to have gone through the resolver already.
+func Compile(stmt ast.Stmt) (fn *Function, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if ce, ok := e.(*CompileError); ok {
+				err = ce
+			} else {
+				panic(fmt.Errorf("unexpected error during compilation: %v", e))
+			}
+		}
+	}()
+	c := newCompiler(nil, script, "")
+	c.function.script = true
+	if exprStmt, ok := stmt.(*ast.ExprStmt); ok {
+		c.expression(exprStmt.Expression)
+	} else {
+		c.statement(stmt)
+		c.emit(NIL)
+	}
+	c.emit(RETURN)
+	return c.function, nil
+}
+
+func (c *compiler) statement(stmt ast.Stmt) {
+	stmt.AcceptStmt(c)
+}
+
+func (c *compiler) expression(expr ast.Expr) {
+	expr.AcceptExpr(c)
+}
+
+func (c *compiler) error(message string) {
+	panic(&CompileError{span: c.span, message: message})
+}
+
+func (c *compiler) emit(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *compiler) emitByte(b byte) {
+	c.function.Chunk.write(b, c.span)
+}
+
+func (c *compiler) emitShort(n int) {
+	c.emitByte(byte(n >> 8))
+	c.emitByte(byte(n))
+}
+
+func (c *compiler) emitConstant(value interface{}) {
+	c.emit(CONSTANT)
+	c.emitShort(c.makeConstant(value))
+}
+
+func (c *compiler) makeConstant(value interface{}) int {
+	index := c.function.Chunk.addConstant(value)
+	if index > 0xffff {
+		c.error("too many constants in one chunk")
+	}
+	return index
+}
+
+func (c *compiler) emitJump(op OpCode) int {
+	c.emit(op)
+	c.emitShort(0xffff)
+	return len(c.function.Chunk.Code) - 2
+}
+
+func (c *compiler) patchJump(offset int) {
+	jump := len(c.function.Chunk.Code) - offset - 2
+	if jump > 0xffff {
+		c.error("too much code to jump over")
+	}
+	c.function.Chunk.Code[offset] = byte(jump >> 8)
+	c.function.Chunk.Code[offset+1] = byte(jump)
+}
+
+func (c *compiler) emitLoop(start int) {
+	c.emit(LOOP)
+	offset := len(c.function.Chunk.Code) - start + 2
+	if offset > 0xffff {
+		c.error("loop body too large")
+	}
+	c.emitShort(offset)
+}
+
+func (c *compiler) emitReturn() {
+	c.emitReturnValue()
+	c.emit(RETURN)
+}
+
+func (c *compiler) emitReturnValue() {
+	if c.kind == initializer {
+		c.emit(GET_LOCAL)
+		c.emitByte(0)
+	} else {
+		c.emit(NIL)
+	}
+}
+
+func (c *compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *compiler) endScope() {
+	c.scopeDepth--
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		if c.locals[len(c.locals)-1].captured {
+			c.emit(CLOSE_UPVALUE)
+		} else {
+			c.emit(POP)
+		}
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+func (c *compiler) addLocal(name string) {
+	if len(c.locals) == 256 {
+		c.error("too many local variables in function")
+	}
+	c.locals = append(c.locals, local{name: name, depth: -1})
+}
+
+func (c *compiler) declareVariable(name token.Token) {
+	c.span = name.Span
+	if c.scopeDepth > 0 {
+		c.addLocal(name.Lexeme)
+	}
+}
+
+func (c *compiler) markInitialized() {
+	if c.scopeDepth > 0 {
+		c.locals[len(c.locals)-1].depth = c.scopeDepth
+	}
+}
+
+func (c *compiler) defineVariable(name token.Token) {
+	if c.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.emit(DEFINE_GLOBAL)
+	c.emitShort(c.makeConstant(name.Lexeme))
+}
+
+func (c *compiler) resolveLocal(name string) int {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name {
+			if c.locals[i].depth == -1 {
+				c.error("cannot read local variable in its own initializer")
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *compiler) resolveUpvalue(name string) int {
+	if c.enclosing == nil {
+		return -1
+	}
+	if local := c.enclosing.resolveLocal(name); local != -1 {
+		c.enclosing.locals[local].captured = true
+		return c.addUpvalue(byte(local), true)
+	}
+	if upvalue := c.enclosing.resolveUpvalue(name); upvalue != -1 {
+		return c.addUpvalue(byte(upvalue), false)
+	}
+	return -1
+}
+
+func (c *compiler) addUpvalue(index byte, isLocal bool) int {
+	for i, upvalue := range c.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return i
+		}
+	}
+	if len(c.upvalues) == 256 {
+		c.error("too many closure variables in function")
+	}
+	c.upvalues = append(c.upvalues, upvalue{index: index, isLocal: isLocal})
+	c.function.UpvalueCount = len(c.upvalues)
+	return len(c.upvalues) - 1
+}
+
+// variable emits the instruction to read (or, if assign is true, to write) the variable
+// with the given name, looking it up first among locals, then upvalues and finally globals.
+func (c *compiler) variable(name string, assign bool) {
+	var op OpCode
+	if arg := c.resolveLocal(name); arg != -1 {
+		op = GET_LOCAL
+		if assign {
+			op = SET_LOCAL
+		}
+		c.emit(op)
+		c.emitByte(byte(arg))
+	} else if arg := c.resolveUpvalue(name); arg != -1 {
+		op = GET_UPVALUE
+		if assign {
+			op = SET_UPVALUE
+		}
+		c.emit(op)
+		c.emitByte(byte(arg))
+	} else {
+		op = GET_GLOBAL
+		if assign {
+			op = SET_GLOBAL
+		}
+		c.emit(op)
+		c.emitShort(c.makeConstant(name))
+	}
+}
+
+func (c *compiler) compileFunction(name string, params []token.Token, body *ast.BlockStmt, kind functionType) {
+	fc := newCompiler(c, kind, name)
+	fc.beginScope()
+	for _, param := range params {
+		fc.function.Arity++
+		if fc.function.Arity > 255 {
+			fc.error("cannot have more than 255 parameters")
+		}
+		fc.declareVariable(param)
+		fc.defineVariable(param)
+	}
+	for _, stmt := range body.Statements {
+		fc.statement(stmt)
+	}
+	fc.emitReturn()
+	c.emit(CLOSURE)
+	c.emitShort(c.makeConstant(fc.function))
+	for _, upvalue := range fc.upvalues {
+		if upvalue.isLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(upvalue.index)
+	}
+}
+
+func (c *compiler) VisitVarDeclStmt(stmt *ast.VarDeclStmt) interface{} {
+	c.declareVariable(stmt.Name)
+	if stmt.Initializer != nil && *stmt.Initializer != nil {
+		c.expression(*stmt.Initializer)
+	} else {
+		c.emit(NIL)
+	}
+	c.defineVariable(stmt.Name)
+	return nil
+}
+
+func (c *compiler) VisitFunDeclStmt(stmt *ast.FunDeclStmt) interface{} {
+	c.declareVariable(stmt.Name)
+	// Functions can refer to themselves, so they are initialized before the body is compiled.
+	c.markInitialized()
+	c.compileFunction(stmt.Name.Lexeme, stmt.Params, stmt.Body, function)
+	c.defineVariable(stmt.Name)
+	return nil
+}
+
+func (c *compiler) VisitClassStmt(stmt *ast.ClassStmt) interface{} {
+	c.declareVariable(stmt.Name)
+	c.emit(CLASS)
+	c.emitShort(c.makeConstant(stmt.Name.Lexeme))
+	c.defineVariable(stmt.Name)
+
+	class := &classCompiler{enclosing: c.class}
+	c.class = class
+	defer func() {
+		c.class = class.enclosing
+	}()
+
+	if stmt.Superclass != nil {
+		c.VisitVarExpr(stmt.Superclass)
+		c.beginScope()
+		c.addLocal("super")
+		c.markInitialized()
+		c.variable(stmt.Name.Lexeme, false)
+		c.emit(INHERIT)
+		class.hasSuperclass = true
+	}
+
+	c.variable(stmt.Name.Lexeme, false)
+	for _, m := range stmt.Methods {
+		c.span = m.Name.Span
+		kind := method
+		if m.Name.Lexeme == "init" {
+			kind = initializer
+		}
+		c.compileFunction(m.Name.Lexeme, m.Params, m.Body, kind)
+		c.emit(METHOD)
+		c.emitShort(c.makeConstant(m.Name.Lexeme))
+	}
+	c.emit(POP)
+
+	if class.hasSuperclass {
+		c.endScope()
+	}
+	return nil
+}
+
+func (c *compiler) VisitBlockStmt(stmt *ast.BlockStmt) interface{} {
+	c.beginScope()
+	for _, stmt := range stmt.Statements {
+		c.statement(stmt)
+	}
+	c.endScope()
+	return nil
+}
+
+func (c *compiler) VisitExprStmt(stmt *ast.ExprStmt) interface{} {
+	c.expression(stmt.Expression)
+	c.emit(POP)
+	return nil
+}
+
+func (c *compiler) VisitIfStmt(stmt *ast.IfStmt) interface{} {
+	c.expression(stmt.Condition)
+	thenJump := c.emitJump(JUMP_IF_FALSE)
+	c.emit(POP)
+	c.body(*stmt.ThenBranch)
+	elseJump := c.emitJump(JUMP)
+	c.patchJump(thenJump)
+	c.emit(POP)
+	if stmt.ElseBranch != nil {
+		c.body(*stmt.ElseBranch)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+func (c *compiler) VisitAssertStmt(stmt *ast.AssertStmt) interface{} {
+	c.expression(stmt.Expression)
+	c.span = stmt.Keyword.Span
+	c.emit(ASSERT)
+	return nil
+}
+
+func (c *compiler) VisitPrintStmt(stmt *ast.PrintStmt) interface{} {
+	c.expression(stmt.Expression)
+	c.emit(PRINT)
+	return nil
+}
+
+func (c *compiler) VisitWhileStmt(stmt *ast.WhileStmt) interface{} {
+	loopStart := len(c.function.Chunk.Code)
+	c.expression(stmt.Condition)
+	exitJump := c.emitJump(JUMP_IF_FALSE)
+	c.emit(POP)
+	l := c.loopBody(stmt.Body)
+	c.patchJumps(l.continues)
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emit(POP)
+	c.patchJumps(l.breaks)
+	return nil
+}
+
+func (c *compiler) VisitForStmt(stmt *ast.ForStmt) interface{} {
+	c.beginScope()
+	if stmt.Initializer != nil {
+		c.statement(stmt.Initializer)
+	}
+	loopStart := len(c.function.Chunk.Code)
+	exitJump := -1
+	if stmt.Condition != nil {
+		c.expression(stmt.Condition)
+		exitJump = c.emitJump(JUMP_IF_FALSE)
+		c.emit(POP)
+	}
+	l := c.loopBody(stmt.Body)
+	c.patchJumps(l.continues)
+	if stmt.Increment != nil {
+		c.expression(stmt.Increment)
+		c.emit(POP)
+	}
+	c.span = stmt.Keyword.Span
+	c.emitLoop(loopStart)
+	if exitJump != -1 {
+		c.patchJump(exitJump)
+		c.emit(POP)
+	}
+	c.patchJumps(l.breaks)
+	c.endScope()
+	return nil
+}
+
+// loopBody compiles the body of a loop, returning the jumps emitted by the break and continue
+// statements in it, for the caller to patch.
+func (c *compiler) loopBody(body ast.Stmt) *loop {
+	l := &loop{enclosing: c.loop, scopeDepth: c.scopeDepth, try: c.try}
+	c.loop = l
+	c.body(body)
+	c.loop = l.enclosing
+	return l
+}
+
+// body compiles a branch or the body of a loop. A declaration there gets a scope of its own like
+// the resolver gives it, so that it never leaves a local on the stack.
+func (c *compiler) body(body ast.Stmt) {
+	if ast.IsDeclaration(body) {
+		c.beginScope()
+		defer c.endScope()
+	}
+	c.statement(body)
+}
+
+func (c *compiler) patchJumps(offsets []int) {
+	for _, offset := range offsets {
+		c.patchJump(offset)
+	}
+}
+
+// discardLoopLocals pops the locals declared inside the innermost loop, without forgetting about
+// them, since the code following a break or continue is still in their scope. Whether a local
+// is captured is only known at the end of its scope, so they are all closed to be safe.
+func (c *compiler) discardLoopLocals() {
+	for i := len(c.locals) - 1; i >= 0 && c.locals[i].depth > c.loop.scopeDepth; i-- {
+		c.emit(CLOSE_UPVALUE)
+	}
+}
+
+func (c *compiler) VisitBreakStmt(stmt *ast.BreakStmt) interface{} {
+	c.span = stmt.Keyword.Span
+	c.exitTries(c.loop.try)
+	c.discardLoopLocals()
+	c.loop.breaks = append(c.loop.breaks, c.emitJump(JUMP))
+	return nil
+}
+
+func (c *compiler) VisitContinueStmt(stmt *ast.ContinueStmt) interface{} {
+	c.span = stmt.Keyword.Span
+	c.exitTries(c.loop.try)
+	c.discardLoopLocals()
+	c.loop.continues = append(c.loop.continues, c.emitJump(JUMP))
+	return nil
+}
+
+func (c *compiler) VisitReturnStmt(stmt *ast.ReturnStmt) interface{} {
+	c.span = stmt.Keyword.Span
+	if stmt.Value == nil || *stmt.Value == nil {
+		c.emitReturnValue()
+	} else {
+		c.expression(*stmt.Value)
+	}
+	if c.try != nil {
+		// The value is kept in a hidden local while the enclosing finally blocks run.
+		c.addLocal("")
+		c.markInitialized()
+		c.exitTries(nil)
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+	c.emit(RETURN)
+	return nil
+}
+
+func (c *compiler) VisitThrowStmt(stmt *ast.ThrowStmt) interface{} {
+	c.expression(stmt.Value)
+	c.span = stmt.Keyword.Span
+	c.emit(THROW)
+	return nil
+}
+
+// VisitTryStmt installs a handler for the finally block around everything else, and one for
+// the catch block around the body. When a handler is reached, the VM has already removed it,
+// unwound the stack to where it was installed and pushed the thrown value. The finally block
+// is compiled twice: once for when the statement completes, and once for when a value is
+// thrown or an error happens, where it runs with what is unwinding the stack in a hidden local
+// and rethrows it as it was.
+func (c *compiler) VisitTryStmt(stmt *ast.TryStmt) interface{} {
+	t := &tryStatement{enclosing: c.try, finally: stmt.Finally}
+	c.span = stmt.Keyword.Span
+	finallyHandler := -1
+	if stmt.Finally != nil {
+		finallyHandler = c.emitJump(FINALLY)
+		t.handlers++
+	}
+	c.try = t
+	if stmt.Catch != nil {
+		catchHandler := c.emitJump(TRY)
+		t.handlers++
+		c.statement(stmt.Body)
+		c.emit(END_TRY)
+		t.handlers--
+		skip := c.emitJump(JUMP)
+		c.patchJump(catchHandler)
+		c.beginScope()
+		c.declareVariable(stmt.CatchName)
+		c.markInitialized()
+		c.statement(stmt.Catch)
+		c.endScope()
+		c.patchJump(skip)
+	} else {
+		c.statement(stmt.Body)
+	}
+	c.try = t.enclosing
+	if stmt.Finally != nil {
+		c.emit(END_TRY)
+		c.statement(stmt.Finally)
+		end := c.emitJump(JUMP)
+		c.patchJump(finallyHandler)
+		c.beginScope()
+		c.addLocal("")
+		c.markInitialized()
+		c.statement(stmt.Finally)
+		c.emit(GET_LOCAL)
+		c.emitByte(byte(len(c.locals) - 1))
+		c.emit(RETHROW)
+		c.endScope()
+		c.patchJump(end)
+	}
+	return nil
+}
+
+// exitTries removes the handlers of the try statements being jumped out of, innermost first,
+// and runs their finally blocks, stopping at the given one.
+func (c *compiler) exitTries(until *tryStatement) {
+	current := c.try
+	for t := current; t != until; t = t.enclosing {
+		for n := 0; n < t.handlers; n++ {
+			c.emit(END_TRY)
+		}
+		if t.finally != nil {
+			c.try = t.enclosing
+			c.statement(t.finally)
+		}
+	}
+	c.try = current
+}
+
+func (c *compiler) VisitEndStmt(stmt *ast.EndStmt) interface{} {
+	return nil
+}
+
+func (c *compiler) VisitAssignmentExpr(expr *ast.AssignmentExpr) interface{} {
+	c.expression(expr.Value)
+	c.span = expr.Name.Span
+	c.variable(expr.Name.Lexeme, true)
+	return nil
+}
+
+func (c *compiler) VisitBinaryExpr(expr *ast.BinaryExpr) interface{} {
+	c.expression(expr.Left)
+	c.expression(expr.Right)
+	c.span = expr.Operator.Span
+	switch expr.Operator.Type {
+	case token.BANG_EQUAL:
+		c.emit(NOT_EQUAL)
+	case token.EQUAL_EQUAL:
+		c.emit(EQUAL)
+	case token.GREATER:
+		c.emit(GREATER)
+	case token.GREATER_EQUAL:
+		c.emit(GREATER_EQUAL)
+	case token.LESS:
+		c.emit(LESS)
+	case token.LESS_EQUAL:
+		c.emit(LESS_EQUAL)
+	case token.PLUS:
+		c.emit(ADD)
+	case token.MINUS:
+		c.emit(SUBTRACT)
+	case token.STAR:
+		c.emit(MULTIPLY)
+	case token.SLASH:
+		c.emit(DIVIDE)
+	default:
+		panic(fmt.Errorf("unexpected operator: %v", expr.Operator))
+	}
+	return nil
+}
+
+func (c *compiler) arguments(arguments []ast.Expr) {
+	for _, arg := range arguments {
+		c.expression(arg)
+	}
+}
+
+func (c *compiler) VisitCallExpr(expr *ast.CallExpr) interface{} {
+	switch callee := expr.Callee.(type) {
+	case *ast.GetExpr:
+		c.expression(callee.Object)
+		c.arguments(expr.Arguments)
+		c.span = ast.SpanOf(expr)
+		c.emit(INVOKE)
+		c.emitShort(c.makeConstant(callee.Name.Lexeme))
+		c.emitByte(byte(len(expr.Arguments)))
+	case *ast.SuperExpr:
+		c.span = callee.Keyword.Span
+		c.variable("this", false)
+		c.arguments(expr.Arguments)
+		c.variable("super", false)
+		c.span = ast.SpanOf(expr)
+		c.emit(SUPER_INVOKE)
+		c.emitShort(c.makeConstant(callee.Method.Lexeme))
+		c.emitByte(byte(len(expr.Arguments)))
+	default:
+		c.expression(expr.Callee)
+		c.arguments(expr.Arguments)
+		c.span = ast.SpanOf(expr)
+		c.emit(CALL)
+		c.emitByte(byte(len(expr.Arguments)))
+	}
+	return nil
+}
+
+func (c *compiler) VisitFunctionExpr(expr *ast.FunctionExpr) interface{} {
+	c.span = expr.Keyword.Span
+	c.compileFunction("", expr.Params, expr.Body, function)
+	return nil
+}
+
+func (c *compiler) VisitGetExpr(expr *ast.GetExpr) interface{} {
+	c.expression(expr.Object)
+	c.span = expr.Name.Span
+	c.emit(GET_PROPERTY)
+	c.emitShort(c.makeConstant(expr.Name.Lexeme))
+	return nil
+}
+
+func (c *compiler) VisitSetExpr(expr *ast.SetExpr) interface{} {
+	c.expression(expr.Object)
+	c.expression(expr.Value)
+	c.span = expr.Name.Span
+	c.emit(SET_PROPERTY)
+	c.emitShort(c.makeConstant(expr.Name.Lexeme))
+	return nil
+}
+
+func (c *compiler) VisitIndexExpr(expr *ast.IndexExpr) interface{} {
+	c.expression(expr.Object)
+	c.expression(expr.Index)
+	c.span = expr.Bracket.Span
+	c.emit(GET_INDEX)
+	return nil
+}
+
+func (c *compiler) VisitSetIndexExpr(expr *ast.SetIndexExpr) interface{} {
+	c.expression(expr.Object)
+	c.expression(expr.Index)
+	c.expression(expr.Value)
+	c.span = expr.Bracket.Span
+	c.emit(SET_INDEX)
+	return nil
+}
+
+func (c *compiler) VisitListExpr(expr *ast.ListExpr) interface{} {
+	if len(expr.Elements) > 0xffff {
+		c.span = expr.Bracket.Span
+		c.error("too many elements in list literal")
+	}
+	for _, element := range expr.Elements {
+		c.expression(element)
+	}
+	c.span = expr.Bracket.Span
+	c.emit(BUILD_LIST)
+	c.emitShort(len(expr.Elements))
+	return nil
+}
+
+func (c *compiler) VisitMapExpr(expr *ast.MapExpr) interface{} {
+	if len(expr.Keys) > 0xffff {
+		c.span = expr.Brace.Span
+		c.error("too many entries in map literal")
+	}
+	for index, key := range expr.Keys {
+		c.expression(key)
+		c.expression(expr.Values[index])
+	}
+	c.span = expr.Brace.Span
+	c.emit(BUILD_MAP)
+	c.emitShort(len(expr.Keys))
+	return nil
+}
+
+func (c *compiler) VisitGroupingExpr(expr *ast.GroupingExpr) interface{} {
+	c.expression(expr.Expression)
+	return nil
+}
+
+func (c *compiler) VisitLiteralExpr(expr *ast.LiteralExpr) interface{} {
+	switch expr.Value {
+	case nil:
+		c.emit(NIL)
+	case true:
+		c.emit(TRUE)
+	case false:
+		c.emit(FALSE)
+	default:
+		c.emitConstant(expr.Value)
+	}
+	return nil
+}
+
+func (c *compiler) VisitLogicalExpr(expr *ast.LogicalExpr) interface{} {
+	c.expression(expr.Left)
+	if expr.Operator.Type == token.OR {
+		elseJump := c.emitJump(JUMP_IF_FALSE)
+		endJump := c.emitJump(JUMP)
+		c.patchJump(elseJump)
+		c.emit(POP)
+		c.expression(expr.Right)
+		c.patchJump(endJump)
+	} else {
+		endJump := c.emitJump(JUMP_IF_FALSE)
+		c.emit(POP)
+		c.expression(expr.Right)
+		c.patchJump(endJump)
+	}
+	return nil
+}
+
+func (c *compiler) VisitUnaryExpr(expr *ast.UnaryExpr) interface{} {
+	c.expression(expr.Right)
+	c.span = expr.Operator.Span
+	switch expr.Operator.Type {
+	case token.MINUS:
+		c.emit(NEGATE)
+	case token.BANG:
+		c.emit(NOT)
+	default:
+		panic(fmt.Errorf("unexpected operator: %v", expr.Operator))
+	}
+	return nil
+}
+
+func (c *compiler) VisitVarExpr(expr *ast.VarExpr) interface{} {
+	c.span = expr.Name.Span
+	c.variable(expr.Name.Lexeme, false)
+	return nil
+}
+
+func (c *compiler) VisitThisExpr(expr *ast.ThisExpr) interface{} {
+	c.span = expr.Keyword.Span
+	c.variable("this", false)
+	return nil
+}
+
+func (c *compiler) VisitSuperExpr(expr *ast.SuperExpr) interface{} {
+	c.span = expr.Keyword.Span
+	c.variable("this", false)
+	c.variable("super", false)
+	c.emit(GET_SUPER)
+	c.emitShort(c.makeConstant(expr.Method.Lexeme))
+	return nil
+}
@@ -0,0 +1,135 @@
+package compiler
+
+import (
+	"bufio"
+	"lox/parser"
+	"lox/scanner"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCompilerExpression(t *testing.T) {
+	expectCode(t, "1 + 2 * 3;", CONSTANT, CONSTANT, CONSTANT, MULTIPLY, ADD, RETURN)
+}
+
+func TestCompilerGlobal(t *testing.T) {
+	expectCode(t, "var x = 1;", CONSTANT, DEFINE_GLOBAL, NIL, RETURN)
+	expectCode(t, "x = 1;", CONSTANT, SET_GLOBAL, RETURN)
+}
+
+func TestCompilerLocal(t *testing.T) {
+	expectCode(t, "{ var x = 1; print x; }", CONSTANT, GET_LOCAL, PRINT, POP, NIL, RETURN)
+}
+
+func TestCompilerIf(t *testing.T) {
+	expectCode(t, "if (true) print 1;", TRUE, JUMP_IF_FALSE, POP, CONSTANT, PRINT, JUMP, POP, NIL, RETURN)
+}
+
+func TestCompilerConstantsAreShared(t *testing.T) {
+	f := compile(t, "1 + 1;")
+	if len(f.Chunk.Constants) != 1 {
+		t.Errorf("expected 1 constant, got %d", len(f.Chunk.Constants))
+	}
+}
+
+func TestCompilerClosure(t *testing.T) {
+	f := compile(t, "{ var x = 1; fun f() { return x; } }")
+	expectDisassembly(t, f, "CLOSURE .*\n.* local 1\n")
+	expectDisassembly(t, f, "== <fn f> ==\n.*GET_UPVALUE +0\n")
+	expectDisassembly(t, f, "CLOSE_UPVALUE")
+}
+
+func TestCompilerList(t *testing.T) {
+	expectCode(t, "[1, 2][0];", CONSTANT, CONSTANT, BUILD_LIST, CONSTANT, GET_INDEX, RETURN)
+	expectDisassembly(t, compile(t, "[1, 2, 3];"), "BUILD_LIST +3\n")
+}
+
+func TestCompilerMap(t *testing.T) {
+	expectCode(t, "var m = {1: 2};", CONSTANT, CONSTANT, BUILD_MAP, DEFINE_GLOBAL, NIL, RETURN)
+	expectDisassembly(t, compile(t, "({1: 2, 3: 4});"), "BUILD_MAP +2\n")
+}
+
+func TestCompilerBreak(t *testing.T) {
+	expectCode(t, "while (true) { var a; break; }",
+		TRUE, JUMP_IF_FALSE, POP, NIL, CLOSE_UPVALUE, JUMP, POP, LOOP, POP, NIL, RETURN)
+}
+
+func TestCompilerTry(t *testing.T) {
+	expectCode(t, "try { throw 1; } catch (e) { print e; }",
+		TRY, CONSTANT, THROW, END_TRY, JUMP, GET_LOCAL, PRINT, POP, NIL, RETURN)
+	expectCode(t, "try {} finally { print 1; }",
+		FINALLY, END_TRY, CONSTANT, PRINT, JUMP, CONSTANT, PRINT, GET_LOCAL, RETHROW, POP, NIL, RETURN)
+}
+
+func TestCompilerTooManyLocals(t *testing.T) {
+	src := strings.Builder{}
+	src.WriteString("{")
+	for i := 0; i < 256; i++ {
+		src.WriteString("var a" + strings.Repeat("a", i) + ";")
+	}
+	src.WriteString("}")
+	expectCompileError(t, src.String(), "too many local variables in function")
+}
+
+func compile(t *testing.T, src string) *Function {
+	t.Helper()
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
+	stmt, err := p.NextStatement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Compile(stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func expectCode(t *testing.T, src string, expected ...OpCode) {
+	t.Helper()
+	f := compile(t, src)
+	var ops []OpCode
+	for _, line := range strings.Split(strings.TrimSpace(f.Disassemble()), "\n")[1:] {
+		fields := strings.Fields(line)
+		ops = append(ops, opCode(fields[2]))
+	}
+	if len(ops) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ops)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, ops)
+		}
+	}
+}
+
+func opCode(name string) OpCode {
+	for op := CONSTANT; op <= METHOD; op++ {
+		if op.String() == name {
+			return op
+		}
+	}
+	panic("unknown opcode " + name)
+}
+
+func expectDisassembly(t *testing.T, f *Function, regex string) {
+	t.Helper()
+	if !regexp.MustCompile(regex).MatchString(f.Disassemble()) {
+		t.Errorf("expected disassembly to match '%s', got:\n%s", regex, f.Disassemble())
+	}
+}
+
+func expectCompileError(t *testing.T, src string, regex string) {
+	t.Helper()
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
+	stmt, err := p.NextStatement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Compile(stmt); err == nil {
+		t.Errorf("expected compile error matching '%s', got none", regex)
+	} else if !regexp.MustCompile(regex).MatchString(err.Error()) {
+		t.Errorf("expected compile error matching '%s', got '%v'", regex, err)
+	}
+}
@@ -0,0 +1,194 @@
+package lox
+
+import (
+	"fmt"
+	"lox/builtin"
+	"reflect"
+)
+
+var (
+	valueType = reflect.TypeOf(Value{})
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// toLox converts a Go value to the Lox value closest to it: integers and floats become
+// numbers, slices and arrays become lists, maps become maps. A Value is passed through as is.
+func toLox(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return toLoxValue(reflect.ValueOf(value))
+}
+
+func toLoxValue(v reflect.Value) (interface{}, error) {
+	if v.Type() == valueType {
+		return v.Interface().(Value).value, nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Kind() == reflect.Interface {
+			return toLoxValue(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		elements := make([]interface{}, v.Len())
+		for i := range elements {
+			element, err := toLoxValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return builtin.NewList(elements), nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		m := builtin.NewMap()
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toLoxValue(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			value, err := toLoxValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			if err := m.Set(key, value); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot use a value of type %v in Lox", v.Type())
+}
+
+// fromLox converts a Lox value to the given Go type, failing if the value does not fit it.
+// Empty interfaces get nil, a bool, a float64, a string, or a slice or map of those.
+func fromLox(value interface{}, t reflect.Type) (reflect.Value, error) {
+	if t == valueType {
+		return reflect.ValueOf(Value{value}), nil
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			v.SetBool(b)
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := value.(float64); ok && n == float64(int64(n)) && !v.OverflowInt(int64(n)) {
+			v.SetInt(int64(n))
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, ok := value.(float64); ok && n >= 0 && n == float64(uint64(n)) && !v.OverflowUint(uint64(n)) {
+			v.SetUint(uint64(n))
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := value.(float64); ok {
+			v.SetFloat(n)
+			return v, nil
+		}
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			v.SetString(s)
+			return v, nil
+		}
+	case reflect.Slice:
+		if list, ok := value.(*builtin.List); ok {
+			v.Set(reflect.MakeSlice(t, len(list.Elements), len(list.Elements)))
+			for i, element := range list.Elements {
+				e, err := fromLox(element, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.Index(i).Set(e)
+			}
+			return v, nil
+		}
+	case reflect.Map:
+		if m, ok := value.(*builtin.Map); ok {
+			v.Set(reflect.MakeMapWithSize(t, m.Len()))
+			for _, key := range m.Keys() {
+				k, err := fromLox(key, t.Key())
+				if err != nil {
+					return v, err
+				}
+				element, _ := m.Get(key)
+				e, err := fromLox(element, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.SetMapIndex(k, e)
+			}
+			return v, nil
+		}
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return fromLoxToAny(value, t)
+		}
+	}
+	return v, fmt.Errorf("expected %s, got %s", describe(t), builtin.Repr(value))
+}
+
+func fromLoxToAny(value interface{}, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch x := value.(type) {
+	case nil:
+		return v, nil
+	case *builtin.List:
+		slice, err := fromLox(x, reflect.TypeOf([]interface{}{}))
+		if err != nil {
+			return v, err
+		}
+		v.Set(slice)
+	case *builtin.Map:
+		m, err := fromLox(x, reflect.TypeOf(map[interface{}]interface{}{}))
+		if err != nil {
+			return v, err
+		}
+		v.Set(m)
+	default:
+		v.Set(reflect.ValueOf(value))
+	}
+	return v, nil
+}
+
+func describe(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Map:
+		return "a map"
+	default:
+		return "a value of type " + t.String()
+	}
+}
@@ -0,0 +1,152 @@
+package dap
+
+import "encoding/json"
+
+// The parts of the Debug Adapter Protocol the adapter uses. Messages are framed as the framing
+// package does.
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type initializeArguments struct {
+	LinesStartAt1 *bool `json:"linesStartAt1"`
+}
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+type launchArguments struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source `json:"source"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line"`
+	Message  string `json:"message,omitempty"`
+}
+
+type setBreakpointsBody struct {
+	Breakpoints []breakpoint `json:"breakpoints"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type threadsBody struct {
+	Threads []thread `json:"threads"`
+}
+
+type stackTraceArguments struct {
+	StartFrame int `json:"startFrame"`
+	Levels     int `json:"levels"`
+}
+
+type stackFrame struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Source source `json:"source"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type stackTraceBody struct {
+	StackFrames []stackFrame `json:"stackFrames"`
+	TotalFrames int          `json:"totalFrames"`
+}
+
+type scopesArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	PresentationHint   string `json:"presentationHint,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type scopesBody struct {
+	Scopes []scope `json:"scopes"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type variablesBody struct {
+	Variables []variable `json:"variables"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId"`
+}
+
+type evaluateBody struct {
+	Result             string `json:"result"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type continueBody struct {
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+type stoppedBody struct {
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+type outputBody struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+type exitedBody struct {
+	ExitCode int `json:"exitCode"`
+}
@@ -0,0 +1,490 @@
+// Package dap implements a debug adapter for Lox, speaking the Debug Adapter Protocol over a pair
+// of streams. Scripts run in the tree-walking interpreter, driven by the debugger package, which
+// stops them before statements, as the interpreter tells it what scripts do.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lox/ast"
+	"lox/builtin"
+	"lox/debugger"
+	"lox/diagnostics"
+	"lox/framing"
+	"lox/interpreter"
+	"lox/parser"
+	"lox/resolver"
+	"lox/scanner"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// threadID identifies the only thread scripts run in.
+const threadID = 1
+
+// Server debugs a single script for a client. Requests are handled as they come, while the
+// script runs in a goroutine of its own. When the script stops, that goroutine waits for the
+// action to resume it with, and requests can inspect the interpreter in the meantime.
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interpreter *interpreter.Interpreter
+	debugger    *debugger.Debugger
+	// lineBase is the number of the first line for the client.
+	lineBase int
+	// breakpoints are the lines with a breakpoint, by the absolute path of their source.
+	breakpoints map[string][]int
+	program     *ast.Program
+	path        string
+	source      string
+	done        chan struct{}
+	resume      chan debugger.Action
+	// action is what to resume the script with once the current request is answered.
+	action *debugger.Action
+
+	// mu guards the output and the fields below, which the script goroutine sets when stopping.
+	mu     sync.Mutex
+	seq    int
+	paused bool
+	frames []interpreter.Frame
+	// references are the variables the client can list while the script is stopped.
+	references []func() []variable
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	s := &Server{
+		in:          bufio.NewReader(in),
+		out:         out,
+		interpreter: interpreter.NewInterpreter(),
+		lineBase:    1,
+		breakpoints: make(map[string][]int),
+		resume:      make(chan debugger.Action),
+	}
+	s.interpreter.SetStreams(builtin.NewStreams(strings.NewReader(""), &output{s, "stdout"}, &output{s, "stderr"}))
+	s.debugger = debugger.NewDebugger(s.interpreter, s)
+	return s
+}
+
+// Serve handles requests until the client disconnects. It fails if the input ends before then.
+func (s *Server) Serve() error {
+	defer s.quit()
+	for {
+		content, err := framing.Read(s.in)
+		if err == io.EOF {
+			return errors.New("input ended before disconnecting")
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("invalid message: %v", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		body, err := s.handle(req)
+		if err := s.respond(req, body, err); err != nil {
+			return err
+		}
+		switch {
+		case req.Command == "initialize" && err == nil:
+			if err := s.event("initialized", nil); err != nil {
+				return err
+			}
+		case req.Command == "disconnect":
+			return nil
+		case s.action != nil:
+			s.continueWith(*s.action)
+			s.action = nil
+		}
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		var args initializeArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		return capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+	case "launch":
+		var args launchArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		path := absolute(args.Source.Path)
+		var lines []int
+		body := setBreakpointsBody{Breakpoints: []breakpoint{}}
+		for _, b := range args.Breakpoints {
+			line := b.Line + 1 - s.lineBase
+			lines = append(lines, line)
+			if s.path == "" || path == s.path {
+				body.Breakpoints = append(body.Breakpoints, breakpoint{Verified: true, Line: b.Line})
+			} else {
+				body.Breakpoints = append(body.Breakpoints, breakpoint{Line: b.Line, Message: "not in the script being debugged"})
+			}
+		}
+		s.breakpoints[path] = lines
+		s.setBreakpoints()
+		return body, nil
+	case "setExceptionBreakpoints":
+		return nil, nil
+	case "configurationDone":
+		if s.program == nil {
+			return nil, errors.New("no script launched")
+		}
+		if s.done == nil {
+			s.done = make(chan struct{})
+			go s.run()
+		}
+		return nil, nil
+	case "threads":
+		return threadsBody{Threads: []thread{{ID: threadID, Name: "main"}}}, nil
+	case "continue", "next", "stepIn", "stepOut":
+		if !s.stopped() {
+			return nil, errors.New("the script is not stopped")
+		}
+		action := map[string]debugger.Action{
+			"continue": debugger.Continue,
+			"next":     debugger.StepOver,
+			"stepIn":   debugger.StepIn,
+			"stepOut":  debugger.StepOut,
+		}[req.Command]
+		s.action = &action
+		if action == debugger.Continue {
+			return continueBody{AllThreadsContinued: true}, nil
+		}
+		return nil, nil
+	case "stackTrace":
+		var args stackTraceArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if !s.stopped() {
+			return nil, errors.New("the script is not stopped")
+		}
+		return s.stackTrace(args)
+	case "scopes":
+		var args scopesArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		frame, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		return s.scopes(frame), nil
+	case "variables":
+		var args variablesArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if !s.stopped() || args.VariablesReference <= 0 || args.VariablesReference > len(s.references) {
+			return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+		}
+		return variablesBody{Variables: s.references[args.VariablesReference-1]()}, nil
+	case "evaluate":
+		var args evaluateArguments
+		if err := decode(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		// Without a frame, expressions are evaluated in the innermost one.
+		frame, err := s.frame(max(args.FrameID, 1))
+		if err != nil {
+			return nil, err
+		}
+		value, err := s.debugger.Evaluate(args.Expression, frame)
+		if err != nil {
+			return nil, errors.New(diagnostics.FromError(err).Message)
+		}
+		v := s.variable("", value)
+		return evaluateBody{Result: v.Value, VariablesReference: v.VariablesReference}, nil
+	case "terminate", "disconnect":
+		s.quit()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown command '%s'", req.Command)
+	}
+}
+
+// launch reads the script to debug, which only starts once the client is done configuring.
+// Scripts with errors are not launched, and their errors are reported as output.
+func (s *Server) launch(args launchArguments) error {
+	if s.program != nil {
+		return errors.New("a script is already launched")
+	}
+	if args.Program == "" {
+		return errors.New("no program to launch")
+	}
+	content, err := os.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	s.path, s.source = absolute(args.Program), string(content)
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(s.source))))
+	program, errs := p.ParseProgram()
+	errs = resolver.NewResolver(s.interpreter).ResolveProgram(program, errs)
+	if len(errs) > 0 {
+		reporter := diagnostics.NewReporter(diagnostics.Text, &output{s, "stderr"}, args.Program)
+		for _, err := range errs {
+			reporter.Report(diagnostics.FromError(err), s.source)
+		}
+		reporter.Flush()
+		return errors.New("the script has errors")
+	}
+	s.program = program
+	s.debugger.SetStopOnEntry(args.StopOnEntry)
+	s.setBreakpoints()
+	return nil
+}
+
+// setBreakpoints gives the debugger the breakpoints of the script.
+func (s *Server) setBreakpoints() {
+	for _, line := range s.debugger.Breakpoints() {
+		s.debugger.Clear(line)
+	}
+	for _, line := range s.breakpoints[s.path] {
+		s.debugger.Break(line)
+	}
+}
+
+// run runs the script up to its end, or until it fails or is stopped for good.
+func (s *Server) run() {
+	defer close(s.done)
+	exitCode := 0
+	for _, stmt := range s.program.Statements {
+		if _, err := s.interpreter.Interpret(stmt); errors.Is(err, debugger.ErrQuit) {
+			break
+		} else if err != nil {
+			reporter := diagnostics.NewReporter(diagnostics.Text, &output{s, "stderr"}, s.path)
+			reporter.Report(diagnostics.FromError(err), s.source)
+			reporter.Flush()
+			exitCode = 1
+			break
+		}
+	}
+	s.event("exited", exitedBody{ExitCode: exitCode})
+	s.event("terminated", nil)
+}
+
+// Paused tells the client where the script stopped, and waits for the action to resume it with.
+func (s *Server) Paused(d *debugger.Debugger, stop debugger.Stop) debugger.Action {
+	s.mu.Lock()
+	s.paused = true
+	s.frames = d.Frames()
+	s.references = nil
+	s.mu.Unlock()
+	s.event("stopped", stoppedBody{Reason: stop.Reason, ThreadID: threadID, AllThreadsStopped: true})
+	return <-s.resume
+}
+
+// stopped tells whether the script is stopped. Only the goroutine handling requests resumes it,
+// so the script stays stopped until that goroutine does.
+func (s *Server) stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+func (s *Server) continueWith(action debugger.Action) {
+	s.mu.Lock()
+	s.paused = false
+	s.mu.Unlock()
+	s.resume <- action
+}
+
+// quit stops the script for good if it is running, and waits for it to end.
+func (s *Server) quit() {
+	if s.done == nil {
+		return
+	}
+	if s.stopped() {
+		s.continueWith(debugger.Quit)
+	} else {
+		s.debugger.Quit()
+	}
+	<-s.done
+}
+
+func (s *Server) frame(id int) (interpreter.Frame, error) {
+	if !s.stopped() {
+		return interpreter.Frame{}, errors.New("the script is not stopped")
+	}
+	if id <= 0 || id > len(s.frames) {
+		return interpreter.Frame{}, fmt.Errorf("unknown frame %d", id)
+	}
+	return s.frames[id-1], nil
+}
+
+// stackTrace returns the frames the client asked for. Frames are identified by their index from
+// the innermost one, starting from 1. Asking for no levels, or for more than there are, returns
+// every frame from the start one.
+func (s *Server) stackTrace(args stackTraceArguments) (stackTraceBody, error) {
+	if args.StartFrame < 0 {
+		return stackTraceBody{}, fmt.Errorf("invalid start frame %d", args.StartFrame)
+	}
+	body := stackTraceBody{StackFrames: []stackFrame{}, TotalFrames: len(s.frames)}
+	end := len(s.frames)
+	if args.Levels > 0 && args.Levels < end-args.StartFrame {
+		end = args.StartFrame + args.Levels
+	}
+	for index := args.StartFrame; index < end; index++ {
+		frame := s.frames[index]
+		body.StackFrames = append(body.StackFrames, stackFrame{
+			ID:     index + 1,
+			Name:   frame.Function,
+			Source: source{Name: filepath.Base(s.path), Path: s.path},
+			Line:   frame.Line - 1 + s.lineBase,
+			Column: 1,
+		})
+	}
+	return body, nil
+}
+
+// scopes returns the local variables of a frame, if it has any, and the global ones.
+func (s *Server) scopes(frame interpreter.Frame) scopesBody {
+	body := scopesBody{}
+	env := frame.Env
+	if env.Parent() != nil {
+		locals := env
+		body.Scopes = append(body.Scopes, scope{Name: "Locals", PresentationHint: "locals", VariablesReference: s.reference(func() []variable {
+			var variables []variable
+			for env := locals; env.Parent() != nil; env = env.Parent() {
+				for _, v := range env.Variables() {
+					variables = append(variables, s.variable(v.Name, v.Value))
+				}
+			}
+			return variables
+		})})
+		for env.Parent() != nil {
+			env = env.Parent()
+		}
+	}
+	body.Scopes = append(body.Scopes, scope{Name: "Globals", VariablesReference: s.reference(func() []variable {
+		var variables []variable
+		for _, v := range env.Variables() {
+			variables = append(variables, s.variable(v.Name, v.Value))
+		}
+		return variables
+	})})
+	return body
+}
+
+// variable describes a value for the client. Instances, lists and maps have variables of their
+// own, which the client lists through the reference of the variable.
+func (s *Server) variable(name string, value interface{}) variable {
+	v := variable{Name: name, Value: builtin.Repr(value)}
+	if fields, ok := interpreter.Fields(value); ok && len(fields) > 0 {
+		v.VariablesReference = s.reference(func() []variable {
+			var variables []variable
+			for _, field := range fields {
+				variables = append(variables, s.variable(field.Name, field.Value))
+			}
+			return variables
+		})
+	}
+	switch value := value.(type) {
+	case *builtin.List:
+		if len(value.Elements) > 0 {
+			v.VariablesReference = s.reference(func() []variable {
+				var variables []variable
+				for index, element := range value.Elements {
+					variables = append(variables, s.variable(fmt.Sprintf("[%d]", index), element))
+				}
+				return variables
+			})
+		}
+	case *builtin.Map:
+		if value.Len() > 0 {
+			v.VariablesReference = s.reference(func() []variable {
+				var variables []variable
+				values := value.Values()
+				for index, key := range value.Keys() {
+					variables = append(variables, s.variable(builtin.Repr(key), values[index]))
+				}
+				return variables
+			})
+		}
+	}
+	return v
+}
+
+// reference returns the reference the client lists variables through, until the script goes on.
+func (s *Server) reference(variables func() []variable) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.references = append(s.references, variables)
+	return len(s.references)
+}
+
+func (s *Server) respond(req request, body interface{}, err error) error {
+	res := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		res.Message = err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	res.Seq = s.seq
+	return framing.Write(s.out, res)
+}
+
+func (s *Server) event(name string, body interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return framing.Write(s.out, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// output sends what the script writes to a stream to the client.
+type output struct {
+	s        *Server
+	category string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	if err := o.s.event("output", outputBody{Category: o.category, Output: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func decode(raw json.RawMessage, args interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, args); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+func absolute(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,282 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"lox/framing"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const script = `fun count(n) {
+  var total = 0;
+  for (var i = 1; i <= n; i = i + 1) {
+    total = total + i;
+  }
+  return total;
+}
+var list = [1, "two"];
+print count(2);
+print "done";
+`
+
+func TestServerBreakpoints(t *testing.T) {
+	c := newClient(t)
+	c.initialize(map[string]interface{}{})
+	path := c.launch(script, false, 4)
+	c.expectEvent("stopped", `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`)
+	c.expectBody(c.request("stackTrace", map[string]interface{}{"threadId": 1}), `{"stackFrames":[
+		{"id":1,"name":"count","source":{"name":"script.lox","path":`+quote(path)+`},"line":4,"column":1},
+		{"id":2,"name":"script","source":{"name":"script.lox","path":`+quote(path)+`},"line":9,"column":1}],"totalFrames":2}`)
+	c.expectBody(c.request("scopes", map[string]interface{}{"frameId": 1}), `{"scopes":[
+		{"name":"Locals","presentationHint":"locals","variablesReference":1,"expensive":false},
+		{"name":"Globals","variablesReference":2,"expensive":false}]}`)
+	c.expectBody(c.request("variables", map[string]interface{}{"variablesReference": 1}), `{"variables":[
+		{"name":"i","value":"1","variablesReference":0},
+		{"name":"n","value":"2","variablesReference":0},
+		{"name":"total","value":"0","variablesReference":0}]}`)
+	c.expectBody(c.request("evaluate", map[string]interface{}{"expression": "list", "frameId": 2}),
+		`{"result":"[1, \"two\"]","variablesReference":3}`)
+	c.expectBody(c.request("variables", map[string]interface{}{"variablesReference": 3}), `{"variables":[
+		{"name":"[0]","value":"1","variablesReference":0},
+		{"name":"[1]","value":"\"two\"","variablesReference":0}]}`)
+	c.expectFailure(c.request("evaluate", map[string]interface{}{"expression": "total +"}), "expected expression")
+	c.expectFailure(c.request("stackTrace", map[string]interface{}{"threadId": 1, "startFrame": -1}), "invalid start frame -1")
+	c.expectBody(c.request("stackTrace", map[string]interface{}{"threadId": 1, "startFrame": 1, "levels": 1 << 62}), `{"stackFrames":[
+		{"id":2,"name":"script","source":{"name":"script.lox","path":`+quote(path)+`},"line":9,"column":1}],"totalFrames":2}`)
+	c.expectBody(c.request("stackTrace", map[string]interface{}{"threadId": 1, "startFrame": 5, "levels": -1}), `{"stackFrames":[],"totalFrames":2}`)
+
+	c.expectBody(c.request("continue", map[string]interface{}{"threadId": 1}), `{"allThreadsContinued":true}`)
+	c.expectEvent("stopped", `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`)
+	c.expectBody(c.request("evaluate", map[string]interface{}{"expression": "total = 10"}), `{"result":"10","variablesReference":0}`)
+	c.request("stepOut", map[string]interface{}{"threadId": 1})
+	c.expectEvent("stopped", `{"reason":"step","threadId":1,"allThreadsStopped":true}`)
+	c.expectBody(c.request("stackTrace", map[string]interface{}{"threadId": 1, "levels": 1}), `{"stackFrames":[
+		{"id":1,"name":"script","source":{"name":"script.lox","path":`+quote(path)+`},"line":10,"column":1}],"totalFrames":1}`)
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	c.expectEvent("exited", `{"exitCode":0}`)
+	c.expectEvent("terminated", ``)
+	c.disconnect()
+	if output := c.output("stdout"); output != "12\ndone\n" {
+		t.Errorf("expected the assignment to change the result, got '%s'", output)
+	}
+}
+
+func TestServerStepping(t *testing.T) {
+	c := newClient(t)
+	c.initialize(map[string]interface{}{"linesStartAt1": false})
+	c.launch(script, true)
+	c.expectEvent("stopped", `{"reason":"entry","threadId":1,"allThreadsStopped":true}`)
+	var lines []float64
+	for _, command := range []string{"next", "next", "stepIn", "stepIn", "next"} {
+		c.request(command, map[string]interface{}{"threadId": 1})
+		c.expectEvent("stopped", "")
+		body := c.request("stackTrace", map[string]interface{}{"threadId": 1})["body"].(map[string]interface{})
+		lines = append(lines, body["stackFrames"].([]interface{})[0].(map[string]interface{})["line"].(float64))
+	}
+	if actual := normalize(t, lines); actual != "[7,8,1,2,3]" {
+		t.Errorf("expected to stop on lines [7,8,1,2,3], got %s", actual)
+	}
+	c.disconnect()
+	if output := c.output("stdout"); output != "" {
+		t.Errorf("expected the script to be stopped, got '%s'", output)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	c := newClient(t)
+	c.expectFailure(c.request("continue", map[string]interface{}{"threadId": 1}), "the script is not stopped")
+	c.expectFailure(c.request("configurationDone", nil), "no script launched")
+	c.expectFailure(c.request("launch", map[string]interface{}{"program": write(t, "print 1 +;\n")}), "the script has errors")
+	if output := c.output("stderr"); !strings.Contains(output, "expected expression") {
+		t.Errorf("expected the syntax error to be reported, got '%s'", output)
+	}
+	c.expectFailure(c.request("attach", nil), "unknown command 'attach'")
+	c.disconnect()
+
+	c = newClient(t)
+	c.initialize(map[string]interface{}{})
+	c.launch("print 1;\nprint nil + 1;\n", false)
+	c.expectEvent("exited", `{"exitCode":1}`)
+	c.disconnect()
+	if output := c.output("stderr"); !strings.Contains(output, "left operand must be a number") {
+		t.Errorf("expected the runtime error to be reported, got '%s'", output)
+	}
+}
+
+// client talks to a server running in the background, keeping the events it has yet to expect.
+type client struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *bufio.Reader
+	seq    int
+	events []map[string]interface{}
+	// outputs are the output events received so far.
+	outputs []map[string]interface{}
+	errs    chan error
+}
+
+func newClient(t *testing.T) *client {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	c := &client{t: t, in: inWriter, out: bufio.NewReader(outReader), errs: make(chan error, 1)}
+	go func() {
+		c.errs <- NewServer(inReader, outWriter).Serve()
+		outWriter.Close()
+	}()
+	return c
+}
+
+func (c *client) initialize(arguments map[string]interface{}) {
+	c.t.Helper()
+	c.expectBody(c.request("initialize", arguments), "")
+	c.expectEvent("initialized", "")
+}
+
+// launch launches a script with breakpoints on the given lines, and starts it.
+func (c *client) launch(src string, stopOnEntry bool, lines ...int) string {
+	c.t.Helper()
+	path := write(c.t, src)
+	c.expectBody(c.request("launch", map[string]interface{}{"program": path, "stopOnEntry": stopOnEntry}), "")
+	var breakpoints []interface{}
+	for _, line := range lines {
+		breakpoints = append(breakpoints, map[string]interface{}{"line": line})
+	}
+	c.request("setBreakpoints", map[string]interface{}{"source": map[string]interface{}{"path": path}, "breakpoints": breakpoints})
+	c.expectBody(c.request("configurationDone", nil), "")
+	return path
+}
+
+// request sends a request and returns its response, keeping the events sent before it.
+func (c *client) request(command string, arguments interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.seq++
+	message := map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": arguments}
+	if err := framing.Write(c.in, message); err != nil {
+		c.t.Fatalf("failed to send request: %v", err)
+	}
+	for {
+		message := c.read()
+		if message["type"] == "response" && message["request_seq"] == float64(c.seq) {
+			return message
+		}
+	}
+}
+
+func (c *client) disconnect() {
+	c.t.Helper()
+	c.request("disconnect", nil)
+	if err := <-c.errs; err != nil {
+		c.t.Fatalf("unexpected error: %v", err)
+	}
+	for {
+		if _, err := framing.Read(c.out); err != nil {
+			return
+		}
+	}
+}
+
+// read reads the next message from the server, keeping it if it is an event.
+func (c *client) read() map[string]interface{} {
+	c.t.Helper()
+	content, err := framing.Read(c.out)
+	if err != nil {
+		c.t.Fatalf("failed to read message: %v", err)
+	}
+	var message map[string]interface{}
+	if err := json.Unmarshal(content, &message); err != nil {
+		c.t.Fatalf("invalid message '%s': %v", content, err)
+	}
+	if message["type"] == "event" {
+		if message["event"] == "output" {
+			c.outputs = append(c.outputs, message)
+		} else {
+			c.events = append(c.events, message)
+		}
+	}
+	return message
+}
+
+// expectEvent waits for the next event, which must have the given name and body. An empty body
+// matches any.
+func (c *client) expectEvent(name string, body string) {
+	c.t.Helper()
+	for len(c.events) == 0 {
+		c.read()
+	}
+	event := c.events[0]
+	c.events = c.events[1:]
+	if event["event"] != name {
+		c.t.Fatalf("expected %s event, got %v", name, event)
+	}
+	if body != "" {
+		expectJSON(c.t, event["body"], body)
+	}
+}
+
+func (c *client) expectBody(response map[string]interface{}, body string) {
+	c.t.Helper()
+	if response["success"] != true {
+		c.t.Fatalf("unexpected failure of %s: %v", response["command"], response["message"])
+	}
+	if body != "" {
+		expectJSON(c.t, response["body"], body)
+	}
+}
+
+func (c *client) expectFailure(response map[string]interface{}, message string) {
+	c.t.Helper()
+	if response["success"] != false || response["message"] != message {
+		c.t.Errorf("expected %s to fail with '%s', got %v", response["command"], message, response)
+	}
+}
+
+// output returns what the server sent as output of a category.
+func (c *client) output(category string) string {
+	builder := strings.Builder{}
+	for _, event := range c.outputs {
+		body := event["body"].(map[string]interface{})
+		if body["category"] == category {
+			builder.WriteString(body["output"].(string))
+		}
+	}
+	return builder.String()
+}
+
+func write(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.lox")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func expectJSON(t *testing.T, actual interface{}, expected string) {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(expected), &value); err != nil {
+		t.Fatalf("invalid expected JSON: %v", err)
+	}
+	if actual, expected := normalize(t, actual), normalize(t, value); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+// normalize returns decoded JSON encoded again, so that values compare as strings.
+func normalize(t *testing.T, value interface{}) string {
+	t.Helper()
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func quote(s string) string {
+	content, _ := json.Marshal(s)
+	return string(content)
+}
@@ -0,0 +1,185 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lox/builtin"
+	"lox/diagnostics"
+	"lox/interpreter"
+	"strconv"
+	"strings"
+)
+
+const consoleHelp = `commands:
+  continue, c       run up to the next breakpoint
+  step, s           stop at the next line, stepping into calls
+  next, n           stop at the next line, stepping over calls
+  out, o            stop once the current function returns
+  break, b LINE     stop before running the statements on a line
+  clear LINE        remove the breakpoint on a line
+  backtrace, bt     print the calls in progress
+  frame, f N        select the frame to inspect, from the backtrace
+  locals            print the variables of the selected frame
+  list, l           print the source around the selected frame
+  print, p EXPR     evaluate an expression in the selected frame
+  quit, q           stop the script
+  help, h           print this help
+Anything else is evaluated as an expression. An empty line repeats the previous command.
+`
+
+// consoleContext is how many lines are listed around the current one.
+const consoleContext = 3
+
+// Console debugs scripts from a terminal, reading commands from a reader and printing to a writer.
+type Console struct {
+	in     *bufio.Reader
+	out    io.Writer
+	lines  []string
+	frames []interpreter.Frame
+	// frame is the index of the selected frame, where locals are listed and expressions evaluated.
+	frame    int
+	previous string
+}
+
+// NewConsole creates a console for debugging the given source.
+func NewConsole(in *bufio.Reader, out io.Writer, source string) *Console {
+	return &Console{in: in, out: out, lines: strings.Split(strings.TrimSuffix(source, "\n"), "\n")}
+}
+
+// Paused tells where the script stopped, then runs commands until one makes the script go on.
+// Quitting is the same as reaching the end of the input.
+func (c *Console) Paused(d *Debugger, stop Stop) Action {
+	c.frames = d.Frames()
+	c.frame = 0
+	fmt.Fprintf(c.out, "%s at line %d in %s\n", stop.Reason, stop.Line, c.frames[0].Function)
+	c.printLine(stop.Line)
+	for {
+		fmt.Fprint(c.out, "(lox) ")
+		line, err := c.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(c.out)
+			return Quit
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = c.previous
+		}
+		c.previous = line
+		if action, ok := c.command(d, line); ok {
+			return action
+		}
+	}
+}
+
+// command runs a command, returning the action to take if it makes the script go on.
+func (c *Console) command(d *Debugger, line string) (Action, bool) {
+	name, argument, _ := strings.Cut(line, " ")
+	argument = strings.TrimSpace(argument)
+	switch name {
+	case "":
+	case "continue", "c":
+		return Continue, true
+	case "step", "s":
+		return StepIn, true
+	case "next", "n":
+		return StepOver, true
+	case "out", "o":
+		return StepOut, true
+	case "quit", "q":
+		return Quit, true
+	case "break", "b":
+		if argument == "" {
+			if lines := d.Breakpoints(); len(lines) > 0 {
+				fmt.Fprintln(c.out, "breakpoints on lines", strings.Trim(fmt.Sprint(lines), "[]"))
+			} else {
+				fmt.Fprintln(c.out, "no breakpoints")
+			}
+		} else if line, ok := c.lineArgument(argument); ok {
+			d.Break(line)
+			fmt.Fprintf(c.out, "breakpoint on line %d\n", line)
+		}
+	case "clear":
+		if line, ok := c.lineArgument(argument); ok {
+			if d.Clear(line) {
+				fmt.Fprintf(c.out, "cleared breakpoint on line %d\n", line)
+			} else {
+				fmt.Fprintf(c.out, "no breakpoint on line %d\n", line)
+			}
+		}
+	case "backtrace", "bt":
+		for index, frame := range c.frames {
+			marker := " "
+			if index == c.frame {
+				marker = "*"
+			}
+			fmt.Fprintf(c.out, "%s#%d %s at line %d\n", marker, index, frame.Function, frame.Line)
+		}
+	case "frame", "f":
+		index, err := strconv.Atoi(argument)
+		if err != nil || index < 0 || index >= len(c.frames) {
+			fmt.Fprintf(c.out, "expected a frame between 0 and %d\n", len(c.frames)-1)
+			break
+		}
+		c.frame = index
+		fmt.Fprintf(c.out, "#%d %s at line %d\n", index, c.frames[index].Function, c.frames[index].Line)
+		c.printLine(c.frames[index].Line)
+	case "locals":
+		c.printLocals()
+	case "list", "l":
+		current := c.frames[c.frame].Line
+		for line := max(current-consoleContext, 1); line <= min(current+consoleContext, len(c.lines)); line++ {
+			marker := " "
+			if line == current {
+				marker = ">"
+			}
+			fmt.Fprintf(c.out, "%s%4d | %s\n", marker, line, c.lines[line-1])
+		}
+	case "help", "h":
+		fmt.Fprint(c.out, consoleHelp)
+	case "print", "p":
+		c.evaluate(d, argument)
+	default:
+		c.evaluate(d, line)
+	}
+	return Continue, false
+}
+
+func (c *Console) lineArgument(argument string) (int, bool) {
+	line, err := strconv.Atoi(argument)
+	if err != nil || line <= 0 {
+		fmt.Fprintln(c.out, "expected a line number")
+		return 0, false
+	}
+	return line, true
+}
+
+// printLocals prints the variables of each scope of the selected frame, innermost first. Global
+// variables are left out, as builtins are among them.
+func (c *Console) printLocals() {
+	printed := false
+	for env := c.frames[c.frame].Env; env.Parent() != nil; env = env.Parent() {
+		for _, variable := range env.Variables() {
+			fmt.Fprintf(c.out, "%s = %s\n", variable.Name, builtin.Repr(variable.Value))
+			printed = true
+		}
+	}
+	if !printed {
+		fmt.Fprintln(c.out, "no local variables")
+	}
+}
+
+func (c *Console) evaluate(d *Debugger, source string) {
+	value, err := d.Evaluate(source, c.frames[c.frame])
+	if err != nil {
+		fmt.Fprintln(c.out, "error:", diagnostics.FromError(err).Message)
+		return
+	}
+	fmt.Fprintln(c.out, builtin.Repr(value))
+}
+
+func (c *Console) printLine(line int) {
+	if line > 0 && line <= len(c.lines) {
+		fmt.Fprintf(c.out, "%5d | %s\n", line, c.lines[line-1])
+	}
+}
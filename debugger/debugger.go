@@ -0,0 +1,230 @@
+// Package debugger steps through scripts run by the tree-walking interpreter. Scripts stop at
+// breakpoints and after steps, and can then be inspected: the calls in progress, the variables
+// of each of them, and the value of expressions evaluated where they are.
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"lox/ast"
+	"lox/interpreter"
+	"lox/parser"
+	"lox/resolver"
+	"lox/scanner"
+	"lox/token"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Action is how a script goes on after stopping.
+type Action int
+
+const (
+	// Continue runs the script up to the next breakpoint.
+	Continue Action = iota
+	// StepIn stops at the next line, even in a function called from the current one.
+	StepIn
+	// StepOver stops at the next line of the current function, or of its caller once it returns.
+	StepOver
+	// StepOut stops once the current function returned.
+	StepOut
+	// Quit stops the script for good.
+	Quit
+)
+
+// ErrQuit is the error a script stops with when quitting.
+var ErrQuit = errors.New("script stopped by the debugger")
+
+// Stop tells where a script stopped, and why: "entry", "breakpoint" or "step".
+type Stop struct {
+	Reason string
+	Line   int
+	Stmt   ast.Stmt
+}
+
+// Frontend is how the user debugs a script. Paused is called whenever the script stops, and the
+// script goes on as the action returned tells.
+type Frontend interface {
+	Paused(d *Debugger, stop Stop) Action
+}
+
+// Debugger stops a script run by an interpreter, before running the statements it should stop
+// at. Scripts stop at most once per line: statements on the line of the previous one, in the
+// same call, are run without stopping, unless they start a new block.
+//
+// Breakpoints can be changed, and the script stopped, from other goroutines than the one running
+// the script; mu guards what they change.
+type Debugger struct {
+	interpreter *interpreter.Interpreter
+	frontend    Frontend
+	mu          sync.Mutex
+	breakpoints map[int]bool
+	quit        bool
+	action      Action
+	// depth is how many calls were in progress when the action was taken.
+	depth int
+	// line and lineDepth are where the previous statement was.
+	line       int
+	lineDepth  int
+	stopped    bool
+	evaluating bool
+}
+
+// NewDebugger debugs the scripts run by an interpreter from now on, which are first run up to a
+// breakpoint.
+func NewDebugger(i *interpreter.Interpreter, frontend Frontend) *Debugger {
+	d := &Debugger{interpreter: i, frontend: frontend, breakpoints: make(map[int]bool)}
+	i.SetObserver(observer{d})
+	return d
+}
+
+// SetStopOnEntry makes the script stop at its first statement, rather than at the first
+// breakpoint.
+func (d *Debugger) SetStopOnEntry(stop bool) {
+	if stop {
+		d.action = StepIn
+	} else {
+		d.action = Continue
+	}
+}
+
+// Break sets a breakpoint on a line, where the script stops before running the statements
+// starting on it.
+func (d *Debugger) Break(line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[line] = true
+}
+
+// Clear removes the breakpoint on a line, returning whether there was one.
+func (d *Debugger) Clear(line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ok := d.breakpoints[line]
+	delete(d.breakpoints, line)
+	return ok
+}
+
+// Breakpoints returns the lines with a breakpoint, in order.
+func (d *Debugger) Breakpoints() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var lines []int
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Quit stops the script for good before its next statement, unless it is stopped already: its
+// frontend should then tell it to quit instead.
+func (d *Debugger) Quit() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.quit = true
+}
+
+// Frames returns the calls in progress where the script stopped, innermost first and ending
+// with the script itself.
+func (d *Debugger) Frames() []interpreter.Frame {
+	return d.interpreter.Frames()
+}
+
+// Evaluate evaluates an expression in the environment of a frame, where its local variables are
+// visible. Evaluating does not stop at breakpoints.
+func (d *Debugger) Evaluate(source string, frame interpreter.Frame) (interface{}, error) {
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(source))))
+	expr, err := p.ParseExpression()
+	if err != nil {
+		return nil, err
+	}
+	var scopes [][]string
+	for env := frame.Env; env.Parent() != nil; env = env.Parent() {
+		scopes = append([][]string{env.Names()}, scopes...)
+	}
+	if err := resolver.NewResolver(d.interpreter).ResolveExpr(expr, scopes); err != nil {
+		return nil, err
+	}
+	d.evaluating = true
+	defer func() {
+		d.evaluating = false
+	}()
+	return d.interpreter.Evaluate(expr, frame.Env)
+}
+
+// before is called before each statement, and stops the script if it should.
+func (d *Debugger) before(stmt ast.Stmt) error {
+	if d.evaluating {
+		return nil
+	}
+	d.mu.Lock()
+	quit := d.quit
+	d.mu.Unlock()
+	if quit || d.action == Quit {
+		// Finally clauses are left unfinished too.
+		return ErrQuit
+	}
+	switch stmt.(type) {
+	case *ast.BlockStmt:
+		// The statements of a block are on a line of their own, even if the previous one was
+		// on the same line, like in a new iteration of a loop.
+		d.line = 0
+		return nil
+	case *ast.EndStmt:
+		return nil
+	}
+	line, depth := stmt.Start().Line, d.interpreter.Depth()
+	if line == d.line && depth == d.lineDepth {
+		return nil
+	}
+	d.line, d.lineDepth = line, depth
+	var reason string
+	switch {
+	case d.action == StepIn, d.action == StepOver && depth <= d.depth, d.action == StepOut && depth < d.depth:
+		reason = "step"
+		if !d.stopped {
+			reason = "entry"
+		}
+	case d.breakpoint(line):
+		reason = "breakpoint"
+	default:
+		return nil
+	}
+	d.stopped = true
+	d.action = d.frontend.Paused(d, Stop{Reason: reason, Line: line, Stmt: stmt})
+	d.depth = depth
+	if d.action == Quit {
+		return ErrQuit
+	}
+	return nil
+}
+
+// observer has the interpreter call the debugger before each statement. The rest of what scripts
+// do is of no use to it.
+type observer struct {
+	d *Debugger
+}
+
+func (o observer) EnterStmt(stmt ast.Stmt) error {
+	return o.d.before(stmt)
+}
+
+func (o observer) LeaveStmt(stmt ast.Stmt) {}
+
+func (o observer) Call(callee interface{}, arguments []interface{}) {}
+
+func (o observer) Return(callee interface{}, result interface{}) {}
+
+func (o observer) Assign(name token.Token, value interface{}) {}
+
+func (o observer) Set(object interface{}, key interface{}, value interface{}) {}
+
+func (o observer) Error(err *interpreter.RuntimeError, caught bool) {}
+
+func (d *Debugger) breakpoint(line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.breakpoints[line]
+}
@@ -0,0 +1,226 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"lox/builtin"
+	"lox/interpreter"
+	"lox/parser"
+	"lox/resolver"
+	"lox/scanner"
+	"strings"
+	"testing"
+)
+
+const script = `fun count(n) {
+  var total = 0;
+  for (var i = 1; i <= n; i = i + 1) {
+    total = total + i;
+  }
+  return total;
+}
+class Counter {
+  init(n) { this.n = n; }
+  sum() { return count(this.n); }
+}
+print Counter(3).sum();
+print "done";
+`
+
+func TestDebuggerSteps(t *testing.T) {
+	steps := []Action{StepIn, StepIn, StepIn, StepIn, StepIn, StepOver, StepIn, StepOut, StepIn}
+	expectStops(t, script, nil, true, steps, []string{
+		"entry at 1 in script",
+		"step at 8 in script",
+		"step at 12 in script",
+		"step at 9 in init",
+		"step at 10 in sum",
+		"step at 2 in count",
+		"step at 3 in count",
+		"step at 4 in count",
+		"step at 13 in script",
+	})
+	expectStops(t, script, nil, true, []Action{StepOver, StepOver, StepOver}, []string{
+		"entry at 1 in script",
+		"step at 8 in script",
+		"step at 12 in script",
+		"step at 13 in script",
+	})
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	expectStops(t, script, []int{4}, false, []Action{Continue, Continue, StepOut}, []string{
+		"breakpoint at 4 in count",
+		"breakpoint at 4 in count",
+		"breakpoint at 4 in count",
+		"step at 13 in script",
+	})
+	expectStops(t, script, []int{4, 6}, false, []Action{Continue, Continue, Continue, Continue}, []string{
+		"breakpoint at 4 in count",
+		"breakpoint at 4 in count",
+		"breakpoint at 4 in count",
+		"breakpoint at 6 in count",
+	})
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	out, err := run(t, script, []int{4}, false, func(d *Debugger, stop Stop) Action { return Quit })
+	if !errors.Is(err, ErrQuit) {
+		t.Errorf("expected the script to be stopped by the debugger, got %v", err)
+	}
+	if out != "" {
+		t.Errorf("expected no output, got '%s'", out)
+	}
+	out, err = run(t, "try {\n  print 1;\n} catch (e) {\n  print e;\n} finally {\n  print 2;\n}\n", []int{2}, false,
+		func(d *Debugger, stop Stop) Action { return Quit })
+	if !errors.Is(err, ErrQuit) {
+		t.Errorf("expected the script to be stopped by the debugger, got %v", err)
+	}
+	if out != "" {
+		t.Errorf("expected no output, got '%s'", out)
+	}
+}
+
+func TestDebuggerEvaluate(t *testing.T) {
+	var results []string
+	out, err := run(t, script, []int{6, 13}, false, func(d *Debugger, stop Stop) Action {
+		frames := d.Frames()
+		for _, source := range []string{"total", "n * 2", "total = 100", "this.n", "count(1) + undefined"} {
+			value, err := d.Evaluate(source, frames[0])
+			if err != nil {
+				results = append(results, fmt.Sprintf("%s: %v", source, err))
+			} else {
+				results = append(results, fmt.Sprintf("%s: %s", source, builtin.Repr(value)))
+			}
+		}
+		if len(frames) > 2 {
+			value, err := d.Evaluate("this.n", frames[1])
+			results = append(results, fmt.Sprintf("this.n in %s: %v %v", frames[1].Function, value, err))
+		}
+		return Continue
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"total: 6",
+		"n * 2: 6",
+		"total = 100: 100",
+		"this.n: resolution error on line 1: cannot use 'this' outside of a class",
+		"count(1) + undefined: runtime error on line 1: variable not defined",
+		"this.n in sum: 3 <nil>",
+		"total: runtime error on line 1: variable not defined",
+		"n * 2: runtime error on line 1: variable not defined",
+		"total = 100: runtime error on line 1: variable not declared",
+		"this.n: resolution error on line 1: cannot use 'this' outside of a class",
+		"count(1) + undefined: runtime error on line 1: variable not defined",
+	}
+	if strings.Join(results, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(results, "\n"))
+	}
+	if out != "100\ndone\n" {
+		t.Errorf("expected the assignment to change the result, got '%s'", out)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	commands := "b 4\nc\nbt\nlocals\np total + i\n\ni = 3\nf 2\nlist\nthis\nclear 4\nclear 4\nb\nn\nn\nwhat\nc\n"
+	in := bufio.NewReader(strings.NewReader(commands))
+	console := &strings.Builder{}
+	out, err := run(t, script, nil, true, NewConsole(in, console, script).Paused)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `entry at line 1 in script
+    1 | fun count(n) {
+(lox) breakpoint on line 4
+(lox) breakpoint at line 4 in count
+    4 |     total = total + i;
+(lox) *#0 count at line 4
+ #1 sum at line 10
+ #2 script at line 12
+(lox) i = 1
+n = 3
+total = 0
+(lox) 1
+(lox) 1
+(lox) 3
+(lox) #2 script at line 12
+   12 | print Counter(3).sum();
+(lox)     9 |   init(n) { this.n = n; }
+   10 |   sum() { return count(this.n); }
+   11 | }
+>  12 | print Counter(3).sum();
+   13 | print "done";
+(lox) error: cannot use 'this' outside of a class
+(lox) cleared breakpoint on line 4
+(lox) no breakpoint on line 4
+(lox) no breakpoints
+(lox) step at line 6 in count
+    6 |   return total;
+(lox) step at line 13 in script
+   13 | print "done";
+(lox) error: variable not defined
+(lox) `
+	if console.String() != expected {
+		t.Errorf("expected console:\n%s\ngot:\n%s", expected, console.String())
+	}
+	if out != "3\ndone\n" {
+		t.Errorf("expected the assignment to change the result, got '%s'", out)
+	}
+}
+
+// frontend is a frontend calling a function when the script stops.
+type frontend func(d *Debugger, stop Stop) Action
+
+func (f frontend) Paused(d *Debugger, stop Stop) Action {
+	return f(d, stop)
+}
+
+// expectStops runs a script taking the given actions in turn, and checks where it stopped.
+func expectStops(t *testing.T, src string, breakpoints []int, stopOnEntry bool, actions []Action, expected []string) {
+	t.Helper()
+	var stops []string
+	_, err := run(t, src, breakpoints, stopOnEntry, func(d *Debugger, stop Stop) Action {
+		stops = append(stops, fmt.Sprintf("%s at %d in %s", stop.Reason, stop.Line, d.Frames()[0].Function))
+		if len(stops) > len(actions) {
+			return Continue
+		}
+		return actions[len(stops)-1]
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(stops, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected stops:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(stops, "\n"))
+	}
+}
+
+// run runs a script in the debugger, and returns what it printed.
+func run(t *testing.T, src string, breakpoints []int, stopOnEntry bool, paused frontend) (string, error) {
+	t.Helper()
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
+	program, errs := p.ParseProgram()
+	if len(errs) > 0 {
+		t.Fatalf("unexpected syntax errors: %v", errs)
+	}
+	i := interpreter.NewInterpreter()
+	out := &strings.Builder{}
+	i.SetStreams(builtin.NewStreams(strings.NewReader(""), out, out))
+	r := resolver.NewResolver(i)
+	d := NewDebugger(i, paused)
+	d.SetStopOnEntry(stopOnEntry)
+	for _, line := range breakpoints {
+		d.Break(line)
+	}
+	for _, stmt := range program.Statements {
+		if err := r.Resolve(stmt); err != nil {
+			t.Fatalf("unexpected resolution error: %v", err)
+		}
+		if _, err := i.Interpret(stmt); err != nil {
+			return out.String(), err
+		}
+	}
+	return out.String(), nil
+}
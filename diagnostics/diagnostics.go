@@ -0,0 +1,79 @@
+// Package diagnostics describes the problems found in scripts, and renders them for humans
+// along with the source they refer to.
+package diagnostics
+
+import (
+	"errors"
+	"lox/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	default:
+		return "error"
+	}
+}
+
+// Codes identify the phase that found a problem in machine-readable output. They are stable,
+// while the wording of messages may change.
+const (
+	CodeLexical    = "E0001"
+	CodeSyntax     = "E0002"
+	CodeResolution = "E0003"
+	CodeCompile    = "E0004"
+	CodeRuntime    = "E0005"
+)
+
+// Diagnostic is a problem found in a script. Kind tells which phase found it, like "syntax" or
+// "runtime", and Code identifies that phase for tools. The span is the source causing the
+// problem: it is zero when the problem is not tied to any source, and has no columns when only
+// its line is known.
+type Diagnostic struct {
+	Severity Severity
+	Kind     string
+	Code     string
+	Message  string
+	Span     token.Span
+	Notes    []string
+	Hints    []string
+}
+
+// Diagnosable is implemented by the errors that can describe themselves as a diagnostic.
+type Diagnosable interface {
+	error
+	Diagnostic() Diagnostic
+}
+
+// FromError returns the diagnostic for an error. If the error cannot describe itself, it only
+// has its message, and the line it happened on if the error knows it.
+func FromError(err error) Diagnostic {
+	var d Diagnosable
+	if errors.As(err, &d) {
+		return d.Diagnostic()
+	}
+	var lined interface{ Line() int }
+	if errors.As(err, &lined) {
+		return Diagnostic{Severity: Error, Message: err.Error(), Span: LineSpan(lined.Line())}
+	}
+	return Diagnostic{Severity: Error, Message: err.Error()}
+}
+
+// LineSpan returns the span of a whole line, for problems whose column is unknown.
+func LineSpan(line int) token.Span {
+	if line <= 0 {
+		return token.Span{}
+	}
+	return token.Span{Start: token.Position{Line: line}, End: token.Position{Line: line}}
+}
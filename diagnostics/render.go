@@ -0,0 +1,167 @@
+package diagnostics
+
+import (
+	"fmt"
+	"io"
+	"lox/token"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	reset  = "\x1b[0m"
+	bold   = "\x1b[1m"
+	red    = "\x1b[1;31m"
+	yellow = "\x1b[1;33m"
+	blue   = "\x1b[1;34m"
+	cyan   = "\x1b[1;36m"
+)
+
+// Renderer renders diagnostics the way rustc does: a header with the message, the location of
+// the problem, the line of source it is on with its span underlined, and then notes and hints.
+type Renderer struct {
+	// Filename is how the location refers to the source.
+	Filename string
+	// Color enables ANSI colours, which only make sense on terminals.
+	Color bool
+}
+
+// Render writes a diagnostic, quoting the source it refers to.
+func (r Renderer) Render(w io.Writer, d Diagnostic, source string) error {
+	var b strings.Builder
+	header := d.Severity.String()
+	if d.Kind != "" {
+		header = d.Kind + " " + header
+	}
+	b.WriteString(r.paint(header+":", r.severityColor(d.Severity)))
+	b.WriteString(r.paint(" "+d.Message, bold))
+	b.WriteByte('\n')
+
+	start := d.Span.Start
+	gutter := strings.Repeat(" ", len(strconv.Itoa(d.Span.Start.Line)))
+	if start.Line > 0 {
+		location := fmt.Sprintf("%s:%d", r.filename(), start.Line)
+		if start.Column > 0 {
+			location += fmt.Sprintf(":%d", start.Column)
+		}
+		fmt.Fprintf(&b, "%s%s %s\n", gutter, r.paint("-->", blue), location)
+		if line, ok := sourceLine(source, start.Line); ok {
+			bar := r.paint("|", blue)
+			fmt.Fprintf(&b, "%s %s\n", gutter, bar)
+			fmt.Fprintf(&b, "%s %s %s\n", r.paint(strconv.Itoa(start.Line), blue), bar, expandTabs(line))
+			if start.Column > 0 {
+				fmt.Fprintf(&b, "%s %s %s\n", gutter, bar, r.paint(underline(line, d.Span), r.severityColor(d.Severity)))
+			}
+		}
+	}
+	for _, note := range d.Notes {
+		r.annotate(&b, gutter, "note", note)
+	}
+	for _, hint := range d.Hints {
+		r.annotate(&b, gutter, "help", hint)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// annotate writes a note or a hint, aligning the lines after its first under it.
+func (r Renderer) annotate(b *strings.Builder, gutter string, label string, text string) {
+	prefix := fmt.Sprintf("%s %s %s: ", gutter, r.paint("=", blue), label)
+	indent := strings.Repeat(" ", len(gutter)+len(label)+5)
+	for i, line := range strings.Split(text, "\n") {
+		if i == 0 {
+			b.WriteString(prefix)
+		} else {
+			b.WriteString(indent)
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+}
+
+func (r Renderer) filename() string {
+	if r.Filename == "" {
+		return "<stdin>"
+	}
+	return r.Filename
+}
+
+func (r Renderer) severityColor(s Severity) string {
+	switch s {
+	case Warning:
+		return yellow
+	case Note:
+		return cyan
+	default:
+		return red
+	}
+}
+
+func (r Renderer) paint(text string, color string) string {
+	if !r.Color {
+		return text
+	}
+	return color + text + reset
+}
+
+// underline returns the carets marking the span under the line it starts on. A span going
+// past the end of that line is underlined up to it, and an empty span still gets one caret.
+func underline(line string, span token.Span) string {
+	runes := []rune(line)
+	start := min(span.Start.Column-1, len(runes))
+	end := len(runes)
+	if span.End.Line == span.Start.Line {
+		end = min(span.End.Column-1, end)
+	}
+	width := max(end-start, 1)
+	return strings.Repeat(" ", columnWidth(runes[:start])) + strings.Repeat("^", width)
+}
+
+// columnWidth returns how many columns the runes take once tabs are expanded.
+func columnWidth(runes []rune) int {
+	width := 0
+	for _, r := range runes {
+		if r == '\t' {
+			width += 4
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+func expandTabs(line string) string {
+	return strings.ReplaceAll(line, "\t", "    ")
+}
+
+// sourceLine returns the given line of the source, without its line ending.
+func sourceLine(source string, number int) (string, bool) {
+	for i := 1; i < number; i++ {
+		newline := strings.IndexByte(source, '\n')
+		if newline < 0 {
+			return "", false
+		}
+		source = source[newline+1:]
+	}
+	if newline := strings.IndexByte(source, '\n'); newline >= 0 {
+		source = source[:newline]
+	}
+	source = strings.TrimSuffix(source, "\r")
+	if !utf8.ValidString(source) {
+		return "", false
+	}
+	return source, true
+}
+
+// UseColor returns whether diagnostics written to w should be coloured: only if it is a
+// terminal, and NO_COLOR is not set to ask for plain output anyway.
+func UseColor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
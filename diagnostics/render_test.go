@@ -0,0 +1,83 @@
+package diagnostics
+
+import (
+	"errors"
+	"lox/token"
+	"strings"
+	"testing"
+)
+
+func span(line, start, end int) token.Span {
+	return token.Span{Start: token.Position{Line: line, Column: start}, End: token.Position{Line: line, Column: end}}
+}
+
+func render(t *testing.T, d Diagnostic, source string) string {
+	t.Helper()
+	var b strings.Builder
+	if err := (Renderer{Filename: "test.lox"}).Render(&b, d, source); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRender(t *testing.T) {
+	d := Diagnostic{Kind: "syntax", Message: "expected expression", Span: span(2, 7, 10), Hints: []string{"add a value"}}
+	got := render(t, d, "var a;\nprint 1 + ;\n")
+	expected := "syntax error: expected expression\n" +
+		" --> test.lox:2:7\n" +
+		"  |\n" +
+		"2 | print 1 + ;\n" +
+		"  |       ^^^\n" +
+		"  = help: add a value\n"
+	if got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestRenderMultilineNote(t *testing.T) {
+	d := Diagnostic{Message: "failed", Span: span(1, 1, 1), Notes: []string{"first\nsecond"}}
+	got := render(t, d, "x")
+	expected := "error: failed\n" +
+		" --> test.lox:1:1\n" +
+		"  |\n" +
+		"1 | x\n" +
+		"  | ^\n" +
+		"  = note: first\n" +
+		"          second\n"
+	if got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestRenderTabs(t *testing.T) {
+	d := Diagnostic{Message: "bad", Span: span(1, 2, 4)}
+	got := render(t, d, "\tab\n")
+	if !strings.Contains(got, "1 |     ab\n  |     ^^\n") {
+		t.Errorf("tabs are not expanded consistently:\n%s", got)
+	}
+}
+
+func TestRenderWithoutSource(t *testing.T) {
+	got := render(t, Diagnostic{Message: "lost", Span: LineSpan(3)}, "one line")
+	if expected := "error: lost\n --> test.lox:3\n"; got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+	got = render(t, Diagnostic{Message: "nowhere"}, "")
+	if expected := "error: nowhere\n"; got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+type linedError struct{}
+
+func (linedError) Error() string { return "lined" }
+func (linedError) Line() int     { return 4 }
+
+func TestFromError(t *testing.T) {
+	if d := FromError(errors.New("plain")); d.Message != "plain" || d.Span != (token.Span{}) {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+	if d := FromError(linedError{}); d.Span.Start.Line != 4 || d.Span.Start.Column != 0 {
+		t.Errorf("unexpected diagnostic %+v", d)
+	}
+}
@@ -0,0 +1,126 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Format selects how diagnostics are reported: rendered for humans, or as JSON or SARIF for
+// tools such as CI annotators.
+type Format int
+
+const (
+	Text Format = iota
+	JSON
+	SARIF
+)
+
+func (f *Format) String() string {
+	switch *f {
+	case JSON:
+		return "json"
+	case SARIF:
+		return "sarif"
+	default:
+		return "text"
+	}
+}
+
+func (f *Format) Set(s string) error {
+	switch s {
+	case "text":
+		*f = Text
+	case "json":
+		*f = JSON
+	case "sarif":
+		*f = SARIF
+	default:
+		return fmt.Errorf("unknown diagnostics format '%s', expected 'text', 'json' or 'sarif'", s)
+	}
+	return nil
+}
+
+// Reporter writes out the diagnostics for a file, given the source read so far. Flush must be
+// called once done, as some formats can only be written once every diagnostic is known.
+type Reporter interface {
+	Report(d Diagnostic, source string)
+	Flush() error
+}
+
+// NewReporter returns a reporter writing diagnostics about the given file in the given format.
+// An empty filename stands for stdin.
+func NewReporter(format Format, w io.Writer, filename string) Reporter {
+	switch format {
+	case JSON:
+		if filename == "" {
+			filename = "<stdin>"
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		return &jsonReporter{encoder: encoder, filename: filename}
+	case SARIF:
+		return &sarifReporter{w: w, filename: filename}
+	default:
+		return &textReporter{w: w, renderer: Renderer{Filename: filename, Color: UseColor(w)}}
+	}
+}
+
+type textReporter struct {
+	w        io.Writer
+	renderer Renderer
+	err      error
+}
+
+func (r *textReporter) Report(d Diagnostic, source string) {
+	if err := r.renderer.Render(r.w, d, source); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *textReporter) Flush() error {
+	return r.err
+}
+
+// jsonDiagnostic is how a diagnostic is written as JSON. Lines and columns are zero when unknown.
+type jsonDiagnostic struct {
+	File      string   `json:"file"`
+	Line      int      `json:"line"`
+	Column    int      `json:"column"`
+	EndLine   int      `json:"endLine"`
+	EndColumn int      `json:"endColumn"`
+	Severity  string   `json:"severity"`
+	Code      string   `json:"code,omitempty"`
+	Message   string   `json:"message"`
+	Notes     []string `json:"notes,omitempty"`
+	Hints     []string `json:"hints,omitempty"`
+}
+
+// jsonReporter writes each diagnostic as soon as it is reported, as a JSON object on its own line.
+type jsonReporter struct {
+	encoder  *json.Encoder
+	filename string
+	err      error
+}
+
+func (r *jsonReporter) Report(d Diagnostic, source string) {
+	err := r.encoder.Encode(jsonDiagnostic{
+		File:      r.filename,
+		Line:      d.Span.Start.Line,
+		Column:    d.Span.Start.Column,
+		EndLine:   d.Span.End.Line,
+		EndColumn: d.Span.End.Column,
+		Severity:  d.Severity.String(),
+		Code:      d.Code,
+		Message:   d.Message,
+		Notes:     d.Notes,
+		Hints:     d.Hints,
+	})
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *jsonReporter) Flush() error {
+	return r.err
+}
@@ -0,0 +1,65 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONReporter(t *testing.T) {
+	var b strings.Builder
+	r := NewReporter(JSON, &b, "")
+	r.Report(Diagnostic{Code: CodeSyntax, Message: "first", Span: span(1, 2, 3)}, "")
+	r.Report(Diagnostic{Message: "second"}, "")
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"file":"<stdin>","line":1,"column":2,"endLine":1,"endColumn":3,"severity":"error","code":"E0002","message":"first"}` + "\n" +
+		`{"file":"<stdin>","line":0,"column":0,"endLine":0,"endColumn":0,"severity":"error","message":"second"}` + "\n"
+	if got := b.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestSARIFReporter(t *testing.T) {
+	var b strings.Builder
+	r := NewReporter(SARIF, &b, "dir/my script.lox")
+	r.Report(Diagnostic{Code: CodeRuntime, Message: "failed", Span: span(3, 5, 8)}, "")
+	r.Report(Diagnostic{Message: "nowhere"}, "")
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal([]byte(b.String()), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 {
+		t.Fatalf("expected one run with two results, got %s", b.String())
+	}
+	result := log.Runs[0].Results[0]
+	if result.RuleID != CodeRuntime || result.Level != "error" || result.Message.Text != "failed" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	location := result.Locations[0].PhysicalLocation
+	if location.ArtifactLocation.URI != "dir/my%20script.lox" {
+		t.Errorf("unexpected uri %s", location.ArtifactLocation.URI)
+	}
+	if location.Region != (sarifRegion{StartLine: 3, StartColumn: 5, EndLine: 3, EndColumn: 8}) {
+		t.Errorf("unexpected region %+v", location.Region)
+	}
+	if len(log.Runs[0].Results[1].Locations) != 0 {
+		t.Errorf("expected no location for a diagnostic without a span")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	var f Format
+	for _, name := range []string{"text", "json", "sarif"} {
+		if err := f.Set(name); err != nil || f.String() != name {
+			t.Errorf("expected format %s, got %s (%v)", name, f.String(), err)
+		}
+	}
+	if err := f.Set("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,118 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+)
+
+// The parts of SARIF 2.1.0 needed to report diagnostics. Columns count code points, like those
+// of token.Position.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool     `json:"tool"`
+	ColumnKind string        `json:"columnKind"`
+	Results    []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId,omitempty"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation *sarifArtifactLocation `json:"artifactLocation,omitempty"`
+	Region           sarifRegion            `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+// rules describes the codes diagnostics can have.
+var rules = []sarifRule{
+	{CodeLexical, "LexicalError", sarifMessage{"The source contains characters that do not form a valid token."}},
+	{CodeSyntax, "SyntaxError", sarifMessage{"The tokens do not form a valid statement."}},
+	{CodeResolution, "ResolutionError", sarifMessage{"A statement is valid, but not where it is used."}},
+	{CodeCompile, "CompileError", sarifMessage{"A statement goes over the limits of the bytecode compiler."}},
+	{CodeRuntime, "RuntimeError", sarifMessage{"An error happened while running the script."}},
+}
+
+// sarifReporter collects diagnostics, and writes them as a single SARIF log when flushed.
+type sarifReporter struct {
+	w        io.Writer
+	filename string
+	results  []sarifResult
+}
+
+func (r *sarifReporter) Report(d Diagnostic, source string) {
+	result := sarifResult{RuleID: d.Code, Level: d.Severity.String(), Message: sarifMessage{d.Message}}
+	if start, end := d.Span.Start, d.Span.End; start.Line > 0 {
+		location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+			Region: sarifRegion{StartLine: start.Line, StartColumn: start.Column, EndLine: end.Line, EndColumn: end.Column},
+		}}
+		if r.filename != "" {
+			uri := url.URL{Path: filepath.ToSlash(r.filename)}
+			location.PhysicalLocation.ArtifactLocation = &sarifArtifactLocation{URI: uri.String()}
+		}
+		result.Locations = []sarifLocation{location}
+	}
+	r.results = append(r.results, result)
+}
+
+func (r *sarifReporter) Flush() error {
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool:       sarifTool{Driver: sarifDriver{Name: "lox", Rules: rules}},
+			ColumnKind: "unicodeCodePoints",
+			Results:    r.results,
+		}},
+	}
+	if log.Runs[0].Results == nil {
+		log.Runs[0].Results = []sarifResult{}
+	}
+	encoder := json.NewEncoder(r.w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(log)
+}
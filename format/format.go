@@ -3,62 +3,254 @@ package format
 import (
 	"fmt"
 	"lox/ast"
+	"lox/token"
+	"strconv"
 	"strings"
 )
 
+// BraceStyle is where opening braces go.
+type BraceStyle int
+
+const (
+	// NextLine puts opening braces on a line of their own, as well as else, catch and finally.
+	NextLine BraceStyle = iota
+	// SameLine puts opening braces at the end of the line before them, and else, catch and
+	// finally right after the closing brace before them.
+	SameLine
+)
+
+func (b *BraceStyle) String() string {
+	switch *b {
+	case SameLine:
+		return "same-line"
+	default:
+		return "next-line"
+	}
+}
+
+func (b *BraceStyle) Set(s string) error {
+	switch s {
+	case "next-line":
+		*b = NextLine
+	case "same-line":
+		*b = SameLine
+	default:
+		return fmt.Errorf("unknown brace style '%s', expected 'next-line' or 'same-line'", s)
+	}
+	return nil
+}
+
+// Style is how the formatter lays out code. The zero style indents with tabs, puts braces on
+// lines of their own, and never wraps lines.
+type Style struct {
+	// Spaces indents with spaces instead of tabs.
+	Spaces bool
+	// IndentWidth is how many columns a level of indentation takes, 4 if zero. Tabs count as
+	// that wide when measuring lines.
+	IndentWidth int
+	Braces      BraceStyle
+	// MaxWidth is how many columns lines should fit in, by putting the arguments of calls on
+	// lines of their own when they do not. Zero means lines are never wrapped.
+	MaxWidth int
+}
+
+func (s Style) indentWidth() int {
+	if s.IndentWidth <= 0 {
+		return 4
+	}
+	return s.IndentWidth
+}
+
+// Formatter turns statements back into source, in a canonical layout. When formatting a whole
+// program, comments holds those that are not placed yet, in the order they appear in.
 type Formatter struct {
 	indentation int
+	comments    []token.Comment
+	style       Style
 }
 
 func (f *Formatter) fmtExpr(expr ast.Expr) string {
 	return expr.AcceptExpr(f).(string)
 }
 
+func (f *Formatter) fmtStmt(stmt ast.Stmt) string {
+	return stmt.AcceptStmt(f).(string)
+}
+
 func NewFormatter() *Formatter {
 	return &Formatter{}
 }
 
+func (f *Formatter) SetStyle(style Style) {
+	f.style = style
+}
+
 func (f *Formatter) Format(stmt ast.Stmt) string {
 	builder := strings.Builder{}
 	f.indent(&builder)
 	builder.WriteString(stmt.AcceptStmt(f).(string))
-	return builder.String()
+	return f.wrap(builder.String())
 }
 
-func (f *Formatter) block(stmts func() []ast.Stmt) string {
+// FormatProgram formats a whole program, one statement per line. Its comments are kept, as
+// are the blank lines grouping statements, though several blank lines in a row become one.
+func (f *Formatter) FormatProgram(program *ast.Program) string {
+	f.comments = program.Comments
+	lines := f.lines(program.Statements, program.End(), f.fmtStmt)
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// lines formats statements one per line, followed by the comments left before end, where
+// the enclosing block ends. Comments go on their own line before the statement they precede,
+// except those following a statement on its last line. Comments inside a statement that no
+// nested block took are moved before it.
+func (f *Formatter) lines(stmts []ast.Stmt, end token.Position, format func(ast.Stmt) string) []string {
+	var lines []string
+	previous := 0
+	// Lines are separated by a blank line when what they hold was.
+	add := func(text string, start int, end int) {
+		if previous > 0 && start > previous+1 {
+			lines = append(lines, "")
+		}
+		builder := strings.Builder{}
+		f.indent(&builder)
+		builder.WriteString(text)
+		lines = append(lines, f.wrap(builder.String()))
+		previous = end
+	}
+	for i, stmt := range stmts {
+		for _, comment := range f.commentsBefore(stmt.Start()) {
+			add(comment.Text, comment.Span.Start.Line, comment.Span.End.Line)
+		}
+		// Blocks start on a line of their own, which is already the case here.
+		text := strings.TrimLeft(format(stmt), "\n\t ")
+		start := stmt.Start().Line
+		for _, comment := range f.commentsBefore(stmt.End()) {
+			add(comment.Text, start, start)
+		}
+		add(text, start, stmt.End().Line)
+		// The end of blocks made up around a single statement is unknown, as is where the
+		// comments after that statement stop being about it.
+		next := end
+		if i < len(stmts)-1 {
+			next = stmts[i+1].Start()
+		}
+		if len(f.comments) > 0 && f.comments[0].Span.Start.Line == stmt.End().Line &&
+			(next.Line == 0 || f.comments[0].Span.Start.Offset < next.Offset) {
+			lines[len(lines)-1] += " " + f.comments[0].Text
+			f.comments = f.comments[1:]
+		}
+	}
+	for _, comment := range f.commentsBefore(end) {
+		add(comment.Text, comment.Span.Start.Line, comment.Span.End.Line)
+	}
+	return lines
+}
+
+// commentsBefore takes the comments left that start before the given position.
+func (f *Formatter) commentsBefore(position token.Position) []token.Comment {
+	i := 0
+	for i < len(f.comments) && f.comments[i].Span.Start.Offset < position.Offset {
+		i++
+	}
+	comments := f.comments[:i]
+	f.comments = f.comments[i:]
+	return comments
+}
+
+func (f *Formatter) block(stmts []ast.Stmt, end token.Position) string {
+	return f.braced(func() []string { return f.lines(stmts, end, f.fmtStmt) })
+}
+
+// braced puts lines between braces, indenting them one more level than the braces.
+func (f *Formatter) braced(lines func() []string) string {
 	builder := strings.Builder{}
-	builder.WriteRune('\n')
-	f.indent(&builder)
+	if f.style.Braces == SameLine {
+		builder.WriteRune(' ')
+	} else {
+		builder.WriteRune('\n')
+		f.indent(&builder)
+	}
 	builder.WriteRune('{')
 	f.indentation++
-	for _, stmt := range stmts() {
+	for _, line := range lines() {
 		builder.WriteRune('\n')
-		builder.WriteString(f.Format(stmt))
+		builder.WriteString(line)
 	}
+	f.indentation--
 	builder.WriteRune('\n')
+	f.indent(&builder)
 	builder.WriteRune('}')
 	return builder.String()
 }
 
+// clause starts a part of a statement following a block, like else.
+func (f *Formatter) clause(builder *strings.Builder, keyword string) {
+	if f.style.Braces == SameLine {
+		builder.WriteRune(' ')
+	} else {
+		builder.WriteRune('\n')
+		f.indent(builder)
+	}
+	builder.WriteString(keyword)
+}
+
+// asBlock wraps a single statement in a block, so that branches and loop bodies are always braced.
+func asBlock(stmt ast.Stmt) *ast.BlockStmt {
+	if block, ok := stmt.(*ast.BlockStmt); ok {
+		return block
+	}
+	return &ast.BlockStmt{Statements: []ast.Stmt{stmt}}
+}
+
 func (f *Formatter) indent(builder *strings.Builder) {
+	unit := "\t"
+	if f.style.Spaces {
+		unit = strings.Repeat(" ", f.style.indentWidth())
+	}
 	for i := 0; i < f.indentation; i++ {
-		builder.WriteRune('\t')
+		builder.WriteString(unit)
 	}
 }
 
 func (f *Formatter) VisitFunDeclStmt(stmt *ast.FunDeclStmt) interface{} {
+	return "fun " + stmt.Name.Lexeme + f.function(stmt.Params, stmt.Body)
+}
+
+func (f *Formatter) function(params []token.Token, body *ast.BlockStmt) string {
 	builder := strings.Builder{}
-	builder.WriteString("fun ")
-	builder.WriteString(stmt.Name.Lexeme)
 	builder.WriteRune('(')
-	for i, param := range stmt.Params {
+	for i, param := range params {
 		if i > 0 {
 			builder.WriteString(", ")
 		}
 		builder.WriteString(param.Lexeme)
 	}
 	builder.WriteRune(')')
-	builder.WriteString(f.Format(stmt.Body))
+	builder.WriteString(f.fmtStmt(body))
+	return builder.String()
+}
+
+func (f *Formatter) VisitClassStmt(stmt *ast.ClassStmt) interface{} {
+	builder := strings.Builder{}
+	builder.WriteString("class ")
+	builder.WriteString(stmt.Name.Lexeme)
+	if stmt.Superclass != nil {
+		builder.WriteString(" < ")
+		builder.WriteString(f.fmtExpr(stmt.Superclass))
+	}
+	methods := make([]ast.Stmt, len(stmt.Methods))
+	for i, method := range stmt.Methods {
+		methods[i] = method
+	}
+	method := func(s ast.Stmt) string {
+		method := s.(*ast.FunDeclStmt)
+		return method.Name.Lexeme + f.function(method.Params, method.Body)
+	}
+	builder.WriteString(f.braced(func() []string { return f.lines(methods, stmt.End(), method) }))
 	return builder.String()
 }
 
@@ -66,7 +258,7 @@ func (f *Formatter) VisitVarDeclStmt(stmt *ast.VarDeclStmt) interface{} {
 	builder := strings.Builder{}
 	builder.WriteString("var ")
 	builder.WriteString(stmt.Name.Lexeme)
-	if stmt.Initializer != nil {
+	if stmt.Initializer != nil && *stmt.Initializer != nil {
 		builder.WriteString(" = ")
 		builder.WriteString(f.fmtExpr(*stmt.Initializer))
 	}
@@ -75,7 +267,7 @@ func (f *Formatter) VisitVarDeclStmt(stmt *ast.VarDeclStmt) interface{} {
 }
 
 func (f *Formatter) VisitBlockStmt(stmt *ast.BlockStmt) interface{} {
-	return f.block(func() []ast.Stmt { return stmt.Statements })
+	return f.block(stmt.Statements, stmt.End())
 }
 
 func (f *Formatter) VisitExprStmt(stmt *ast.ExprStmt) interface{} {
@@ -90,21 +282,10 @@ func (f *Formatter) VisitIfStmt(stmt *ast.IfStmt) interface{} {
 	builder.WriteString("if (")
 	builder.WriteString(f.fmtExpr(stmt.Condition))
 	builder.WriteRune(')')
-	thenBlock := *stmt.ThenBranch
-	if _, ok := thenBlock.(*ast.BlockStmt); !ok {
-		thenBlock = &ast.BlockStmt{Statements: []ast.Stmt{thenBlock}}
-	}
-	builder.WriteString(f.Format(thenBlock))
+	builder.WriteString(f.fmtStmt(asBlock(*stmt.ThenBranch)))
 	if stmt.ElseBranch != nil {
-		builder.WriteRune('\n')
-		f.indent(&builder)
-		builder.WriteString("else")
-
-		elseBlock := *stmt.ElseBranch
-		if _, ok := elseBlock.(*ast.BlockStmt); !ok {
-			elseBlock = &ast.BlockStmt{Statements: []ast.Stmt{elseBlock}}
-		}
-		builder.WriteString(f.Format(elseBlock))
+		f.clause(&builder, "else")
+		builder.WriteString(f.fmtStmt(asBlock(*stmt.ElseBranch)))
 	}
 	return builder.String()
 }
@@ -127,8 +308,11 @@ func (f *Formatter) VisitAssertStmt(stmt *ast.AssertStmt) interface{} {
 
 func (f *Formatter) VisitReturnStmt(stmt *ast.ReturnStmt) interface{} {
 	builder := strings.Builder{}
-	builder.WriteString("return ")
-	builder.WriteString(f.fmtExpr(*stmt.Value))
+	builder.WriteString("return")
+	if stmt.Value != nil && *stmt.Value != nil {
+		builder.WriteRune(' ')
+		builder.WriteString(f.fmtExpr(*stmt.Value))
+	}
 	builder.WriteRune(';')
 	return builder.String()
 }
@@ -142,10 +326,59 @@ func (f *Formatter) VisitWhileStmt(stmt *ast.WhileStmt) interface{} {
 	builder.WriteString("while (")
 	builder.WriteString(f.fmtExpr(stmt.Condition))
 	builder.WriteRune(')')
-	builder.WriteString(f.Format(stmt.Body))
+	builder.WriteString(f.fmtStmt(asBlock(stmt.Body)))
+	return builder.String()
+}
+
+func (f *Formatter) VisitForStmt(stmt *ast.ForStmt) interface{} {
+	builder := strings.Builder{}
+	builder.WriteString("for (")
+	if stmt.Initializer != nil {
+		builder.WriteString(f.fmtStmt(stmt.Initializer))
+	} else {
+		builder.WriteRune(';')
+	}
+	if stmt.Condition != nil {
+		builder.WriteRune(' ')
+		builder.WriteString(f.fmtExpr(stmt.Condition))
+	}
+	builder.WriteRune(';')
+	if stmt.Increment != nil {
+		builder.WriteRune(' ')
+		builder.WriteString(f.fmtExpr(stmt.Increment))
+	}
+	builder.WriteRune(')')
+	builder.WriteString(f.fmtStmt(asBlock(stmt.Body)))
+	return builder.String()
+}
+
+func (f *Formatter) VisitThrowStmt(stmt *ast.ThrowStmt) interface{} {
+	return "throw " + f.fmtExpr(stmt.Value) + ";"
+}
+
+func (f *Formatter) VisitTryStmt(stmt *ast.TryStmt) interface{} {
+	builder := strings.Builder{}
+	builder.WriteString("try")
+	builder.WriteString(f.fmtStmt(stmt.Body))
+	if stmt.Catch != nil {
+		f.clause(&builder, "catch ("+stmt.CatchName.Lexeme+")")
+		builder.WriteString(f.fmtStmt(stmt.Catch))
+	}
+	if stmt.Finally != nil {
+		f.clause(&builder, "finally")
+		builder.WriteString(f.fmtStmt(stmt.Finally))
+	}
 	return builder.String()
 }
 
+func (f *Formatter) VisitBreakStmt(stmt *ast.BreakStmt) interface{} {
+	return "break;"
+}
+
+func (f *Formatter) VisitContinueStmt(stmt *ast.ContinueStmt) interface{} {
+	return "continue;"
+}
+
 func (f *Formatter) VisitAssignmentExpr(expr *ast.AssignmentExpr) interface{} {
 	builder := strings.Builder{}
 	builder.WriteString(expr.Name.Lexeme)
@@ -178,16 +411,34 @@ func (f *Formatter) VisitCallExpr(expr *ast.CallExpr) interface{} {
 	builder := strings.Builder{}
 	builder.WriteString(f.fmtExpr(expr.Callee))
 	builder.WriteRune('(')
+	// Arguments can be wrapped, see wrap.
+	wrappable := f.style.MaxWidth > 0 && len(expr.Arguments) > 0
+	if wrappable {
+		builder.WriteString(groupStart)
+	}
 	for i, arg := range expr.Arguments {
-		if i > 0 {
+		if i > 0 && wrappable {
+			builder.WriteString("," + groupBreak)
+		} else if i > 0 {
 			builder.WriteString(", ")
 		}
 		builder.WriteString(f.fmtExpr(arg))
 	}
+	if wrappable {
+		builder.WriteString(groupEnd)
+	}
 	builder.WriteRune(')')
 	return builder.String()
 }
 
+func (f *Formatter) VisitFunctionExpr(expr *ast.FunctionExpr) interface{} {
+	return "fun " + f.function(expr.Params, expr.Body)
+}
+
+func (f *Formatter) VisitGetExpr(expr *ast.GetExpr) interface{} {
+	return f.fmtExpr(expr.Object) + "." + expr.Name.Lexeme
+}
+
 func (f *Formatter) VisitGroupingExpr(expr *ast.GroupingExpr) interface{} {
 	builder := strings.Builder{}
 	builder.WriteRune('(')
@@ -196,10 +447,45 @@ func (f *Formatter) VisitGroupingExpr(expr *ast.GroupingExpr) interface{} {
 	return builder.String()
 }
 
+func (f *Formatter) VisitIndexExpr(expr *ast.IndexExpr) interface{} {
+	return f.fmtExpr(expr.Object) + "[" + f.fmtExpr(expr.Index) + "]"
+}
+
+func (f *Formatter) VisitListExpr(expr *ast.ListExpr) interface{} {
+	builder := strings.Builder{}
+	builder.WriteRune('[')
+	for i, element := range expr.Elements {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(f.fmtExpr(element))
+	}
+	builder.WriteRune(']')
+	return builder.String()
+}
+
+func (f *Formatter) VisitMapExpr(expr *ast.MapExpr) interface{} {
+	builder := strings.Builder{}
+	builder.WriteRune('{')
+	for i, key := range expr.Keys {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(f.fmtExpr(key))
+		builder.WriteString(": ")
+		builder.WriteString(f.fmtExpr(expr.Values[i]))
+	}
+	builder.WriteRune('}')
+	return builder.String()
+}
+
 func (f *Formatter) VisitLiteralExpr(expr *ast.LiteralExpr) interface{} {
-	switch expr.Value.(type) {
+	// Strings have no escape sequences, and numbers no exponent.
+	switch value := expr.Value.(type) {
 	case string:
-		return fmt.Sprintf("%q", expr.Value)
+		return `"` + value + `"`
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
 	case nil:
 		return "nil"
 	default:
@@ -207,6 +493,28 @@ func (f *Formatter) VisitLiteralExpr(expr *ast.LiteralExpr) interface{} {
 	}
 }
 
+func (f *Formatter) VisitSetExpr(expr *ast.SetExpr) interface{} {
+	builder := strings.Builder{}
+	builder.WriteString(f.fmtExpr(expr.Object))
+	builder.WriteRune('.')
+	builder.WriteString(expr.Name.Lexeme)
+	builder.WriteString(" = ")
+	builder.WriteString(f.fmtExpr(expr.Value))
+	return builder.String()
+}
+
+func (f *Formatter) VisitSetIndexExpr(expr *ast.SetIndexExpr) interface{} {
+	return f.fmtExpr(expr.Object) + "[" + f.fmtExpr(expr.Index) + "] = " + f.fmtExpr(expr.Value)
+}
+
+func (f *Formatter) VisitSuperExpr(expr *ast.SuperExpr) interface{} {
+	return expr.Keyword.Lexeme + "." + expr.Method.Lexeme
+}
+
+func (f *Formatter) VisitThisExpr(expr *ast.ThisExpr) interface{} {
+	return expr.Keyword.Lexeme
+}
+
 func (f *Formatter) VisitUnaryExpr(expr *ast.UnaryExpr) interface{} {
 	builder := strings.Builder{}
 	builder.WriteString(expr.Operator.Lexeme)
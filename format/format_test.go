@@ -0,0 +1,74 @@
+package format
+
+import (
+	"bufio"
+	"lox/parser"
+	"lox/scanner"
+	"strings"
+	"testing"
+)
+
+func TestFormatProgramKeepsComments(t *testing.T) {
+	expectProgram(t, "// header\n\n// about a\nvar a = 1; // one\nfun f() { // f\n  // inside\n  return; // done\n  // last\n}\n// the end\n",
+		"// header\n\n// about a\nvar a = 1; // one\nfun f()\n{\n\t// f\n\t// inside\n\treturn; // done\n\t// last\n}\n// the end\n")
+}
+
+func TestFormatProgramMovesCommentsOutOfExpressions(t *testing.T) {
+	expectProgram(t, "print 1;\nprint f(\n  1, // one\n  2);\n", "print 1;\n// one\nprint f(1, 2);\n")
+}
+
+func TestFormatProgramKeepsBlankLines(t *testing.T) {
+	expectProgram(t, "var a;\n\n\n\nvar b;\nvar c;\n{\n\n  a;\n\n  b;\n\n}\n", "var a;\n\nvar b;\nvar c;\n{\n\ta;\n\n\tb;\n}\n")
+}
+
+func TestFormatProgramSingleStatementBranches(t *testing.T) {
+	expectProgram(t, "if (a) print 1; // one\nelse print 2;\n", "if (a)\n{\n\tprint 1; // one\n}\nelse\n{\n\tprint 2;\n}\n")
+}
+
+func TestFormatProgramLiterals(t *testing.T) {
+	expectProgram(t, "print \"C:\\dir\" + \"a\nb\";\nprint 1000000000000000000000;\nprint 0.50;\n",
+		"print \"C:\\dir\" + \"a\nb\";\nprint 1000000000000000000000;\nprint 0.5;\n")
+}
+
+func expectProgram(t *testing.T, src string, expected string) {
+	t.Helper()
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
+	program, errs := p.ParseProgram()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := NewFormatter().FormatProgram(program); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestFormatStyle(t *testing.T) {
+	src := "class A { m() { try { if (a) print 1; else print 2; } catch (e) {} finally {} } }\n"
+	expectStyled(t, Style{Spaces: true, IndentWidth: 2, Braces: SameLine}, src,
+		"class A {\n  m() {\n    try {\n      if (a) {\n        print 1;\n      } else {\n        print 2;\n      }\n    } catch (e) {\n    } finally {\n    }\n  }\n}\n")
+	expectStyled(t, Style{Spaces: true}, "{ print 1; }\n", "{\n    print 1;\n}\n")
+}
+
+func TestFormatWrapsArguments(t *testing.T) {
+	style := Style{MaxWidth: 20}
+	expectStyled(t, style, "print f(1, 2);\n", "print f(1, 2);\n")
+	expectStyled(t, style, "print f(one, g(two, three), four);\n", "print f(\n\tone,\n\tg(two, three),\n\tfour\n);\n")
+	expectStyled(t, style, "{ print f(alpha, beta); }\n", "{\n\tprint f(\n\t\talpha,\n\t\tbeta\n\t);\n}\n")
+	expectStyled(t, style, "short(1) + long(alphabet, beta);\n", "short(1) + long(\n\talphabet,\n\tbeta\n);\n")
+	// Arguments that are still too long are wrapped in turn.
+	expectStyled(t, style, "f(g(alphabet, betamax, gamma));\n", "f(\n\tg(\n\t\talphabet,\n\t\tbetamax,\n\t\tgamma\n\t)\n);\n")
+}
+
+func expectStyled(t *testing.T, style Style, src string, expected string) {
+	t.Helper()
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
+	program, errs := p.ParseProgram()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	f := NewFormatter()
+	f.SetStyle(style)
+	if got := f.FormatProgram(program); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
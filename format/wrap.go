@@ -0,0 +1,104 @@
+package format
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// These markers delimit the arguments of calls in formatted lines, until wrap decides whether
+// to put them on lines of their own. They are not valid UTF-8, so they never appear in source.
+const (
+	groupStart = "\xff\x01"
+	groupBreak = "\xff\x02"
+	groupEnd   = "\xff\x03"
+)
+
+var flattener = strings.NewReplacer(groupStart, "", groupBreak, " ", groupEnd, "")
+
+// flatten lays out the groups of a line without breaking them.
+func flatten(line string) string {
+	return flattener.Replace(line)
+}
+
+// wrap lays out the groups of arguments in formatted text. Each line wider than the maximum
+// width gets one of its groups broken, which puts every argument on a line of its own indented
+// one level more, and the closing parenthesis on the line after; the lines this makes are then
+// wrapped in turn. Groups that are not broken stay on one line.
+func (f *Formatter) wrap(text string) string {
+	if !strings.Contains(text, groupStart) {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	var wrapped []string
+	for len(lines) > 0 {
+		line := lines[0]
+		lines = lines[1:]
+		if start, end := f.breakable(line); start >= 0 {
+			lines = append(f.breakGroup(line, start, end), lines...)
+		} else {
+			wrapped = append(wrapped, flatten(line))
+		}
+	}
+	return strings.Join(wrapped, "\n")
+}
+
+// breakable returns where the group to break in a line starts and ends, or -1 if the line fits
+// or has no group. Only groups that are not nested in another, and that start and end on the
+// line, are candidates: the first one whose closing parenthesis would be past the maximum width
+// is chosen, or else the last one.
+func (f *Formatter) breakable(line string) (int, int) {
+	if f.width(flatten(line)) <= f.style.MaxWidth {
+		return -1, -1
+	}
+	depth, start := 0, -1
+	chosenStart, chosenEnd := -1, -1
+	for i := 0; i < len(line); i++ {
+		switch {
+		case strings.HasPrefix(line[i:], groupStart):
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case strings.HasPrefix(line[i:], groupEnd) && depth > 0:
+			depth--
+			if depth == 0 {
+				chosenStart, chosenEnd = start, i
+				if f.width(flatten(line[:i])) >= f.style.MaxWidth {
+					return chosenStart, chosenEnd
+				}
+			}
+		}
+	}
+	return chosenStart, chosenEnd
+}
+
+// breakGroup splits a line at the group between the given markers, one argument per line.
+func (f *Formatter) breakGroup(line string, start int, end int) []string {
+	indentation := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	unit := "\t"
+	if f.style.Spaces {
+		unit = strings.Repeat(" ", f.style.indentWidth())
+	}
+	lines := []string{line[:start]}
+	content := line[start+len(groupStart) : end]
+	depth, from := 0, 0
+	for i := 0; i < len(content); i++ {
+		switch {
+		case strings.HasPrefix(content[i:], groupStart):
+			depth++
+		case strings.HasPrefix(content[i:], groupEnd):
+			depth--
+		case strings.HasPrefix(content[i:], groupBreak) && depth == 0:
+			lines = append(lines, indentation+unit+content[from:i])
+			from = i + len(groupBreak)
+		}
+	}
+	lines = append(lines, indentation+unit+content[from:])
+	return append(lines, indentation+line[end+len(groupEnd):])
+}
+
+// width returns how many columns a line takes, tabs being as wide as a level of indentation.
+func (f *Formatter) width(line string) int {
+	tabs := strings.Count(line, "\t")
+	return utf8.RuneCountInString(line) - tabs + tabs*f.style.indentWidth()
+}
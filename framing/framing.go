@@ -0,0 +1,53 @@
+// Package framing reads and writes messages the way the Language Server and Debug Adapter
+// protocols send them over a stream: each is JSON preceded by a header giving its length in
+// bytes.
+package framing
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// MaxLength is the length of the largest message Read accepts, so that a wrong header cannot
+// have it allocate any amount of memory.
+const MaxLength = 16 << 20
+
+// Read returns the content of the next message, or io.EOF if there are none left.
+func Read(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid message header: %v", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid message length '%s'", header.Get("Content-Length"))
+	}
+	if length > MaxLength {
+		return nil, fmt.Errorf("message length %d is over the limit of %d bytes", length, MaxLength)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, fmt.Errorf("truncated message: %v", err)
+	}
+	return content, nil
+}
+
+// Write writes a message encoded as JSON.
+func Write(w io.Writer, message interface{}) error {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
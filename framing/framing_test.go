@@ -0,0 +1,39 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	out := bytes.Buffer{}
+	if err := Write(&out, map[string]int{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(&out)
+	if content, err := Read(r); err != nil || string(content) != `{"id":1}` {
+		t.Errorf("expected the message back, got '%s' (%v)", content, err)
+	}
+	if _, err := Read(r); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	expectReadError(t, "Content-Length: -1\r\n\r\n", "invalid message length '-1'")
+	expectReadError(t, "Content-Type: json\r\n\r\n{}", "invalid message length ''")
+	expectReadError(t, fmt.Sprintf("Content-Length: %d\r\n\r\n{}", MaxLength+1),
+		fmt.Sprintf("message length %d is over the limit of %d bytes", MaxLength+1, MaxLength))
+	expectReadError(t, "Content-Length: 10\r\n\r\n{}", "truncated message: unexpected EOF")
+}
+
+func expectReadError(t *testing.T, input string, message string) {
+	t.Helper()
+	if _, err := Read(bufio.NewReader(strings.NewReader(input))); err == nil || err.Error() != message {
+		t.Errorf("expected error '%s', got %v", message, err)
+	}
+}
@@ -0,0 +1,80 @@
+package interpreter
+
+import (
+	"fmt"
+	"lox/token"
+	"sort"
+)
+
+type class struct {
+	name       string
+	superclass *class
+	methods    map[string]*function
+}
+
+func (c *class) findMethod(name string) (*function, bool) {
+	if method, ok := c.methods[name]; ok {
+		return method, true
+	}
+	if c.superclass != nil {
+		return c.superclass.findMethod(name)
+	}
+	return nil, false
+}
+
+func (c *class) Arity() int {
+	if initializer, ok := c.findMethod("init"); ok {
+		return initializer.Arity()
+	}
+	return 0
+}
+
+func (c *class) Call(i *Interpreter, at token.Span, arguments []interface{}) interface{} {
+	instance := &instance{class: c, fields: make(map[string]interface{})}
+	if initializer, ok := c.findMethod("init"); ok {
+		initializer.bind(instance).Call(i, at, arguments)
+	}
+	return instance
+}
+
+func (c *class) String() string {
+	return c.name
+}
+
+type instance struct {
+	class  *class
+	fields map[string]interface{}
+}
+
+func (o *instance) get(name token.Token) interface{} {
+	if value, ok := o.fields[name.Lexeme]; ok {
+		return value
+	}
+	if method, ok := o.class.findMethod(name.Lexeme); ok {
+		return method.bind(o)
+	}
+	panic(&RuntimeError{span: name.Span, message: fmt.Sprintf("undefined property '%s'", name.Lexeme)})
+}
+
+func (o *instance) set(name token.Token, value interface{}) {
+	o.fields[name.Lexeme] = value
+}
+
+func (o *instance) String() string {
+	return fmt.Sprintf("%s instance", o.class.name)
+}
+
+// Fields returns the fields of an instance sorted by name, for debuggers, or false if the value
+// is not an instance.
+func Fields(value interface{}) ([]Variable, bool) {
+	o, ok := value.(*instance)
+	if !ok {
+		return nil, false
+	}
+	fields := make([]Variable, 0, len(o.fields))
+	for name, value := range o.fields {
+		fields = append(fields, Variable{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(a, b int) bool { return fields[a].Name < fields[b].Name })
+	return fields, true
+}
@@ -1,53 +1,103 @@
 package interpreter
 
+import (
+	"lox/token"
+	"sort"
+)
+
+// Env holds the variables declared in a scope. Local variables live in values, in the order in
+// which they are declared, and are accessed through the slot the resolver assigned to them.
+// Global variables are never resolved, so the global environment keeps them by name instead.
+// The names of local variables are only kept, in slots, for debuggers.
 type Env struct {
 	parent *Env
-	values map[string]interface{}
+	values []interface{}
+	slots  []string
+	names  map[string]interface{}
+}
+
+// Variable is a variable of an environment, as listed by debuggers.
+type Variable struct {
+	Name  string
+	Value interface{}
 }
 
 func NewGlobalEnv() *Env {
-	return &Env{values: make(map[string]interface{})}
+	return &Env{names: make(map[string]interface{})}
 }
 
 func NewEnv(parent *Env) *Env {
-	return &Env{parent: parent, values: make(map[string]interface{})}
+	return &Env{parent: parent}
 }
 
-func (e *Env) Define(name string, initializer func() interface{}) {
-	if _, ok := e.values[name]; ok {
-		panic(&RuntimeError{message: "variable already declared"})
+// Define declares a variable. Globals can only be declared once, and declaring one again fails
+// at the span of its name.
+func (e *Env) Define(name token.Token, initializer func() interface{}) {
+	if e.names == nil {
+		e.values = append(e.values, initializer())
+		e.slots = append(e.slots, name.Lexeme)
+		return
 	}
-	e.values[name] = initializer()
+	if _, ok := e.names[name.Lexeme]; ok {
+		panic(&RuntimeError{span: name.Span, message: "variable already declared"})
+	}
+	e.names[name.Lexeme] = initializer()
 }
 
-func (e *Env) Assign(name string, initializer func() interface{}) interface{} {
-	if _, ok := e.values[name]; ok {
+func (e *Env) Assign(name token.Token, initializer func() interface{}) interface{} {
+	if _, ok := e.names[name.Lexeme]; ok {
 		value := initializer()
-		e.values[name] = value
+		e.names[name.Lexeme] = value
 		return value
-	} else if e.parent != nil {
-		return e.parent.Assign(name, initializer)
 	} else {
-		panic(&RuntimeError{message: "variable not declared"})
+		panic(&RuntimeError{span: name.Span, message: "variable not declared"})
 	}
 }
 
-func (e *Env) AssignAt(distance int, name string, initializer func() interface{}) interface{} {
-	return e.ancestor(distance).Assign(name, initializer)
+func (e *Env) AssignAt(distance int, slot int, initializer func() interface{}) interface{} {
+	env := e.ancestor(distance)
+	value := initializer()
+	env.values[slot] = value
+	return value
 }
 
-func (e *Env) Get(name string) interface{} {
-	if value, ok := e.values[name]; ok {
+func (e *Env) Get(name token.Token) interface{} {
+	if value, ok := e.names[name.Lexeme]; ok {
 		return value
-	} else if e.parent != nil {
-		return e.parent.Get(name)
 	} else {
-		panic(&RuntimeError{message: "variable not defined"})
+		panic(&RuntimeError{span: name.Span, message: "variable not defined"})
 	}
 }
 
-func (e *Env) GetAt(distance int, name string) interface{} {
-	return e.ancestor(distance).Get(name)
+func (e *Env) GetAt(distance int, slot int) interface{} {
+	return e.ancestor(distance).values[slot]
+}
+
+// Parent returns the enclosing environment, which is nil for the global one.
+func (e *Env) Parent() *Env {
+	return e.parent
+}
+
+// Names returns the names of the local variables of the environment, in the order of their slots.
+func (e *Env) Names() []string {
+	return e.slots
+}
+
+// Variables returns the variables of the environment: local ones in the order they were
+// declared, and global ones sorted by name.
+func (e *Env) Variables() []Variable {
+	var variables []Variable
+	if e.names == nil {
+		for slot, name := range e.slots {
+			variables = append(variables, Variable{Name: name, Value: e.values[slot]})
+		}
+		return variables
+	}
+	for name, value := range e.names {
+		variables = append(variables, Variable{Name: name, Value: value})
+	}
+	sort.Slice(variables, func(a, b int) bool { return variables[a].Name < variables[b].Name })
+	return variables
 }
 
 func (e *Env) ancestor(distance int) *Env {
@@ -3,171 +3,665 @@ package interpreter
 import (
 	"fmt"
 	"lox/ast"
+	"lox/builtin"
+	"lox/diagnostics"
 	"lox/token"
-	"time"
 )
 
+// Callable is implemented by the values scripts can call. Calls are made at the given span of
+// source, which is zero for calls made by the host.
 type Callable interface {
 	Arity() int
-	Call(*Interpreter, []interface{}) interface{}
+	Call(i *Interpreter, at token.Span, arguments []interface{}) interface{}
 }
 
 type function struct {
+	name    string
 	arity   int
 	closure *Env
 	call    func(*Interpreter, []interface{}) interface{}
 }
 
-func newFunction(arity int, closure *Env, call func(*Interpreter, []interface{}) interface{}) *function {
-	return &function{arity: arity, closure: closure, call: call}
+func newFunction(name string, arity int, closure *Env, call func(*Interpreter, []interface{}) interface{}) *function {
+	return &function{name: name, arity: arity, closure: closure, call: call}
 }
 
 func (b *function) Arity() int {
 	return b.arity
 }
 
-func (b *function) Call(i *Interpreter, arguments []interface{}) interface{} {
+// Call runs the function in a new frame. The frame is left on the stack if the call fails, so
+// that the trace of the error can be built from it once it is recovered.
+func (b *function) Call(i *Interpreter, at token.Span, arguments []interface{}) interface{} {
+	if len(i.frames) >= i.maxDepth {
+		panic(&RuntimeError{span: at, message: builtin.ErrCallDepth.Error(), err: builtin.ErrCallDepth})
+	}
+	previous := i.env
 	i.env = b.closure
+	defer func() {
+		i.env = previous
+	}()
+	i.frames = append(i.frames, frame{function: b.name, line: at.Start.Line, env: previous})
 	result := b.call(i, arguments)
+	i.frames = i.frames[:len(i.frames)-1]
 	return result
 }
 
+// bind returns a copy of the function whose closure defines `this` as the given instance.
+func (b *function) bind(instance *instance) *function {
+	env := NewEnv(b.closure)
+	env.Define(token.Token{Type: token.THIS, Lexeme: "this"}, func() interface{} {
+		return instance
+	})
+	return newFunction(b.name, b.arity, env, b.call)
+}
+
+func (b *function) String() string {
+	if b.name == "" {
+		return "<fn>"
+	}
+	return fmt.Sprintf("<fn %s>", b.name)
+}
+
+// native wraps a builtin function so that it can be called like any other Callable.
+type native struct {
+	builtin.Native
+}
+
+func (n *native) Arity() int {
+	return n.Native.Arity
+}
+
+// Call calls the builtin, reporting its errors as happening where it is called.
+func (n *native) Call(i *Interpreter, at token.Span, arguments []interface{}) interface{} {
+	if err := n.CheckArity(len(arguments)); err != nil {
+		panic(&RuntimeError{span: at, message: err.Error()})
+	}
+	result, err := n.Native.Call(arguments)
+	if err != nil {
+		panic(&RuntimeError{span: at, message: err.Error()})
+	}
+	return result
+}
+
+func (n *native) String() string {
+	return fmt.Sprintf("<fn %s>", n.Name)
+}
+
 type Return struct {
 	value interface{}
 }
 
+// Break and Continue unwind the statements of a loop body, like Return does for function bodies.
+type Break struct{}
+
+type Continue struct{}
+
+// Throw unwinds the interpreter up to the closest enclosing try statement.
+type Throw struct {
+	value interface{}
+	span  token.Span
+}
+
+// uncaught turns a value that was thrown and never caught into the error reported for it.
+func (t *Throw) uncaught() *RuntimeError {
+	if e, ok := t.value.(*builtin.Error); ok {
+		return &RuntimeError{span: e.Span, message: e.Message}
+	}
+	return &RuntimeError{span: t.span, message: "uncaught exception: " + builtin.Repr(t.value)}
+}
+
+// frame is a call to a function in progress, made on the given line from the given environment.
+type frame struct {
+	function string
+	line     int
+	env      *Env
+}
+
+// Frame is a call in progress as seen by debuggers: the function running, or "script" for
+// top-level code, the line it is on and the environment it runs in.
+type Frame struct {
+	Function string
+	Line     int
+	Env      *Env
+}
+
+// halt stops a script for good when an observer fails. Unlike runtime errors, it cannot be caught.
+type halt struct {
+	err error
+}
+
+// RuntimeError is an error happening while running a script. Errors caused by going over the
+// limits of the script wrap the corresponding error from builtin.
 type RuntimeError struct {
-	line    int
+	span    token.Span
 	message string
+	err     error
+	trace   []builtin.Frame
 }
 
 func (e RuntimeError) Error() string {
-	return fmt.Sprintf("runtime error on line %d: %s", e.line, e.message)
+	return fmt.Sprintf("runtime error on line %d: %s", e.span.Start.Line, e.message)
 }
 
-type Interpreter struct {
-	locals  map[ast.Expr]int
-	globals *Env
-	env     *Env
-	done    bool
+// Trace returns the calls that were in progress when the error happened, innermost first.
+func (e RuntimeError) Trace() []builtin.Frame {
+	return e.trace
 }
 
+func (e RuntimeError) Line() int {
+	return e.span.Start.Line
+}
+
+func (e RuntimeError) Column() int {
+	return e.span.Start.Column
+}
+
+func (e RuntimeError) Code() string {
+	return diagnostics.CodeRuntime
+}
+
+func (e RuntimeError) Diagnostic() diagnostics.Diagnostic {
+	d := diagnostics.Diagnostic{Kind: "runtime", Code: e.Code(), Message: e.message, Span: e.span}
+	if note := builtin.TraceNote(e.trace); note != "" {
+		d.Notes = []string{note}
+	}
+	return d
+}
+
+func (e RuntimeError) Unwrap() error {
+	return e.err
+}
+
+// location is where the resolver found a local variable: the number of scopes between the
+// variable and the expression referring to it, and the slot of the variable in its scope.
+type location struct {
+	distance int
+	slot     int
+}
+
+// Observer is told what scripts do as they run, for debuggers, profilers, coverage tools and
+// tracers to be built on. Its methods are called from the goroutine running the script, which
+// waits for them to return.
+type Observer interface {
+	// EnterStmt is called before running a statement, nested ones included. If it fails, the
+	// script stops with its error, which try statements cannot catch.
+	EnterStmt(stmt ast.Stmt) error
+	// LeaveStmt is called once a statement is done, even if it ended by returning, breaking out of
+	// a loop or failing.
+	LeaveStmt(stmt ast.Stmt)
+	// Call is called before calling a function, class or native with the given arguments.
+	Call(callee interface{}, arguments []interface{})
+	// Return is called once a call is done, with its result, which is nil if the call failed.
+	Return(callee interface{}, result interface{})
+	// Assign is called after assigning a value to a variable, or declaring it with var, in which
+	// case the value is nil if there is no initializer.
+	Assign(name token.Token, value interface{})
+	// Set is called after setting a property of an instance, with its name as key, or an element
+	// of a list or a map, with its index or key.
+	Set(object interface{}, key interface{}, value interface{})
+	// Error is called when a runtime error happens, before leaving the statement it happened in,
+	// so that the calls in progress are those that led to it. Caught tells whether a try statement
+	// of the run catches the error.
+	Error(err *RuntimeError, caught bool)
+}
+
+type Interpreter struct {
+	locals   map[ast.Expr]location
+	globals  *Env
+	env      *Env
+	streams  *builtin.Streams
+	budget   builtin.Budget
+	frames   []frame
+	maxDepth int
+	done     bool
+	observer Observer
+	// line is the line of the statement being run, only kept track of when there is an observer.
+	line int
+	// reported is the last error the observer was told about, as each statement it goes through
+	// on its way up sees it.
+	reported *RuntimeError
+	// catching is how many bodies of try statements with a catch block the current run is in.
+	catching int
+}
+
+// NewInterpreter creates an interpreter using the standard streams of the process.
 func NewInterpreter() *Interpreter {
 	globals := NewGlobalEnv()
-	globals.Define("clock", func() interface{} {
-		return newFunction(0, globals, func(i *Interpreter, arguments []interface{}) interface{} {
-			return float64(time.Now().Unix())
-		})
-	})
-	return &Interpreter{locals: make(map[ast.Expr]int), globals: globals, env: globals}
+	streams := builtin.StandardStreams()
+	i := &Interpreter{locals: make(map[ast.Expr]location), globals: globals, env: globals, streams: &streams}
+	i.SetLimits(builtin.Limits{})
+	for _, n := range builtin.Natives {
+		i.DefineNative(n)
+	}
+	for _, n := range i.streams.Natives() {
+		i.DefineNative(n)
+	}
+	return i
+}
+
+// SetStreams replaces the streams that scripts print to and read from.
+func (i *Interpreter) SetStreams(streams builtin.Streams) {
+	*i.streams = streams
+}
+
+// SetLimits sets the limits of the scripts run from now on, with a fresh budget of steps.
+func (i *Interpreter) SetLimits(limits builtin.Limits) {
+	i.budget = builtin.NewBudget(limits)
+	i.maxDepth = limits.CallDepth(builtin.DefaultMaxCallDepth)
+}
+
+// SetObserver sets the observer of the scripts run from now on, or removes it if nil.
+func (i *Interpreter) SetObserver(observer Observer) {
+	i.observer = observer
+}
+
+// DefineNative defines a global variable holding the native, replacing any previous value.
+func (i *Interpreter) DefineNative(n builtin.Native) {
+	i.SetGlobal(n.Name, &native{n})
 }
 
 func (i *Interpreter) Interpret(stmt ast.Stmt) (result interface{}, err error) {
+	defer i.recoverError(&err, len(i.frames))
+	result = i.execute(stmt)
+	return
+}
 
+// Call calls a function or class from outside of any script, with values that are already
+// Lox values. Errors are reported as happening on line zero. Natives can call it while a script
+// runs, and the calls in progress are then left as they were, even if the call fails.
+func (i *Interpreter) Call(callee interface{}, arguments []interface{}) (result interface{}, err error) {
+	// Errors of the call are returned to the native rather than caught by the script.
+	catching := i.catching
+	i.catching = 0
 	defer func() {
-		if e := recover(); e != nil {
-			if re, ok := e.(*RuntimeError); ok {
-				err = re
-			} else {
-				panic(fmt.Errorf("unexpected error during interpretation: %v", e))
-			}
+		i.catching = catching
+	}()
+	defer i.recoverError(&err, len(i.frames))
+	if native, ok := callee.(*native); ok {
+		return i.call(native, token.Span{}, arguments), nil
+	}
+	function, ok := callee.(Callable)
+	if !ok {
+		panic(&RuntimeError{message: "can only call functions and classes"})
+	}
+	if len(arguments) != function.Arity() {
+		panic(&RuntimeError{message: fmt.Sprintf("expected %d arguments but got %d", function.Arity(), len(arguments))})
+	}
+	return i.call(function, token.Span{}, arguments), nil
+}
+
+// call calls a function, class or native whose arity was checked, telling the observer about it.
+func (i *Interpreter) call(callee Callable, at token.Span, arguments []interface{}) (result interface{}) {
+	if i.observer != nil {
+		i.observer.Call(callee, arguments)
+		defer func() {
+			i.observer.Return(callee, result)
+		}()
+	}
+	return callee.Call(i, at, arguments)
+}
+
+// execute runs a statement, which counts as a step of the script.
+func (i *Interpreter) execute(stmt ast.Stmt) interface{} {
+	if err := i.budget.Step(); err != nil {
+		overLimit(ast.SpanOf(stmt), err)
+	}
+	if i.observer != nil {
+		i.line = stmt.Start().Line
+		if err := i.observer.EnterStmt(stmt); err != nil {
+			panic(&halt{err: err})
 		}
+		defer i.leave(stmt)
+	}
+	return stmt.AcceptStmt(i)
+}
+
+// leave tells the observer that a statement is done, and about the runtime error it failed with,
+// if it is the first statement the error goes through.
+func (i *Interpreter) leave(stmt ast.Stmt) {
+	if e := recover(); e != nil {
+		if err, ok := e.(*RuntimeError); ok && err != i.reported {
+			i.reported = err
+			i.observer.Error(err, i.catching > 0)
+		}
+		i.observer.LeaveStmt(stmt)
+		panic(e)
+	}
+	i.observer.LeaveStmt(stmt)
+}
+
+// evaluate evaluates an expression, which counts as a step of the script.
+func (i *Interpreter) evaluate(expr ast.Expr) interface{} {
+	if err := i.budget.Step(); err != nil {
+		overLimit(ast.SpanOf(expr), err)
+	}
+	return expr.AcceptExpr(i)
+}
+
+// overLimit stops the script, which went over one of its limits while running the given span.
+func overLimit(span token.Span, err error) {
+	panic(&RuntimeError{span: span, message: err.Error(), err: err})
+}
+
+// recoverError turns what a failing script panicked with into its error, and drops the frames of
+// the calls it was making, down to the given number of frames.
+func (i *Interpreter) recoverError(err *error, frames int) {
+	if e := recover(); e != nil {
+		var re *RuntimeError
+		switch e := e.(type) {
+		case *RuntimeError:
+			re = e
+		case *Throw:
+			re = e.uncaught()
+		case *halt:
+			i.frames = i.frames[:frames]
+			*err = e.err
+			return
+		default:
+			panic(fmt.Errorf("unexpected error during interpretation: %v", e))
+		}
+		// Errors happening outside of any statement, or thrown values, were not reported yet.
+		if i.observer != nil && re != i.reported {
+			i.observer.Error(re, false)
+		}
+		i.reported = nil
+		re.trace = i.trace(re.Line())
+		i.frames = i.frames[:frames]
+		*err = re
+	}
+}
+
+// trace returns the frames on the stack, innermost first, given the line the innermost one
+// reached. Calls made by the host happen on line zero and are not part of any script.
+func (i *Interpreter) trace(line int) []builtin.Frame {
+	var trace []builtin.Frame
+	for index := len(i.frames) - 1; index >= 0; index-- {
+		trace = append(trace, builtin.Frame{Function: builtin.FunctionName(i.frames[index].function), Line: line})
+		line = i.frames[index].line
+	}
+	if line > 0 {
+		trace = append(trace, builtin.Frame{Function: "script", Line: line})
+	}
+	return trace
+}
+
+// Frames returns the calls in progress, innermost first and ending with the script itself. It is
+// meant to be called by observers entering a statement, as the innermost frame is on the line
+// of that statement.
+func (i *Interpreter) Frames() []Frame {
+	frames := make([]Frame, 0, len(i.frames)+1)
+	line, env := i.line, i.env
+	for index := len(i.frames) - 1; index >= 0; index-- {
+		frames = append(frames, Frame{Function: builtin.FunctionName(i.frames[index].function), Line: line, Env: env})
+		line, env = i.frames[index].line, i.frames[index].env
+	}
+	return append(frames, Frame{Function: "script", Line: line, Env: env})
+}
+
+// Depth returns the number of calls in progress.
+func (i *Interpreter) Depth() int {
+	return len(i.frames)
+}
+
+// Evaluate evaluates a resolved expression in the given environment, like debuggers do in the
+// frame a script is paused in. The calls in progress are left as they were, even if the
+// evaluation fails.
+func (i *Interpreter) Evaluate(expr ast.Expr, env *Env) (result interface{}, err error) {
+	previous, frames, line := i.env, i.frames, i.line
+	i.env = env
+	defer func() {
+		i.env, i.frames, i.line = previous, frames, line
 	}()
+	defer i.recoverError(&err, len(frames))
+	return i.evaluate(expr), nil
+}
 
-	result = stmt.AcceptStmt(i)
+// Global returns the value of a global variable, if it is defined.
+func (i *Interpreter) Global(name string) (interface{}, bool) {
+	value, ok := i.globals.names[name]
+	return value, ok
+}
 
-	return
+// SetGlobal defines a global variable, or assigns it if it is already defined.
+func (i *Interpreter) SetGlobal(name string, value interface{}) {
+	i.globals.names[name] = value
 }
 
 func (i *Interpreter) Done() bool {
 	return i.done
 }
 
-func (i *Interpreter) Resolve(expr ast.Expr, depth int) {
-	i.locals[expr] = depth
+func (i *Interpreter) Resolve(expr ast.Expr, depth int, slot int) {
+	i.locals[expr] = location{distance: depth, slot: slot}
 }
 
 func (i *Interpreter) VisitFunDeclStmt(stmt *ast.FunDeclStmt) interface{} {
-	i.env.Define(stmt.Name.Lexeme, func() interface{} {
-		return newFunction(len(stmt.Params), i.env, func(i *Interpreter, arguments []interface{}) (ret interface{}) {
-			env := NewEnv(i.env)
-			i.env = env
-			for index, param := range stmt.Params {
-				env.Define(param.Lexeme, func() interface{} {
-					return arguments[index]
-				})
+	i.env.Define(stmt.Name, func() interface{} {
+		return i.declaredFunction(stmt.Name.Lexeme, stmt.Params, stmt.Body, false)
+	})
+	return nil
+}
+
+// declaredFunction creates a function closing over the current environment. Initializers
+// always return the instance they are bound to, regardless of what the body returns.
+func (i *Interpreter) declaredFunction(name string, params []token.Token, body *ast.BlockStmt, initializer bool) *function {
+	return newFunction(name, len(params), i.env, func(i *Interpreter, arguments []interface{}) (ret interface{}) {
+		closure := i.env
+		env := NewEnv(closure)
+		for index, param := range params {
+			env.Define(param, func() interface{} {
+				return arguments[index]
+			})
+		}
+		defer func() {
+			if e := recover(); e != nil {
+				if r, ok := e.(*Return); ok {
+					ret = r.value
+				} else {
+					panic(e)
+				}
+			}
+			if initializer {
+				ret = closure.GetAt(0, 0)
 			}
+		}()
+		i.executeBlock(body.Statements, env)
+		return
+	})
+}
+
+func (i *Interpreter) VisitClassStmt(stmt *ast.ClassStmt) interface{} {
+	i.env.Define(stmt.Name, func() interface{} {
+		var superclass *class
+		if stmt.Superclass != nil {
+			var ok bool
+			if superclass, ok = i.evaluate(stmt.Superclass).(*class); !ok {
+				panic(&RuntimeError{span: stmt.Superclass.Name.Span, message: "superclass must be a class"})
+			}
+			previous := i.env
+			i.env = NewEnv(previous)
+			i.env.Define(token.Token{Type: token.SUPER, Lexeme: "super"}, func() interface{} {
+				return superclass
+			})
 			defer func() {
-				i.env = env.parent
-				if e := recover(); e != nil {
-					if r, ok := e.(*Return); ok {
-						ret = r.value
-					} else {
-						panic(e)
-					}
-				}
+				i.env = previous
 			}()
-			stmt.Body.AcceptStmt(i)
-			return
-		})
+		}
+		methods := make(map[string]*function)
+		for _, method := range stmt.Methods {
+			methods[method.Name.Lexeme] = i.declaredFunction(method.Name.Lexeme, method.Params, method.Body, method.Name.Lexeme == "init")
+		}
+		return &class{name: stmt.Name.Lexeme, superclass: superclass, methods: methods}
 	})
 	return nil
 }
 
 func (i *Interpreter) VisitVarDeclStmt(stmt *ast.VarDeclStmt) interface{} {
-	i.env.Define(stmt.Name.Lexeme, func() interface{} {
-		if *stmt.Initializer == nil {
-			return nil
+	var value interface{}
+	i.env.Define(stmt.Name, func() interface{} {
+		if *stmt.Initializer != nil {
+			value = i.evaluate(*stmt.Initializer)
 		}
-		initializer := *stmt.Initializer
-		return initializer.AcceptExpr(i)
+		return value
 	})
+	if i.observer != nil {
+		i.observer.Assign(stmt.Name, value)
+	}
 	return nil
 }
 
 func (i *Interpreter) VisitBlockStmt(stmt *ast.BlockStmt) interface{} {
-	env := NewEnv(i.env)
+	i.executeBlock(stmt.Statements, NewEnv(i.env))
+	return nil
+}
+
+func (i *Interpreter) executeBlock(stmts []ast.Stmt, env *Env) {
+	previous := i.env
 	i.env = env
-	for _, stmt := range stmt.Statements {
-		stmt.AcceptStmt(i)
+	defer func() {
+		i.env = previous
+	}()
+	for _, stmt := range stmts {
+		i.execute(stmt)
 	}
-	i.env = env.parent
-	return nil
 }
 
 func (i *Interpreter) VisitIfStmt(stmt *ast.IfStmt) interface{} {
-	if truthy(stmt.Condition.AcceptExpr(i)) {
-		(*stmt.ThenBranch).AcceptStmt(i)
+	if truthy(i.evaluate(stmt.Condition)) {
+		i.executeBody(*stmt.ThenBranch)
 	} else if stmt.ElseBranch != nil {
-		(*stmt.ElseBranch).AcceptStmt(i)
+		i.executeBody(*stmt.ElseBranch)
 	}
 	return nil
 }
 
 func (i *Interpreter) VisitPrintStmt(stmt *ast.PrintStmt) interface{} {
-	fmt.Println(stmt.Expression.AcceptExpr(i))
+	fmt.Fprintln(i.streams.Stdout, i.evaluate(stmt.Expression))
 	return nil
 }
 
 func (i *Interpreter) VisitAssertStmt(stmt *ast.AssertStmt) interface{} {
-	assertion := stmt.Expression.AcceptExpr(i)
+	assertion := i.evaluate(stmt.Expression)
 	if !truthy(assertion) {
-		panic(&RuntimeError{line: 0 /*TODO*/, message: "assertion failed"})
+		panic(&RuntimeError{span: stmt.Keyword.Span, message: "assertion failed"})
 	}
 	return nil
 }
 
 func (i *Interpreter) VisitWhileStmt(stmt *ast.WhileStmt) interface{} {
-	for truthy(stmt.Condition.AcceptExpr(i)) {
-		stmt.Body.AcceptStmt(i)
+	for truthy(i.evaluate(stmt.Condition)) {
+		if i.executeLoopBody(stmt.Body) {
+			break
+		}
+	}
+	return nil
+}
+
+func (i *Interpreter) VisitForStmt(stmt *ast.ForStmt) interface{} {
+	previous := i.env
+	i.env = NewEnv(i.env)
+	defer func() {
+		i.env = previous
+	}()
+	if stmt.Initializer != nil {
+		i.execute(stmt.Initializer)
+	}
+	for stmt.Condition == nil || truthy(i.evaluate(stmt.Condition)) {
+		if i.executeLoopBody(stmt.Body) {
+			break
+		}
+		if stmt.Increment != nil {
+			i.evaluate(stmt.Increment)
+		}
+	}
+	return nil
+}
+
+// executeLoopBody runs one iteration of a loop, returning whether the loop should stop.
+func (i *Interpreter) executeLoopBody(body ast.Stmt) (stop bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch e.(type) {
+			case *Break:
+				stop = true
+			case *Continue:
+			default:
+				panic(e)
+			}
+		}
+	}()
+	i.executeBody(body)
+	return false
+}
+
+// executeBody runs a branch or the body of a loop, giving declarations a scope of their own like
+// the resolver does.
+func (i *Interpreter) executeBody(body ast.Stmt) {
+	if ast.IsDeclaration(body) {
+		i.executeBlock([]ast.Stmt{body}, NewEnv(i.env))
+		return
+	}
+	i.execute(body)
+}
+
+func (i *Interpreter) VisitThrowStmt(stmt *ast.ThrowStmt) interface{} {
+	panic(&Throw{value: i.evaluate(stmt.Value), span: stmt.Keyword.Span})
+}
+
+func (i *Interpreter) VisitTryStmt(stmt *ast.TryStmt) interface{} {
+	if stmt.Finally != nil {
+		defer i.executeBlock(stmt.Finally.Statements, NewEnv(i.env))
+	}
+	if stmt.Catch == nil {
+		i.execute(stmt.Body)
+		return nil
+	}
+	if value, caught := i.executeTryBody(stmt.Body); caught {
+		env := NewEnv(i.env)
+		env.Define(stmt.CatchName, func() interface{} {
+			return value
+		})
+		i.executeBlock(stmt.Catch.Statements, NewEnv(env))
 	}
 	return nil
 }
 
+// executeTryBody runs the body of a try statement, returning the value thrown in it, if any.
+// Runtime errors are caught too, as error values.
+func (i *Interpreter) executeTryBody(body *ast.BlockStmt) (value interface{}, caught bool) {
+	frames := len(i.frames)
+	i.catching++
+	defer func() {
+		i.catching--
+		if e := recover(); e != nil {
+			i.frames = i.frames[:frames]
+			switch e := e.(type) {
+			case *RuntimeError:
+				value, caught = &builtin.Error{Message: e.message, Line: e.Line(), Span: e.span}, true
+			case *Throw:
+				value, caught = e.value, true
+			default:
+				panic(e)
+			}
+		}
+	}()
+	i.execute(body)
+	return nil, false
+}
+
+func (i *Interpreter) VisitBreakStmt(stmt *ast.BreakStmt) interface{} {
+	panic(&Break{})
+}
+
+func (i *Interpreter) VisitContinueStmt(stmt *ast.ContinueStmt) interface{} {
+	panic(&Continue{})
+}
+
 func (i *Interpreter) VisitReturnStmt(stmt *ast.ReturnStmt) interface{} {
 	var value interface{}
-	if stmt.Value != nil {
-		value = (*stmt.Value).AcceptExpr(i)
+	if stmt.Value != nil && *stmt.Value != nil {
+		value = i.evaluate(*stmt.Value)
 	}
 	panic(&Return{value: value})
 }
@@ -178,27 +672,30 @@ func (i *Interpreter) VisitEndStmt(stmt *ast.EndStmt) interface{} {
 }
 
 func (i *Interpreter) VisitExprStmt(stmt *ast.ExprStmt) interface{} {
-	return stmt.Expression.AcceptExpr(i)
+	return i.evaluate(stmt.Expression)
 }
 
 func (i *Interpreter) VisitAssignmentExpr(expr *ast.AssignmentExpr) interface{} {
 	var value interface{}
-	if distance, ok := i.locals[expr]; ok {
-		value = i.env.AssignAt(distance, expr.Name.Lexeme, func() interface{} {
-			value = expr.Value.AcceptExpr(i)
+	if local, ok := i.locals[expr]; ok {
+		value = i.env.AssignAt(local.distance, local.slot, func() interface{} {
+			value = i.evaluate(expr.Value)
 			return value
 		})
 	} else {
-		value = i.globals.Assign(expr.Name.Lexeme, func() interface{} {
-			value = expr.Value.AcceptExpr(i)
+		value = i.globals.Assign(expr.Name, func() interface{} {
+			value = i.evaluate(expr.Value)
 			return value
 		})
 	}
+	if i.observer != nil {
+		i.observer.Assign(expr.Name, value)
+	}
 	return value
 }
 
 func (i *Interpreter) VisitLogicalExpr(expr *ast.LogicalExpr) interface{} {
-	left := expr.Left.AcceptExpr(i)
+	left := i.evaluate(expr.Left)
 	if expr.Operator.Type == token.OR {
 		if truthy(left) {
 			return left
@@ -208,59 +705,59 @@ func (i *Interpreter) VisitLogicalExpr(expr *ast.LogicalExpr) interface{} {
 			return left
 		}
 	}
-	return expr.Right.AcceptExpr(i)
+	return i.evaluate(expr.Right)
 }
 
 func (i *Interpreter) VisitBinaryExpr(expr *ast.BinaryExpr) interface{} {
-	left := expr.Left.AcceptExpr(i)
-	right := expr.Right.AcceptExpr(i)
+	left := i.evaluate(expr.Left)
+	right := i.evaluate(expr.Right)
 	switch expr.Operator.Type {
 	case token.MINUS:
 		if left, ok := left.(float64); ok {
 			if right, ok := right.(float64); ok {
 				return left - right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number"})
 		}
 	case token.SLASH:
 		if left, ok := left.(float64); ok {
 			if right, ok := right.(float64); ok {
 				return left / right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number"})
 		}
 	case token.STAR:
 		if left, ok := left.(float64); ok {
 			if right, ok := right.(float64); ok {
 				return left * right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number"})
 		}
 	case token.PLUS:
 		if left, ok := left.(float64); ok {
 			if right, ok := right.(float64); ok {
 				return left + right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		}
 		if left, ok := left.(string); ok {
 			if right, ok := right.(string); ok {
 				return left + right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a string"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a string"})
 			}
 		}
-		panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number or a string"})
+		panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number or a string"})
 	case token.BANG_EQUAL:
 		if left == nil {
 			return right != nil
@@ -276,63 +773,158 @@ func (i *Interpreter) VisitBinaryExpr(expr *ast.BinaryExpr) interface{} {
 			if right, ok := right.(float64); ok {
 				return left > right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number"})
 		}
 	case token.GREATER_EQUAL:
 		if left, ok := left.(float64); ok {
 			if right, ok := right.(float64); ok {
 				return left >= right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number"})
 		}
 	case token.LESS:
 		if left, ok := left.(float64); ok {
 			if right, ok := right.(float64); ok {
 				return left < right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number"})
 		}
 	case token.LESS_EQUAL:
 		if left, ok := left.(float64); ok {
 			if right, ok := right.(float64); ok {
 				return left <= right
 			} else {
-				panic(&RuntimeError{line: expr.Operator.Line, message: "right operand must be a number"})
+				panic(&RuntimeError{span: expr.Operator.Span, message: "right operand must be a number"})
 			}
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "left operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "left operand must be a number"})
 		}
 	}
 	panic(fmt.Errorf("unexpected operator: %v", expr.Operator))
 }
 
 func (i *Interpreter) VisitCallExpr(expr *ast.CallExpr) interface{} {
-	callee := expr.Callee.AcceptExpr(i)
+	callee := i.evaluate(expr.Callee)
 	var arguments []interface{}
 	for _, arg := range expr.Arguments {
-		arguments = append(arguments, arg.AcceptExpr(i))
+		arguments = append(arguments, i.evaluate(arg))
+	}
+	if native, ok := callee.(*native); ok {
+		return i.call(native, ast.SpanOf(expr), arguments)
 	}
 	if function, ok := callee.(Callable); ok {
 		if len(arguments) != function.Arity() {
-			panic(&RuntimeError{line: expr.Paren.Line, message: fmt.Sprintf("expected %d arguments but got %d", function.Arity(), len(arguments))})
+			panic(&RuntimeError{span: ast.SpanOf(expr), message: fmt.Sprintf("expected %d arguments but got %d", function.Arity(), len(arguments))})
 		}
-		return function.Call(i, arguments)
+		return i.call(function, ast.SpanOf(expr), arguments)
 	} else {
-		panic(&RuntimeError{line: expr.Paren.Line, message: "identifier is not a function"})
+		panic(&RuntimeError{span: ast.SpanOf(expr), message: "can only call functions and classes"})
+	}
+}
+
+func (i *Interpreter) VisitFunctionExpr(expr *ast.FunctionExpr) interface{} {
+	return i.declaredFunction("", expr.Params, expr.Body, false)
+}
+
+func (i *Interpreter) VisitGetExpr(expr *ast.GetExpr) interface{} {
+	object := i.evaluate(expr.Object)
+	switch object := object.(type) {
+	case *instance:
+		return object.get(expr.Name)
+	case *builtin.Error:
+		value, err := object.Get(expr.Name.Lexeme)
+		if err != nil {
+			panic(&RuntimeError{span: expr.Name.Span, message: err.Error()})
+		}
+		return value
 	}
+	panic(&RuntimeError{span: expr.Name.Span, message: "only instances have properties"})
+}
+
+func (i *Interpreter) VisitSetExpr(expr *ast.SetExpr) interface{} {
+	object := i.evaluate(expr.Object)
+	instance, ok := object.(*instance)
+	if !ok {
+		panic(&RuntimeError{span: expr.Name.Span, message: "only instances have fields"})
+	}
+	value := i.evaluate(expr.Value)
+	instance.set(expr.Name, value)
+	if i.observer != nil {
+		i.observer.Set(instance, expr.Name.Lexeme, value)
+	}
+	return value
+}
+
+func (i *Interpreter) VisitSetIndexExpr(expr *ast.SetIndexExpr) interface{} {
+	object := i.evaluate(expr.Object)
+	index := i.evaluate(expr.Index)
+	value := i.evaluate(expr.Value)
+	if err := builtin.SetIndex(object, index, value); err != nil {
+		panic(&RuntimeError{span: expr.Bracket.Span, message: err.Error()})
+	}
+	if i.observer != nil {
+		i.observer.Set(object, index, value)
+	}
+	return value
+}
+
+func (i *Interpreter) VisitSuperExpr(expr *ast.SuperExpr) interface{} {
+	// The resolver puts `this` in the scope right inside the one of `super`, both in slot zero.
+	local := i.locals[expr]
+	superclass := i.env.GetAt(local.distance, 0).(*class)
+	object := i.env.GetAt(local.distance-1, 0).(*instance)
+	method, ok := superclass.findMethod(expr.Method.Lexeme)
+	if !ok {
+		panic(&RuntimeError{span: expr.Method.Span, message: fmt.Sprintf("undefined property '%s'", expr.Method.Lexeme)})
+	}
+	return method.bind(object)
+}
+
+func (i *Interpreter) VisitThisExpr(expr *ast.ThisExpr) interface{} {
+	return i.lookupVariable(expr.Keyword, expr)
 }
 
 func (i *Interpreter) VisitGroupingExpr(expr *ast.GroupingExpr) interface{} {
-	return expr.Expression.AcceptExpr(i)
+	return i.evaluate(expr.Expression)
+}
+
+func (i *Interpreter) VisitIndexExpr(expr *ast.IndexExpr) interface{} {
+	object := i.evaluate(expr.Object)
+	index := i.evaluate(expr.Index)
+	value, err := builtin.Index(object, index)
+	if err != nil {
+		panic(&RuntimeError{span: expr.Bracket.Span, message: err.Error()})
+	}
+	return value
+}
+
+func (i *Interpreter) VisitListExpr(expr *ast.ListExpr) interface{} {
+	elements := make([]interface{}, len(expr.Elements))
+	for index, element := range expr.Elements {
+		elements[index] = i.evaluate(element)
+	}
+	return builtin.NewList(elements)
+}
+
+func (i *Interpreter) VisitMapExpr(expr *ast.MapExpr) interface{} {
+	m := builtin.NewMap()
+	for index, key := range expr.Keys {
+		k := i.evaluate(key)
+		v := i.evaluate(expr.Values[index])
+		if err := m.Set(k, v); err != nil {
+			panic(&RuntimeError{span: expr.Brace.Span, message: err.Error()})
+		}
+	}
+	return m
 }
 
 func (i *Interpreter) VisitLiteralExpr(expr *ast.LiteralExpr) interface{} {
@@ -351,13 +943,13 @@ func truthy(value interface{}) bool {
 }
 
 func (i *Interpreter) VisitUnaryExpr(expr *ast.UnaryExpr) interface{} {
-	right := expr.Right.AcceptExpr(i)
+	right := i.evaluate(expr.Right)
 	switch expr.Operator.Type {
 	case token.MINUS:
 		if x, ok := right.(float64); ok {
 			return -x
 		} else {
-			panic(&RuntimeError{line: expr.Operator.Line, message: "operand must be a number"})
+			panic(&RuntimeError{span: expr.Operator.Span, message: "operand must be a number"})
 		}
 	case token.BANG:
 		return !truthy(right)
@@ -370,9 +962,9 @@ func (i *Interpreter) VisitVarExpr(expr *ast.VarExpr) interface{} {
 }
 
 func (i *Interpreter) lookupVariable(name token.Token, expr ast.Expr) interface{} {
-	if distance, ok := i.locals[expr]; ok {
-		return i.env.GetAt(distance, name.Lexeme)
+	if local, ok := i.locals[expr]; ok {
+		return i.env.GetAt(local.distance, local.slot)
 	} else {
-		return i.globals.Get(name.Lexeme)
+		return i.globals.Get(name)
 	}
 }
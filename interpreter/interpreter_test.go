@@ -2,8 +2,14 @@ package interpreter
 
 import (
 	"bufio"
+	"fmt"
+	"lox/ast"
+	"lox/builtin"
 	"lox/parser"
+	"lox/resolver"
 	"lox/scanner"
+	"lox/token"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
@@ -83,12 +89,18 @@ func TestInterpreterAssert(t *testing.T) {
 	expectRuntimeError(t, "assert nil;", "assertion failed")
 }
 
-// func TestInterpreterShading(t *testing.T) {
-// 	expectResult(t, "var x; { x = 2; } x;", 2.0)
-// 	expectResult(t, "var x = 1; { var x = 2; assert x == 2; } x;", 1.0)
-// 	expectResult(t, "var x = 1; { var x = 2; { x = 3; } assert x == 3; } x;", 1.0)
-// 	expectResult(t, "var x = 1; { var x = 2; { var x = 3; assert x == 3; } assert x == 2; } x;", 1.0)
-// }
+func TestInterpreterShading(t *testing.T) {
+	expectResult(t, "var x; { x = 2; } x;", 2.0)
+	expectResult(t, "var x = 1; { var x = 2; assert x == 2; } x;", 1.0)
+	expectResult(t, "var x = 1; { var x = 2; { x = 3; } assert x == 3; } x;", 1.0)
+	expectResult(t, "var x = 1; { var x = 2; { var x = 3; assert x == 3; } assert x == 2; } x;", 1.0)
+}
+
+func TestInterpreterSlots(t *testing.T) {
+	expectResult(t, "var r; { var a = 1; var b = 2; { var c = 3; a = b + c; } r = a; } r;", 5.0)
+	expectResult(t, "fun f(a, b) { var c = a * b; { var d = c + a; return d - b; } } f(3, 4);", 11.0)
+	expectResult(t, "var r; { var i = 0; while (i < 3) { var j = i; i = i + 1; r = j; } } r;", 2.0)
+}
 
 func TestInterpreterEnv(t *testing.T) {
 	expectResult(t, "var x = 1; { assert x == 1; }", nil)
@@ -101,6 +113,209 @@ func TestInterpreterFun(t *testing.T) {
 	expectResult(t, "fun f() { return 1; } f();", 1.0)
 }
 
+func TestInterpreterClosure(t *testing.T) {
+	expectResult(t, "fun f() { var x = 1; fun g() { x = x + 1; return x; } return g; } var g = f(); g(); g();", 3.0)
+	expectResult(t, "fun f() { return 1; } var y; { var x = 2; f(); y = x; } y;", 2.0)
+}
+
+func TestInterpreterLambda(t *testing.T) {
+	expectResult(t, "var add = fun (a, b) { return a + b; }; add(1, 2);", 3.0)
+	expectResult(t, "fun apply(f, x) { return f(x); } apply(fun (x) { return x * 2; }, 21);", 42.0)
+	expectResult(t, "fun adder(n) { return fun (x) { return x + n; }; } adder(1)(2);", 3.0)
+	expectRuntimeError(t, "fun (x) {}();", "expected 1 arguments but got 0")
+}
+
+func TestInterpreterClass(t *testing.T) {
+	expectResult(t, "class Foo {} var foo = Foo(); foo.x = 1; foo.x;", 1.0)
+	expectResult(t, "class Foo { bar() { return \"bar\"; } } Foo().bar();", "bar")
+	expectResult(t, "class Foo { init(x) { this.x = x; } get() { return this.x; } } Foo(42).get();", 42.0)
+	expectRuntimeError(t, "class Foo {} Foo().bar;", "undefined property 'bar'")
+	expectRuntimeError(t, "1.x;", "only instances have properties")
+	expectRuntimeError(t, "\"s\".x = 1;", "only instances have fields")
+	expectRuntimeError(t, "class Foo { init(x) {} } Foo();", "expected 1 arguments but got 0")
+}
+
+func TestInterpreterBoundMethod(t *testing.T) {
+	expectResult(t, "class Foo { init() { this.n = 1; } get() { return this.n; } } var get = Foo().get; get();", 1.0)
+	expectResult(t, "class Foo { get() { return this.n; } } var a = Foo(); var b = Foo(); a.n = 1; b.n = 2; b.get = a.get; b.get();", 1.0)
+}
+
+func TestInterpreterInitializer(t *testing.T) {
+	expectResult(t, "class Foo { init() { this.n = 1; return; } } var foo = Foo(); foo.n = 2; foo.init().n;", 1.0)
+}
+
+func TestInterpreterInheritance(t *testing.T) {
+	expectResult(t, "class A { m() { return 1; } } class B < A {} B().m();", 1.0)
+	expectResult(t, "class A { m() { return 1; } } class B < A { m() { return 2; } } B().m();", 2.0)
+	expectResult(t, "class A { init(x) { this.x = x; } } class B < A {} B(3).x;", 3.0)
+	expectRuntimeError(t, "var A = 1; class B < A {}", "superclass must be a class")
+}
+
+func TestInterpreterSuper(t *testing.T) {
+	expectResult(t, "class A { m() { return \"A\"; } } class B < A { m() { return \"B\" + super.m(); } } B().m();", "BA")
+	expectResult(t, "class A { m() { return this.n; } } class B < A { m() { this.n = 1; return super.m(); } } class C < B {} C().m();", 1.0)
+	expectResult(t, "class A { m() { return \"A\"; } } class B < A { m() { return super.m; } } class C < B {} C().m()();", "A")
+	expectRuntimeError(t, "class A {} class B < A { m() { return super.m(); } } B().m();", "undefined property 'm'")
+}
+
+func TestInterpreterList(t *testing.T) {
+	expectResult(t, "var xs = [1, 2, 3]; xs[1];", 2.0)
+	expectResult(t, "var xs = [[1], [2]]; xs[1][0] = 3; xs[1][0];", 3.0)
+	expectResult(t, "var xs = []; push(xs, 1); push(xs, 2); insert(xs, 0, 0); len(xs);", 3.0)
+	expectResult(t, "var xs = [1, 2, 3]; pop(xs) + len(xs);", 5.0)
+	expectResult(t, "var xs = slice([1, 2, 3], 1, 3); xs[0] + xs[1];", 5.0)
+	expectResult(t, "len(\"héllo\");", 5.0)
+	expectRuntimeError(t, "[1, 2][2];", "index 2 out of bounds for list of length 2")
+	expectRuntimeError(t, "[1, 2][0.5];", "index must be an integer")
+	expectRuntimeError(t, "var x = 1; x[0] = 1;", "only lists and maps can be indexed")
+	expectRuntimeError(t, "\n\npop([]);", "line 3: cannot pop from an empty list")
+	expectRuntimeError(t, "slice([1], 0, 2);", "slice \\[0, 2\\) out of bounds for list of length 1")
+}
+
+func TestInterpreterMap(t *testing.T) {
+	expectResult(t, "var m = {\"a\": 1, \"b\": 2}; m[\"a\"] + m[\"b\"];", 3.0)
+	expectResult(t, "var m = {}; m[1] = \"one\"; m[true] = nil; m[nil] = 0; len(m);", 3.0)
+	expectResult(t, "var m = {1: 1}; m[1] = 2; len(m) + m[1];", 3.0)
+	expectResult(t, "var m = {\"a\": 1}; has(m, \"a\") and !has(m, \"b\");", true)
+	expectResult(t, "var m = {\"a\": 1}; delete(m, \"a\") and !delete(m, \"a\") and len(m) == 0;", true)
+	expectResult(t, "var m = {\"b\": 1, \"a\": 2}; m[\"c\"] = 3; delete(m, \"b\"); keys(m)[1] + keys(m)[0];", "ca")
+	expectResult(t, "values({\"x\": 1, \"y\": 2})[1];", 2.0)
+	expectRuntimeError(t, "var m = {}; m[\"a\"];", "key \"a\" not found in map")
+	expectRuntimeError(t, "var m = {}; m[[]] = 1;", "map keys must be numbers, strings, booleans or nil")
+	expectRuntimeError(t, "var m = {{}: 1};", "map keys must be numbers, strings, booleans or nil")
+	expectRuntimeError(t, "has([], 1);", "first argument to 'has' must be a map")
+}
+
+func TestInterpreterBreakContinue(t *testing.T) {
+	expectResult(t, "var n = 0; while (true) { n = n + 1; if (n == 3) break; } n;", 3.0)
+	expectResult(t, "var n = 0; for (var i = 0; i < 10; i = i + 1) { if (i == 5) break; n = n + i; } n;", 10.0)
+	expectResult(t, "var n = 0; for (var i = 0; i < 5; i = i + 1) { if (i == 2) continue; n = n + i; } n;", 8.0)
+	expectResult(t, "var n = 0; for (var i = 0; i < 3; i = i + 1) { for (;;) { n = n + 1; break; } } n;", 3.0)
+	expectResult(t, "fun f() { while (true) { return 1; } } f();", 1.0)
+	expectResult(t, "var i = 0; for (; i < 3;) { i = i + 1; } i;", 3.0)
+}
+
+func TestInterpreterTry(t *testing.T) {
+	expectResult(t, "var r; try { throw 42; } catch (e) { r = e; } r;", 42.0)
+	expectResult(t, "var r; try { 1 + nil; } catch (e) { r = e.message; } r;", "right operand must be a number")
+	expectResult(t, "var r; try {\n\n[][0]; } catch (e) { r = e.line; } r;", 3.0)
+	expectResult(t, "var r; try { fun (a) {}(); } catch (e) { r = e.message; } r;", "expected 1 arguments but got 0")
+	expectResult(t, "var r = \"\"; try { try { throw 1; } finally { r = r + \"f\"; } } catch (e) { r = r + \"c\"; } r;", "fc")
+	expectResult(t, "var r = 0; fun f() { try { return 1; } finally { r = 2; } } f() + r;", 3.0)
+	expectResult(t, "fun f() { try { throw 1; } finally { return 2; } } f();", 2.0)
+	expectResult(t, "var n = 0; while (true) { try { break; } finally { n = n + 1; } } n;", 1.0)
+	expectRuntimeError(t, "throw \"oops\";", "line 1: uncaught exception: \"oops\"")
+	expectRuntimeError(t, "try {\nnil();\n} catch (e) { throw e; }", "line 2: can only call functions and classes")
+	expectRuntimeError(t, "try { throw 1; } catch (e) { e.message; }", "only instances have properties")
+	expectRuntimeError(t, "try { nil(); } catch (e) { e.code; }", "undefined property 'code'")
+}
+
+func TestInterpreterStackOverflow(t *testing.T) {
+	expectRuntimeError(t, "fun f() { f(); } f();", "stack overflow")
+	expectResult(t, "fun f() { f(); } var r; try { f(); } catch (e) { r = e.message; } r;", "stack overflow")
+}
+
+func TestInterpreterTrace(t *testing.T) {
+	src := `fun fail() {
+  return -nil;
+}
+fun f(n) {
+  if (n == 0) return fail();
+  return f(n - 1);
+}
+try { f(3); } catch (e) {}
+f(1);`
+	expectTrace(t, src, []builtin.Frame{{Function: "fail", Line: 2}, {Function: "f", Line: 5}, {Function: "f", Line: 6}, {Function: "script", Line: 9}})
+	expectTrace(t, "\nassert false;", []builtin.Frame{{Function: "script", Line: 2}})
+	expectTrace(t, "var g = fun() { undefined; };\ng();", []builtin.Frame{{Function: "<fn>", Line: 1}, {Function: "script", Line: 2}})
+}
+
+func TestInterpreterObserver(t *testing.T) {
+	src := `fun add(a, b) {
+  return a + b;
+}
+var x = add(1, 2);
+x = x * 2;
+try { x = nil(); } catch (e) {}
+var list = [1];
+list[0] = x;
+class C {}
+C().name = "c";
+x = -nil;`
+	observer := &recorder{}
+	if _, err := interpretObserved(t, src, observer); err == nil {
+		t.Fatal("expected runtime error")
+	}
+	expected := []string{
+		"enter 1", "leave 1",
+		"enter 4", "call <fn add>(1, 2)", "enter 2", "leave 2", "return <fn add> 3", "assign x = 3", "leave 4",
+		"enter 5", "assign x = 6", "leave 5",
+		"enter 6", "enter 6", "enter 6", "caught can only call functions and classes", "leave 6", "leave 6", "leave 6",
+		"enter 7", "assign list = [1]", "leave 7",
+		"enter 8", "set [6][0] = 6", "leave 8",
+		"enter 9", "leave 9",
+		"enter 10", "call C()", "return C C instance", "set C instance[\"name\"] = \"c\"", "leave 10",
+		"enter 11", "error operand must be a number", "leave 11",
+	}
+	if strings.Join(observer.events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected events:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(observer.events, "\n"))
+	}
+}
+
+// recorder is an observer keeping track of what scripts do.
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) EnterStmt(stmt ast.Stmt) error {
+	r.events = append(r.events, fmt.Sprintf("enter %d", stmt.Start().Line))
+	return nil
+}
+
+func (r *recorder) LeaveStmt(stmt ast.Stmt) {
+	r.events = append(r.events, fmt.Sprintf("leave %d", stmt.Start().Line))
+}
+
+func (r *recorder) Call(callee interface{}, arguments []interface{}) {
+	var reprs []string
+	for _, argument := range arguments {
+		reprs = append(reprs, builtin.Repr(argument))
+	}
+	r.events = append(r.events, fmt.Sprintf("call %v(%s)", callee, strings.Join(reprs, ", ")))
+}
+
+func (r *recorder) Return(callee interface{}, result interface{}) {
+	r.events = append(r.events, fmt.Sprintf("return %v %s", callee, builtin.Repr(result)))
+}
+
+func (r *recorder) Assign(name token.Token, value interface{}) {
+	r.events = append(r.events, fmt.Sprintf("assign %s = %s", name.Lexeme, builtin.Repr(value)))
+}
+
+func (r *recorder) Set(object interface{}, key interface{}, value interface{}) {
+	r.events = append(r.events, fmt.Sprintf("set %s[%s] = %s", builtin.Repr(object), builtin.Repr(key), builtin.Repr(value)))
+}
+
+func (r *recorder) Error(err *RuntimeError, caught bool) {
+	if caught {
+		r.events = append(r.events, "caught "+err.message)
+	} else {
+		r.events = append(r.events, "error "+err.message)
+	}
+}
+
+func expectTrace(t *testing.T, src string, expected []builtin.Frame) {
+	t.Helper()
+	_, err := interpret(t, src)
+	re, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected runtime error, got '%v'", err)
+	}
+	if !reflect.DeepEqual(re.Trace(), expected) {
+		t.Errorf("expected trace %v, got %v", expected, re.Trace())
+	}
+}
+
 func expectRuntimeError(t *testing.T, src string, regex string) {
 	t.Helper()
 	if _, err := interpret(t, src); err == nil {
@@ -126,14 +341,23 @@ func expectResult(t *testing.T, src string, expected interface{}) {
 }
 
 func interpret(t *testing.T, src string) (interface{}, error) {
+	return interpretObserved(t, src, nil)
+}
+
+func interpretObserved(t *testing.T, src string, observer Observer) (interface{}, error) {
 	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
 	i := NewInterpreter()
+	i.SetObserver(observer)
+	r := resolver.NewResolver(i)
 	var result interface{}
 	for !i.Done() {
 		stmt, err := p.NextStatement()
 		if err != nil {
 			return nil, err
 		}
+		if err := r.Resolve(stmt); err != nil {
+			return nil, err
+		}
 		if res, err := i.Interpret(stmt); err != nil {
 			return nil, err
 		} else {
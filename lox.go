@@ -1,6 +1,24 @@
 package lox
 
+import (
+	"lox/builtin"
+	"lox/diagnostics"
+)
+
+// The errors that runtime errors wrap when a script goes over its limits.
+var (
+	ErrCanceled  = builtin.ErrCanceled
+	ErrStepLimit = builtin.ErrStepLimit
+	ErrCallDepth = builtin.ErrCallDepth
+)
+
+// Error is implemented by all the errors a script can cause, whether while being scanned,
+// parsed, resolved, compiled or run. Columns count runes from 1, and are zero when unknown.
+// The code identifies the phase that failed, as one of the codes of the diagnostics package.
 type Error interface {
 	error
 	Line() int
+	Column() int
+	Code() string
+	Diagnostic() diagnostics.Diagnostic
 }
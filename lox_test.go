@@ -0,0 +1,412 @@
+package lox
+
+import (
+	"context"
+	"errors"
+	"lox/diagnostics"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var backends = []Backend{TreeWalker, Bytecode}
+
+func TestRun(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		expectNumber(t, run(t, vm, "var x = 20; x + 22;"), 42)
+		expectNumber(t, run(t, vm, "x;"), 20)
+		if result := run(t, vm, "var y = 1;"); !result.IsNil() {
+			t.Errorf("expected nil, got %v", result)
+		}
+	}
+}
+
+func TestRunReader(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		result, err := vm.RunReader(strings.NewReader("fun f() { return \"a\" + \"b\"; }\nf();"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, err := result.Str(); err != nil || s != "ab" {
+			t.Errorf("expected 'ab', got %v (%v)", result, err)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	for _, backend := range backends {
+		var stdout strings.Builder
+		vm := NewVM(Options{Backend: backend, Stdin: strings.NewReader("Ada\r\nLovelace"), Stdout: &stdout})
+		run(t, vm, "print \"hello \" + input(\"name? \"); print readLine(); print readLine() == nil;")
+		if expected := "name? hello Ada\nLovelace\ntrue\n"; stdout.String() != expected {
+			t.Errorf("expected output %q, got %q", expected, stdout.String())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend, MaxSteps: 1000, MaxCallDepth: 11})
+		run(t, vm, "fun f(n) { if (n == 0) return 0; return f(n - 1) + 1; }")
+		expectNumber(t, run(t, vm, "f(10);"), 10)
+		expectLimit(t, vm, "f(11);", ErrCallDepth)
+		expectString(t, run(t, vm, "var r; try { f(11); } catch (e) { r = e.message; } r;"), "stack overflow")
+		expectLimit(t, vm, "while (true) {}", ErrStepLimit)
+		expectLimit(t, vm, "try { while (true) {} } catch (e) { print e; }", ErrStepLimit)
+		expectNumber(t, run(t, vm, "f(1);"), 1)
+		if _, err := vm.Call("f", 11); !errors.Is(err, ErrCallDepth) {
+			t.Errorf("expected call depth error, got %v", err)
+		}
+	}
+}
+
+func TestDeepRecursion(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		run(t, vm, "fun sum(n) { if (n == 0) return 0; var add = fun() { return n; }; return sum(n - 1) + add(); }")
+		expectNumber(t, run(t, vm, "sum(1000);"), 500500)
+		expectLimit(t, vm, "sum(1024);", ErrCallDepth)
+
+		vm = NewVM(Options{Backend: backend, MaxCallDepth: 5000})
+		run(t, vm, "fun sum(n) { if (n == 0) return 0; return sum(n - 1) + n; }")
+		expectNumber(t, run(t, vm, "sum(4000);"), 8002000)
+		expectLimit(t, vm, "sum(5000);", ErrCallDepth)
+	}
+}
+
+func TestContext(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := vm.RunContext(ctx, "while (true) {}")
+		cancel()
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected cancellation, got %v", err)
+		}
+		expectNumber(t, run(t, vm, "1;"), 1)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		expectError(t, vm, "var x = ;", 1, "syntax error on line 1: expected expression")
+		expectError(t, vm, "\n#", 2, "lexical error on line 2: unexpected character")
+		expectError(t, vm, "return 1;", 1, "resolution error on line 1: cannot return from top-level code")
+		expectError(t, vm, "1;\n-nil;", 2, "runtime error on line 2: operand must be a number")
+		expectError(t, vm, "throw \"x\";", 1, "uncaught exception: \"x\"")
+		expectError(t, vm, "\xff", 1, "invalid UTF-8 sequence")
+		// The VM is still usable after an error.
+		expectNumber(t, run(t, vm, "1 + 1;"), 2)
+	}
+}
+
+func TestErrorLocations(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		expectLocation(t, vm, "var x = ;", diagnostics.CodeSyntax, 1, 9)
+		expectLocation(t, vm, "\n  #", diagnostics.CodeLexical, 2, 3)
+		expectLocation(t, vm, "{ return 1; }", diagnostics.CodeResolution, 1, 3)
+		expectLocation(t, vm, "1;\nprint 1 + nil;", diagnostics.CodeRuntime, 2, 9)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		if _, ok := vm.Global("x"); ok {
+			t.Error("expected x to be undefined")
+		}
+		if err := vm.SetGlobal("x", 40); err != nil {
+			t.Fatal(err)
+		}
+		expectNumber(t, run(t, vm, "x = x + 2; x;"), 42)
+		x, ok := vm.Global("x")
+		if !ok {
+			t.Fatal("expected x to be defined")
+		}
+		expectNumber(t, x, 42)
+		if err := vm.SetGlobal("x", struct{}{}); err == nil {
+			t.Error("expected an error for an unsupported type")
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		run(t, vm, "fun add(a, b) { return a + b; } fun adder(n) { return fun (x) { return x + n; }; }")
+		run(t, vm, "class Point { init(x) { this.x = x; } } fun fail() { return nil.x; }")
+		result, err := vm.Call("add", 1, 2.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectNumber(t, result, 3.5)
+		adder, err := vm.Call("adder", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err = vm.CallValue(adder, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectNumber(t, result, 15)
+		point, err := vm.Call("Point", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm.SetGlobal("p", point)
+		expectNumber(t, run(t, vm, "p.x;"), 3)
+		result, err = vm.Call("len", "four")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectNumber(t, result, 4)
+		expectCallError(t, vm, "add", "expected 2 arguments but got 1", 1)
+		expectCallError(t, vm, "fail", "runtime error on line 1: only instances have properties")
+		expectCallError(t, vm, "missing", "global variable 'missing' is not defined")
+		expectCallError(t, vm, "p", "can only call functions and classes")
+		expectCallError(t, vm, "len", "argument to 'len' must be a list, a map or a string", true)
+	}
+}
+
+func TestNestedCall(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend, MaxSteps: 10000})
+		define(t, vm, "apply", func(f Value, x float64) (Value, error) {
+			return vm.CallValue(f, x)
+		})
+		run(t, vm, "fun twice(x) { return x * 2; } fun fail(x) { return x + nil; }")
+		expectNumber(t, run(t, vm, "fun outer(x) { return apply(twice, x) + 1; } outer(20);"), 41)
+		// A failed nested call leaves the calls in progress as they were.
+		expectNumber(t, run(t, vm, `fun caught() {
+  var r = 0;
+  try { apply(fail, 1); } catch (e) { r = 1; }
+  return r + twice(2);
+}
+caught();`), 5)
+		expectError(t, vm, "fun g() {\n  apply(fail, 1);\n}\ng();", 2, "right operand must be a number")
+		// Nested calls share the budget of the run.
+		expectLimit(t, vm, "for (var i = 0; i < 10000; i = i + 1) apply(twice, i);", ErrStepLimit)
+		expectNumber(t, run(t, vm, "apply(twice, 3);"), 6)
+	}
+}
+
+func TestValue(t *testing.T) {
+	vm := NewVM(Options{})
+	list, err := run(t, vm, "[1, \"a\", [true]];").List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[2].String() != "[true]" {
+		t.Errorf("unexpected list %v", list)
+	}
+	m, err := run(t, vm, "({\"a\": 1, 2: nil});").Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNumber(t, m["a"], 1)
+	if !m[2.0].IsNil() {
+		t.Errorf("expected nil, got %v", m[2.0])
+	}
+	if _, err := run(t, vm, "\"1\";").Number(); err == nil || err.Error() != "expected a number, got \"1\"" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := run(t, vm, "1;").Bool(); err == nil || err.Error() != "expected a boolean, got 1" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	for _, backend := range backends {
+		vm := NewVM(Options{Backend: backend})
+		define(t, vm, "repeat", strings.Repeat)
+		define(t, vm, "sum", func(xs ...int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		})
+		define(t, vm, "join", func(separator string, parts ...string) string {
+			return strings.Join(parts, separator)
+		})
+		define(t, vm, "even", func(xs []float64) []float64 {
+			var even []float64
+			for i := 0; i < len(xs); i += 2 {
+				even = append(even, xs[i])
+			}
+			return even
+		})
+		define(t, vm, "invert", func(m map[string]int) map[int]string {
+			inverted := make(map[int]string)
+			for k, v := range m {
+				inverted[v] = k
+			}
+			return inverted
+		})
+		define(t, vm, "check", func(ok bool) error {
+			if !ok {
+				return errors.New("check failed")
+			}
+			return nil
+		})
+		define(t, vm, "parse", func(s string) (int, error) {
+			if s == "" {
+				return 0, errors.New("nothing to parse")
+			}
+			return len(s), nil
+		})
+		define(t, vm, "kind", func(v interface{}) string {
+			switch v.(type) {
+			case []interface{}:
+				return "slice"
+			case map[interface{}]interface{}:
+				return "map"
+			case nil:
+				return "nil"
+			default:
+				return "scalar"
+			}
+		})
+		define(t, vm, "apply", func(f Value) Value {
+			return f
+		})
+		define(t, vm, "sixth", func(xs []int) int {
+			return xs[5]
+		})
+
+		expectString(t, run(t, vm, "repeat(\"ab\", 3);"), "ababab")
+		expectNumber(t, run(t, vm, "sum();"), 0)
+		expectNumber(t, run(t, vm, "sum(1, 2, 3);"), 6)
+		expectString(t, run(t, vm, "join(\"-\", \"a\", \"b\");"), "a-b")
+		expectString(t, run(t, vm, "even([1, 2, 3]);"), "[1, 3]")
+		expectString(t, run(t, vm, "invert({\"a\": 1})[1];"), "a")
+		expectNumber(t, run(t, vm, "parse(\"abc\");"), 3)
+		expectString(t, run(t, vm, "kind([1]) + kind({}) + kind(nil) + kind(1);"), "slicemapnilscalar")
+		expectString(t, run(t, vm, "apply(clock);"), "<fn clock>")
+		if result := run(t, vm, "check(true);"); !result.IsNil() {
+			t.Errorf("expected nil, got %v", result)
+		}
+		expectNumber(t, run(t, vm, "var r; try { parse(\"\"); } catch (e) { r = e.line; } r;"), 1)
+
+		expectRunError(t, vm, "repeat(\"a\");", "runtime error on line 1: expected 2 arguments but got 1")
+		expectRunError(t, vm, "join();", "expected at least 1 arguments but got 0")
+		expectRunError(t, vm, "repeat(\"a\", 1.5);", "invalid argument 2 to 'repeat': expected an integer, got 1.5")
+		expectRunError(t, vm, "sum(1, \"2\");", "invalid argument 2 to 'sum': expected an integer, got \"2\"")
+		expectRunError(t, vm, "even([1, nil]);", "invalid argument 1 to 'even': expected a number, got <nil>")
+		expectRunError(t, vm, "\ncheck(false);", "runtime error on line 2: check failed")
+		expectRunError(t, vm, "\nsixth([1]);", "runtime error on line 2: 'sixth' panicked: runtime error: index out of range [5] with length 1")
+		expectNumber(t, run(t, vm, "var line; try { sixth([1]); } catch (e) { line = e.line; } line;"), 1)
+	}
+}
+
+func TestDefineInvalid(t *testing.T) {
+	vm := NewVM(Options{})
+	if err := vm.Define("f", 1); err == nil || err.Error() != "cannot define 'f': expected a function, got int" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := vm.Define("f", func() (int, int) { return 0, 0 }); err == nil {
+		t.Error("expected an error for a function returning two values")
+	}
+	vm.Define("f", func() chan int { return nil })
+	expectRunError(t, vm, "f();", "invalid result from 'f': cannot use a value of type chan int in Lox")
+}
+
+func TestSetGlobalConversions(t *testing.T) {
+	vm := NewVM(Options{Backend: Bytecode})
+	vm.SetGlobal("xs", []int{1, 2})
+	vm.SetGlobal("m", map[string][]string{"a": {"b"}})
+	vm.SetGlobal("n", uint8(7))
+	expectString(t, run(t, vm, "xs;"), "[1, 2]")
+	expectString(t, run(t, vm, "m[\"a\"][0];"), "b")
+	expectNumber(t, run(t, vm, "n;"), 7)
+	if err := vm.SetGlobal("bad", map[[2]int]int{{1, 2}: 3}); err == nil {
+		t.Error("expected an error for a map with unhashable keys")
+	}
+}
+
+func run(t *testing.T, vm *VM, src string) Value {
+	t.Helper()
+	result, err := vm.Run(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func expectNumber(t *testing.T, value Value, expected float64) {
+	t.Helper()
+	if n, err := value.Number(); err != nil {
+		t.Error(err)
+	} else if n != expected {
+		t.Errorf("expected %v, got %v", expected, n)
+	}
+}
+
+func expectString(t *testing.T, value Value, expected string) {
+	t.Helper()
+	if value.String() != expected {
+		t.Errorf("expected %s, got %v", expected, value)
+	}
+}
+
+func define(t *testing.T, vm *VM, name string, function interface{}) {
+	t.Helper()
+	if err := vm.Define(name, function); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectRunError(t *testing.T, vm *VM, src string, message string) {
+	t.Helper()
+	_, err := vm.Run(src)
+	if err == nil || !strings.Contains(err.Error(), message) {
+		t.Errorf("expected error containing '%s', got '%v'", message, err)
+	}
+}
+
+func expectLimit(t *testing.T, vm *VM, src string, limit error) {
+	t.Helper()
+	if _, err := vm.Run(src); !errors.Is(err, limit) {
+		t.Errorf("expected error wrapping '%v', got %v", limit, err)
+	}
+}
+
+func expectError(t *testing.T, vm *VM, src string, line int, regex string) {
+	t.Helper()
+	_, err := vm.Run(src)
+	var loxErr Error
+	if !errors.As(err, &loxErr) {
+		t.Fatalf("expected a Lox error, got %v", err)
+	}
+	if loxErr.Line() != line {
+		t.Errorf("expected an error on line %d, got %v", line, err)
+	}
+	if !regexp.MustCompile(regexp.QuoteMeta(regex)).MatchString(err.Error()) {
+		t.Errorf("expected error matching '%s', got '%v'", regex, err)
+	}
+}
+
+func expectLocation(t *testing.T, vm *VM, src string, code string, line int, column int) {
+	t.Helper()
+	_, err := vm.Run(src)
+	var loxErr Error
+	if !errors.As(err, &loxErr) {
+		t.Fatalf("expected a Lox error, got %v", err)
+	}
+	if loxErr.Code() != code || loxErr.Line() != line || loxErr.Column() != column {
+		t.Errorf("expected error %s at %d:%d, got %s at %d:%d (%v)", code, line, column, loxErr.Code(), loxErr.Line(), loxErr.Column(), err)
+	}
+}
+
+func expectCallError(t *testing.T, vm *VM, name string, message string, arguments ...interface{}) {
+	t.Helper()
+	_, err := vm.Call(name, arguments...)
+	if err == nil || !strings.Contains(err.Error(), message) {
+		t.Errorf("expected error containing '%s', got '%v'", message, err)
+	}
+}
@@ -0,0 +1,329 @@
+package lsp
+
+import (
+	"bufio"
+	"lox/ast"
+	"lox/diagnostics"
+	"lox/parser"
+	"lox/resolver"
+	"lox/scanner"
+	"lox/token"
+	"sort"
+	"strings"
+)
+
+// document is an open script, analysed again whenever it changes. Besides its errors, it knows
+// where every variable is declared and used, as told by the resolver.
+type document struct {
+	uri          string
+	version      int
+	text         string
+	lines        []string
+	program      *ast.Program
+	parsed       bool
+	errs         []error
+	declarations []declaration
+	uses         []use
+	// functions are the function declarations, methods included, by the position of their name.
+	functions map[token.Position]*ast.FunDeclStmt
+	classes   map[token.Position]*ast.ClassStmt
+	symbols   []documentSymbol
+}
+
+type declaration struct {
+	name   token.Token
+	global bool
+}
+
+// use is a variable read or assigned. Its declaration is nil when the variable is global.
+type use struct {
+	name        token.Token
+	declaration *token.Token
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{
+		uri:       uri,
+		version:   version,
+		text:      text,
+		lines:     strings.Split(text, "\n"),
+		functions: make(map[token.Position]*ast.FunDeclStmt),
+		classes:   make(map[token.Position]*ast.ClassStmt),
+	}
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(text))))
+	d.program, d.errs = p.ParseProgram()
+	d.parsed = len(d.errs) == 0
+	r := resolver.NewResolver(noLocals{})
+	r.SetListener(d)
+	d.errs = r.ResolveProgram(d.program, d.errs)
+	d.symbols = d.index(d.program.Statements)
+	return d
+}
+
+// noLocals stands for the interpreter, which does not need to know about resolved variables here.
+type noLocals struct{}
+
+func (noLocals) Resolve(expr ast.Expr, depth int, slot int) {}
+
+func (d *document) Declare(name token.Token, global bool) {
+	d.declarations = append(d.declarations, declaration{name: name, global: global})
+}
+
+func (d *document) Use(name token.Token, declaration *token.Token) {
+	u := use{name: name}
+	if declaration != nil {
+		decl := *declaration
+		u.declaration = &decl
+	}
+	d.uses = append(d.uses, u)
+}
+
+// index returns the symbols declared by statements, looking into nested ones, and records the
+// functions and classes they declare.
+func (d *document) index(stmts []ast.Stmt) []documentSymbol {
+	var symbols []documentSymbol
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.FunDeclStmt:
+			symbols = append(symbols, d.function(stmt, functionSymbol))
+		case *ast.ClassStmt:
+			d.classes[stmt.Name.Span.Start] = stmt
+			symbol := documentSymbol{
+				Name:           stmt.Name.Lexeme,
+				Kind:           classSymbol,
+				Range:          d.lspRange(stmt.Span),
+				SelectionRange: d.lspRange(stmt.Name.Span),
+			}
+			for _, method := range stmt.Methods {
+				symbol.Children = append(symbol.Children, d.function(method, methodSymbol))
+			}
+			symbols = append(symbols, symbol)
+		case *ast.BlockStmt:
+			symbols = append(symbols, d.index(stmt.Statements)...)
+		case *ast.IfStmt:
+			symbols = append(symbols, d.index([]ast.Stmt{*stmt.ThenBranch})...)
+			if stmt.ElseBranch != nil {
+				symbols = append(symbols, d.index([]ast.Stmt{*stmt.ElseBranch})...)
+			}
+		case *ast.WhileStmt:
+			symbols = append(symbols, d.index([]ast.Stmt{stmt.Body})...)
+		case *ast.ForStmt:
+			symbols = append(symbols, d.index([]ast.Stmt{stmt.Body})...)
+		case *ast.TryStmt:
+			for _, block := range []*ast.BlockStmt{stmt.Body, stmt.Catch, stmt.Finally} {
+				if block != nil {
+					symbols = append(symbols, d.index(block.Statements)...)
+				}
+			}
+		}
+	}
+	return symbols
+}
+
+func (d *document) function(stmt *ast.FunDeclStmt, kind int) documentSymbol {
+	d.functions[stmt.Name.Span.Start] = stmt
+	return documentSymbol{
+		Name:           stmt.Name.Lexeme,
+		Detail:         signature(stmt.Params),
+		Kind:           kind,
+		Range:          d.lspRange(stmt.Span),
+		SelectionRange: d.lspRange(stmt.Name.Span),
+		Children:       d.index(stmt.Body.Statements),
+	}
+}
+
+func signature(params []token.Token) string {
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.Lexeme
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// diagnostics returns the errors of the document as LSP diagnostics, with their notes and
+// hints appended to the message.
+func (d *document) diagnostics() []lspDiagnostic {
+	result := []lspDiagnostic{}
+	for _, err := range d.errs {
+		diagnostic := diagnostics.FromError(err)
+		message := diagnostic.Message
+		for _, note := range diagnostic.Notes {
+			message += "\nnote: " + note
+		}
+		for _, hint := range diagnostic.Hints {
+			message += "\nhint: " + hint
+		}
+		result = append(result, lspDiagnostic{
+			Range:    d.lspRange(diagnostic.Span),
+			Severity: severity(diagnostic.Severity),
+			Code:     diagnostic.Code,
+			Source:   "lox",
+			Message:  message,
+		})
+	}
+	return result
+}
+
+func severity(s diagnostics.Severity) int {
+	switch s {
+	case diagnostics.Warning:
+		return 2
+	case diagnostics.Note:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// nameAt returns the variable name at a position, along with its declaration. The declaration of
+// a global is the first one in the document, and is nil if there is none.
+func (d *document) nameAt(p position) (token.Token, *token.Token, bool) {
+	at := d.tokenPosition(p)
+	for _, decl := range d.declarations {
+		if contains(decl.name.Span, at) {
+			name := decl.name
+			return name, &name, true
+		}
+	}
+	for _, u := range d.uses {
+		if contains(u.name.Span, at) {
+			if u.declaration == nil {
+				return u.name, d.global(u.name.Lexeme), true
+			}
+			return u.name, u.declaration, true
+		}
+	}
+	return token.Token{}, nil, false
+}
+
+func (d *document) global(name string) *token.Token {
+	for _, decl := range d.declarations {
+		if decl.global && decl.name.Lexeme == name {
+			return &decl.name
+		}
+	}
+	return nil
+}
+
+// references returns the names referring to a declaration, in the order they appear.
+func (d *document) references(declaration token.Token, includeDeclaration bool) []token.Token {
+	var names []token.Token
+	if includeDeclaration {
+		names = append(names, declaration)
+	}
+	for _, u := range d.uses {
+		decl := u.declaration
+		if decl == nil {
+			decl = d.global(u.name.Lexeme)
+		}
+		if decl != nil && decl.Span == declaration.Span {
+			names = append(names, u.name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Span.Start.Offset < names[j].Span.Start.Offset })
+	return names
+}
+
+// hover describes the function or class named at a position, or returns false if there is none.
+func (d *document) hover(p position) (string, token.Span, bool) {
+	at := d.tokenPosition(p)
+	for _, function := range d.functions {
+		if contains(function.Name.Span, at) {
+			return "fun " + function.Name.Lexeme + signature(function.Params), function.Name.Span, true
+		}
+	}
+	name, decl, ok := d.nameAt(p)
+	if !ok || decl == nil {
+		return "", token.Span{}, false
+	}
+	if function, ok := d.functions[decl.Span.Start]; ok {
+		return "fun " + function.Name.Lexeme + signature(function.Params), name.Span, true
+	}
+	if class, ok := d.classes[decl.Span.Start]; ok {
+		text := "class " + class.Name.Lexeme
+		if class.Superclass != nil {
+			text += " < " + class.Superclass.Name.Lexeme
+		}
+		for _, method := range class.Methods {
+			if method.Name.Lexeme == "init" {
+				text += "\n\ninit" + signature(method.Params)
+			}
+		}
+		return text, name.Span, true
+	}
+	return "", token.Span{}, false
+}
+
+func contains(span token.Span, p token.Position) bool {
+	return span.Start.Line == p.Line && span.Start.Column <= p.Column && p.Column <= span.End.Column
+}
+
+// tokenPosition converts an LSP position to a line and column of the source. Its offset is left
+// out, as it is not needed to find tokens.
+func (d *document) tokenPosition(p position) token.Position {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return token.Position{}
+	}
+	column, units := 1, 0
+	for _, r := range d.lines[p.Line] {
+		if units >= p.Character {
+			break
+		}
+		units += utf16Units(r)
+		column++
+	}
+	return token.Position{Line: p.Line + 1, Column: column}
+}
+
+// lspPosition converts a position in the source to an LSP one. A position without a column stands
+// for the start of its line, unless end is set.
+func (d *document) lspPosition(p token.Position, end bool) position {
+	if p.Line <= 0 {
+		return position{}
+	}
+	if p.Line > len(d.lines) {
+		return d.end()
+	}
+	line := d.lines[p.Line-1]
+	if p.Column == 0 && end {
+		return position{Line: p.Line - 1, Character: utf16Length(line)}
+	}
+	character := 0
+	for i, r := range []rune(line) {
+		if i >= p.Column-1 {
+			break
+		}
+		character += utf16Units(r)
+	}
+	return position{Line: p.Line - 1, Character: character}
+}
+
+func (d *document) lspRange(span token.Span) lspRange {
+	return lspRange{Start: d.lspPosition(span.Start, false), End: d.lspPosition(span.End, true)}
+}
+
+// end returns the position after the last character of the document.
+func (d *document) end() position {
+	return position{Line: len(d.lines) - 1, Character: utf16Length(d.lines[len(d.lines)-1])}
+}
+
+func (d *document) location(span token.Span) location {
+	return location{URI: d.uri, Range: d.lspRange(span)}
+}
+
+func utf16Length(s string) int {
+	length := 0
+	for _, r := range s {
+		length += utf16Units(r)
+	}
+	return length
+}
+
+// utf16Units returns how many UTF-16 code units encode a rune: runes outside of the basic
+// multilingual plane take a surrogate pair.
+func utf16Units(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
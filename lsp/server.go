@@ -0,0 +1,242 @@
+// Package lsp implements a language server for Lox, speaking the Language Server Protocol over a
+// pair of streams. It reuses the scanner, parser and resolver to report errors as the user types,
+// find declarations and references of variables, describe functions, list the functions of a
+// script and format it.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lox/format"
+	"lox/framing"
+)
+
+// Server answers the requests of a single client, keeping the documents it opened.
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	documents   map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, documents: make(map[string]*document)}
+}
+
+// Serve handles messages until the client asks the server to exit. It fails if the client exits
+// without shutting the server down first, as the protocol requires, or if the input ends.
+func (s *Server) Serve() error {
+	for {
+		content, err := framing.Read(s.in)
+		if err == io.EOF {
+			return errors.New("input ended before the exit notification")
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			if err := s.respond(nil, nil, &responseError{parseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		result, err := s.handle(req)
+		if req.ID == nil {
+			// Notifications cannot be answered, even with an error.
+			continue
+		}
+		var re *responseError
+		if err != nil && !errors.As(err, &re) {
+			re = &responseError{invalidRequest, err.Error()}
+		}
+		if err := s.respond(req.ID, result, re); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) respond(id *json.RawMessage, result interface{}, re *responseError) error {
+	res := response{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	if id != nil {
+		res.ID = *id
+	}
+	if re != nil {
+		res.Error = re
+	} else {
+		content, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		res.Result = content
+	}
+	return framing.Write(s.out, res)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return framing.Write(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+	switch {
+	case req.Method == "initialize":
+		s.initialized = true
+		return initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:           syncFull,
+				DefinitionProvider:         true,
+				ReferencesProvider:         true,
+				HoverProvider:              true,
+				DocumentSymbolProvider:     true,
+				DocumentFormattingProvider: true,
+			},
+			ServerInfo: serverInfo{Name: "lox"},
+		}, nil
+	case !s.initialized:
+		return nil, &responseError{serverNotInitialized, "server not initialized"}
+	case s.shutdown:
+		return nil, &responseError{invalidRequest, "server shut down"}
+	}
+	switch req.Method {
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.open(newDocument(params.TextDocument.URI, params.TextDocument.Version, params.TextDocument.Text))
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// With full synchronization, the last change holds the whole text.
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.open(newDocument(params.TextDocument.URI, params.TextDocument.Version, text))
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []lspDiagnostic{},
+		})
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		d, err := s.document(req.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		_, decl, ok := d.nameAt(params.Position)
+		if !ok || decl == nil {
+			return nil, nil
+		}
+		return d.location(decl.Span), nil
+	case "textDocument/references":
+		var params referenceParams
+		d, err := s.document(req.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		_, decl, ok := d.nameAt(params.Position)
+		if !ok || decl == nil {
+			return []location{}, nil
+		}
+		locations := []location{}
+		for _, name := range d.references(*decl, params.Context.IncludeDeclaration) {
+			locations = append(locations, d.location(name.Span))
+		}
+		return locations, nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		d, err := s.document(req.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		text, span, ok := d.hover(params.Position)
+		if !ok {
+			return nil, nil
+		}
+		return hover{
+			Contents: markupContent{Kind: "markdown", Value: "```lox\n" + text + "\n```"},
+			Range:    d.lspRange(span),
+		}, nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		d, err := s.document(req.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		if d.symbols == nil {
+			return []documentSymbol{}, nil
+		}
+		return d.symbols, nil
+	case "textDocument/formatting":
+		var params formattingParams
+		d, err := s.document(req.Params, &params, &params.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		// Scripts with syntax errors are left alone, as the parts that failed to parse would be lost.
+		if !d.parsed {
+			return nil, nil
+		}
+		f := format.NewFormatter()
+		f.SetStyle(format.Style{Spaces: params.Options.InsertSpaces, IndentWidth: params.Options.TabSize})
+		formatted := f.FormatProgram(d.program)
+		if formatted == d.text {
+			return []textEdit{}, nil
+		}
+		return []textEdit{{Range: lspRange{End: d.end()}, NewText: formatted}}, nil
+	default:
+		return nil, &responseError{methodNotFound, fmt.Sprintf("unknown method '%s'", req.Method)}
+	}
+}
+
+// open keeps a document the client opened or changed, and publishes its diagnostics.
+func (s *Server) open(d *document) error {
+	s.documents[d.uri] = d
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         d.uri,
+		Version:     &d.version,
+		Diagnostics: d.diagnostics(),
+	})
+}
+
+// document decodes the parameters of a request about a document, and returns that document.
+func (s *Server) document(raw json.RawMessage, params interface{}, id *textDocumentIdentifier) (*document, error) {
+	if err := decode(raw, params); err != nil {
+		return nil, err
+	}
+	d, ok := s.documents[id.URI]
+	if !ok {
+		return nil, &responseError{invalidParams, fmt.Sprintf("document '%s' is not open", id.URI)}
+	}
+	return d, nil
+}
+
+func decode(raw json.RawMessage, params interface{}) error {
+	if err := json.Unmarshal(raw, params); err != nil {
+		return &responseError{invalidParams, err.Error()}
+	}
+	return nil
+}
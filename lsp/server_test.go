@@ -0,0 +1,297 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"lox/framing"
+	"strings"
+	"testing"
+)
+
+const script = `var greeting = "hi";
+fun greet(name, times) {
+  var i = 0;
+  while (i < times) {
+    print greeting + name;
+    i = i + 1;
+  }
+}
+class Greeter {
+  init(name) { this.name = name; }
+  greet() { greet(this.name, 1); }
+}
+greet("you", 2);
+`
+
+func TestServerDiagnostics(t *testing.T) {
+	messages := serve(t, open("file:///a.lox", "var a = 1;\nprint a +;\n{ var b = b; }\n"))
+	expectMessage(t, messages, "textDocument/publishDiagnostics", `{"uri":"file:///a.lox","version":1,"diagnostics":[
+		{"range":{"start":{"line":1,"character":9},"end":{"line":1,"character":10}},"severity":1,"code":"E0002","source":"lox","message":"expected expression"},
+		{"range":{"start":{"line":2,"character":10},"end":{"line":2,"character":11}},"severity":1,"code":"E0003","source":"lox","message":"cannot read local variable in its own initializer"}]}`)
+}
+
+func TestServerDiagnosticsCleared(t *testing.T) {
+	messages := serve(t,
+		open("file:///a.lox", "print;\n"),
+		notification{Method: "textDocument/didChange", Params: map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": "file:///a.lox", "version": 2},
+			"contentChanges": []interface{}{map[string]interface{}{"text": "print 1;\n"}},
+		}},
+		notification{Method: "textDocument/didClose", Params: textDocument("file:///a.lox")})
+	var published []string
+	for _, message := range messages {
+		if message["method"] == "textDocument/publishDiagnostics" {
+			params := message["params"].(map[string]interface{})
+			published = append(published, normalize(t, params["diagnostics"]))
+		}
+	}
+	if len(published) != 3 || published[0] == "[]" || published[1] != "[]" || published[2] != "[]" {
+		t.Errorf("expected diagnostics to be published then cleared, got %v", published)
+	}
+}
+
+func TestServerDefinition(t *testing.T) {
+	messages := serve(t, open("file:///a.lox", script),
+		call(2, "textDocument/definition", at("file:///a.lox", 4, 24)),
+		call(3, "textDocument/definition", at("file:///a.lox", 4, 12)),
+		call(4, "textDocument/definition", at("file:///a.lox", 10, 13)),
+		call(5, "textDocument/definition", at("file:///a.lox", 4, 4)))
+	expectResult(t, messages, 2, `{"uri":"file:///a.lox","range":{"start":{"line":1,"character":10},"end":{"line":1,"character":14}}}`)
+	expectResult(t, messages, 3, `{"uri":"file:///a.lox","range":{"start":{"line":0,"character":4},"end":{"line":0,"character":12}}}`)
+	expectResult(t, messages, 4, `{"uri":"file:///a.lox","range":{"start":{"line":1,"character":4},"end":{"line":1,"character":9}}}`)
+	expectResult(t, messages, 5, `null`)
+}
+
+func TestServerReferences(t *testing.T) {
+	messages := serve(t, open("file:///a.lox", script),
+		call(2, "textDocument/references", references("file:///a.lox", 2, 7, true)),
+		call(3, "textDocument/references", references("file:///a.lox", 12, 0, false)))
+	expectResult(t, messages, 2, `[
+		{"uri":"file:///a.lox","range":{"start":{"line":2,"character":6},"end":{"line":2,"character":7}}},
+		{"uri":"file:///a.lox","range":{"start":{"line":3,"character":9},"end":{"line":3,"character":10}}},
+		{"uri":"file:///a.lox","range":{"start":{"line":5,"character":4},"end":{"line":5,"character":5}}},
+		{"uri":"file:///a.lox","range":{"start":{"line":5,"character":8},"end":{"line":5,"character":9}}}]`)
+	expectResult(t, messages, 3, `[
+		{"uri":"file:///a.lox","range":{"start":{"line":10,"character":12},"end":{"line":10,"character":17}}},
+		{"uri":"file:///a.lox","range":{"start":{"line":12,"character":0},"end":{"line":12,"character":5}}}]`)
+}
+
+func TestServerHover(t *testing.T) {
+	messages := serve(t, open("file:///a.lox", script),
+		call(2, "textDocument/hover", at("file:///a.lox", 12, 2)),
+		call(3, "textDocument/hover", at("file:///a.lox", 10, 3)),
+		call(4, "textDocument/hover", at("file:///a.lox", 8, 8)),
+		call(5, "textDocument/hover", at("file:///a.lox", 2, 6)))
+	expectResult(t, messages, 2, `{"contents":{"kind":"markdown","value":"`+"```lox\\nfun greet(name, times)\\n```"+`"},
+		"range":{"start":{"line":12,"character":0},"end":{"line":12,"character":5}}}`)
+	expectResult(t, messages, 3, `{"contents":{"kind":"markdown","value":"`+"```lox\\nfun greet()\\n```"+`"},
+		"range":{"start":{"line":10,"character":2},"end":{"line":10,"character":7}}}`)
+	expectResult(t, messages, 4, `{"contents":{"kind":"markdown","value":"`+"```lox\\nclass Greeter\\n\\ninit(name)\\n```"+`"},
+		"range":{"start":{"line":8,"character":6},"end":{"line":8,"character":13}}}`)
+	expectResult(t, messages, 5, `null`)
+}
+
+func TestServerDocumentSymbols(t *testing.T) {
+	messages := serve(t, open("file:///a.lox", "fun f(a) {\n  fun g() {}\n}\nclass A {\n  m() {}\n}\n"),
+		call(2, "textDocument/documentSymbol", map[string]interface{}{"textDocument": textDocument("file:///a.lox")}))
+	expectResult(t, messages, 2, `[
+		{"name":"f","detail":"(a)","kind":12,
+			"range":{"start":{"line":0,"character":0},"end":{"line":2,"character":1}},
+			"selectionRange":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}},
+			"children":[{"name":"g","detail":"()","kind":12,
+				"range":{"start":{"line":1,"character":2},"end":{"line":1,"character":12}},
+				"selectionRange":{"start":{"line":1,"character":6},"end":{"line":1,"character":7}}}]},
+		{"name":"A","kind":5,
+			"range":{"start":{"line":3,"character":0},"end":{"line":5,"character":1}},
+			"selectionRange":{"start":{"line":3,"character":6},"end":{"line":3,"character":7}},
+			"children":[{"name":"m","detail":"()","kind":6,
+				"range":{"start":{"line":4,"character":2},"end":{"line":4,"character":8}},
+				"selectionRange":{"start":{"line":4,"character":2},"end":{"line":4,"character":3}}}]}]`)
+}
+
+func TestServerFormatting(t *testing.T) {
+	messages := serve(t,
+		open("file:///a.lox", "fun f(a) { return a; }\n"),
+		open("file:///b.lox", "fun f(a)\n{\n  return a;\n}\n"),
+		open("file:///c.lox", "fun f(a) { return a }\n"),
+		call(2, "textDocument/formatting", formatting("file:///a.lox")),
+		call(3, "textDocument/formatting", formatting("file:///b.lox")),
+		call(4, "textDocument/formatting", formatting("file:///c.lox")))
+	expectResult(t, messages, 2, `[{"range":{"start":{"line":0,"character":0},"end":{"line":1,"character":0}},"newText":"fun f(a)\n{\n  return a;\n}\n"}]`)
+	expectResult(t, messages, 3, `[]`)
+	expectResult(t, messages, 4, `null`)
+}
+
+func TestServerUTF16(t *testing.T) {
+	messages := serve(t, open("file:///a.lox", "var s = \"😀\"; print s;\nprint \"é\" + t;\n"),
+		call(2, "textDocument/definition", at("file:///a.lox", 0, 21)))
+	expectResult(t, messages, 2, `{"uri":"file:///a.lox","range":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}}}`)
+	expectMessage(t, messages, "textDocument/publishDiagnostics", `{"uri":"file:///a.lox","version":1,"diagnostics":[]}`)
+}
+
+func TestServerErrors(t *testing.T) {
+	messages := serve(t,
+		call(2, "textDocument/hover", at("file:///missing.lox", 0, 0)),
+		call(3, "unknown", nil))
+	expectError(t, messages, 2, invalidParams)
+	expectError(t, messages, 3, methodNotFound)
+
+	input := frame(t, call(1, "textDocument/hover", nil)) + frame(t, notification{Method: "exit"})
+	out := bytes.Buffer{}
+	if err := NewServer(strings.NewReader(input), &out).Serve(); err == nil {
+		t.Errorf("expected exiting without shutting down to fail")
+	}
+	messages = decodeMessages(t, out.String())
+	expectError(t, messages, 1, serverNotInitialized)
+}
+
+// clientRequest is a request from the test client.
+type clientRequest struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+func call(id int, method string, params interface{}) clientRequest {
+	return clientRequest{ID: id, Method: method, Params: params}
+}
+
+func open(uri string, text string) notification {
+	return notification{Method: "textDocument/didOpen", Params: map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "lox", "version": 1, "text": text},
+	}}
+}
+
+func textDocument(uri string) map[string]interface{} {
+	return map[string]interface{}{"uri": uri}
+}
+
+func at(uri string, line int, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": textDocument(uri),
+		"position":     map[string]interface{}{"line": line, "character": character},
+	}
+}
+
+func references(uri string, line int, character int, includeDeclaration bool) map[string]interface{} {
+	params := at(uri, line, character)
+	params["context"] = map[string]interface{}{"includeDeclaration": includeDeclaration}
+	return params
+}
+
+func formatting(uri string) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": textDocument(uri),
+		"options":      map[string]interface{}{"tabSize": 2, "insertSpaces": true},
+	}
+}
+
+func frame(t *testing.T, message interface{}) string {
+	t.Helper()
+	out := bytes.Buffer{}
+	if err := framing.Write(&out, message); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// serve runs a session where the client initializes the server, sends the given messages, then
+// shuts it down, and returns the messages the server sent.
+func serve(t *testing.T, messages ...interface{}) []map[string]interface{} {
+	t.Helper()
+	input := frame(t, call(1, "initialize", map[string]interface{}{})) +
+		frame(t, notification{Method: "initialized", Params: map[string]interface{}{}})
+	for _, message := range messages {
+		input += frame(t, message)
+	}
+	input += frame(t, call(1000, "shutdown", nil)) + frame(t, notification{Method: "exit"})
+	out := bytes.Buffer{}
+	if err := NewServer(strings.NewReader(input), &out).Serve(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return decodeMessages(t, out.String())
+}
+
+func decodeMessages(t *testing.T, output string) []map[string]interface{} {
+	t.Helper()
+	var messages []map[string]interface{}
+	r := bufio.NewReader(strings.NewReader(output))
+	for {
+		content, err := framing.Read(r)
+		if err != nil {
+			break
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(content, &message); err != nil {
+			t.Fatalf("invalid message '%s': %v", content, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func responseTo(t *testing.T, messages []map[string]interface{}, id int) map[string]interface{} {
+	t.Helper()
+	for _, message := range messages {
+		if message["id"] == float64(id) {
+			return message
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+	return nil
+}
+
+func expectResult(t *testing.T, messages []map[string]interface{}, id int, expected string) {
+	t.Helper()
+	message := responseTo(t, messages, id)
+	if message["error"] != nil {
+		t.Fatalf("unexpected error in response to request %d: %v", id, message["error"])
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(expected), &value); err != nil {
+		t.Fatalf("invalid expected result: %v", err)
+	}
+	if actual, expected := normalize(t, message["result"]), normalize(t, value); actual != expected {
+		t.Errorf("expected result to request %d:\n%s\ngot:\n%s", id, expected, actual)
+	}
+}
+
+func expectError(t *testing.T, messages []map[string]interface{}, id int, code int) {
+	t.Helper()
+	message := responseTo(t, messages, id)
+	err, ok := message["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected error in response to request %d, got %v", id, message)
+	}
+	if err["code"] != float64(code) {
+		t.Errorf("expected error code %d in response to request %d, got %v", code, id, err["code"])
+	}
+}
+
+func expectMessage(t *testing.T, messages []map[string]interface{}, method string, params string) {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(params), &value); err != nil {
+		t.Fatalf("invalid expected parameters: %v", err)
+	}
+	for _, message := range messages {
+		if message["method"] == method {
+			if actual, expected := normalize(t, message["params"]), normalize(t, value); actual != expected {
+				t.Errorf("expected %s notification with:\n%s\ngot:\n%s", method, expected, actual)
+			}
+			return
+		}
+	}
+	t.Errorf("expected %s notification", method)
+}
+
+// normalize returns decoded JSON encoded again, so that values compare as strings.
+func normalize(t *testing.T, value interface{}) string {
+	t.Helper()
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"lox/builtin"
+	"lox/debugger"
+	"lox/diagnostics"
+	"lox/interpreter"
+	"lox/parser"
+	"lox/resolver"
+	"lox/scanner"
+	"os"
+	"strings"
+)
+
+// debugCommand runs a script in the debugger, stopping at its first statement, and returns the
+// exit status.
+func debugCommand(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lox debug path/to/script.lox")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 64
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 64
+	}
+	path := flags.Arg(0)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	streams := builtin.StandardStreams()
+	i := interpreter.NewInterpreter()
+	i.SetStreams(streams)
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(string(source)))))
+	program, errs := p.ParseProgram()
+	errs = resolver.NewResolver(i).ResolveProgram(program, errs)
+	reporter := diagnostics.NewReporter(diagnostics.Text, streams.Stderr, path)
+	defer reporter.Flush()
+	for _, err := range errs {
+		reporter.Report(diagnostics.FromError(err), string(source))
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	// The console reads commands from the same stdin as the script.
+	d := debugger.NewDebugger(i, debugger.NewConsole(streams.Stdin, streams.Stdout, string(source)))
+	d.SetStopOnEntry(true)
+	for _, stmt := range program.Statements {
+		if _, err := i.Interpret(stmt); errors.Is(err, debugger.ErrQuit) {
+			return 0
+		} else if err != nil {
+			reporter.Report(diagnostics.FromError(err), string(source))
+			return 1
+		}
+	}
+	return 0
+}
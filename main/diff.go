@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines are shown around changes.
+const diffContext = 3
+
+// edit is a line of an edit script: kept (' '), removed ('-') or added ('+'). Lines keep their
+// line ending, so that a missing one at the end of a file counts as a change.
+type edit struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the changes turning a into b in the unified format, or nothing if they
+// are the same.
+func unifiedDiff(name string, a string, b string) string {
+	if a == b {
+		return ""
+	}
+	edits := diffLines(splitLines(a), splitLines(b))
+	// Count the lines of each side before every edit, to number the hunks.
+	before := make([][2]int, len(edits)+1)
+	for i, e := range edits {
+		before[i+1] = before[i]
+		if e.kind != '+' {
+			before[i+1][0]++
+		}
+		if e.kind != '-' {
+			before[i+1][1]++
+		}
+	}
+	builder := strings.Builder{}
+	fmt.Fprintf(&builder, "--- a/%s\n+++ b/%s\n", name, name)
+	for i := 0; i < len(edits); {
+		for i < len(edits) && edits[i].kind == ' ' {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+		start, end := max(i-diffContext, 0), i
+		// A hunk goes on until there are too many unchanged lines to show before the next change.
+		for end < len(edits) {
+			if edits[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].kind == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*diffContext {
+				end = min(end+diffContext, len(edits))
+				break
+			}
+			end = next
+		}
+		fmt.Fprintf(&builder, "@@ -%s +%s @@\n", hunkRange(before[start][0], before[end][0]), hunkRange(before[start][1], before[end][1]))
+		for _, e := range edits[start:end] {
+			builder.WriteByte(e.kind)
+			builder.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				builder.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return builder.String()
+}
+
+// hunkRange returns the range of lines of a hunk, given the lines before its start and its end.
+// Empty ranges start at the line before them.
+func hunkRange(start int, end int) string {
+	if start == end {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning a into b, using Myers' algorithm. The
+// furthest point reached on each diagonal is kept for every number of changes, to find the
+// path back once b is reached.
+func diffLines(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+	for d := 0; ; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+}
+
+func backtrack(trace [][]int, a []string, b []string) []edit {
+	var edits []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		previous := func(k int) int { return trace[d][k+d] }
+		k := x - y
+		var previousK int
+		if k == -d || (k != d && previous(k-1) < previous(k+1)) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := previous(previousK)
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x, y = x-1, y-1
+		}
+		if x == previousX {
+			edits = append(edits, edit{'+', b[y-1]})
+			y--
+		} else {
+			edits = append(edits, edit{'-', a[x-1]})
+			x--
+		}
+	}
+	for ; x > 0; x-- {
+		edits = append(edits, edit{' ', a[x-1]})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
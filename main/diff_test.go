@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n"
+	expected := "--- a/x\n+++ b/x\n" +
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+		"@@ -9,4 +9,3 @@\n 9\n 10\n 11\n-12\n"
+	if got := unifiedDiff("x", a, b); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestUnifiedDiffEdges(t *testing.T) {
+	if got := unifiedDiff("x", "same\n", "same\n"); got != "" {
+		t.Errorf("expected no diff, got:\n%s", got)
+	}
+	if got, expected := unifiedDiff("x", "", "a\n"), "--- a/x\n+++ b/x\n@@ -0,0 +1,1 @@\n+a\n"; got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+	expected := "--- a/x\n+++ b/x\n@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n"
+	if got := unifiedDiff("x", "a", "a\n"); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
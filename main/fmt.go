@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"lox/diagnostics"
+	"lox/format"
+	"lox/parser"
+	"lox/scanner"
+	"os"
+	"strings"
+)
+
+// formatCommand reformats the scripts given, or stdin if none is, and returns the exit status.
+func formatCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	check := flags.Bool("check", false, "only report the files that are not formatted, and fail if there are any")
+	diff := flags.Bool("diff", false, "print the changes formatting would make instead of making them")
+	var style format.Style
+	flags.BoolVar(&style.Spaces, "spaces", false, "indent with spaces instead of tabs")
+	flags.IntVar(&style.IndentWidth, "indent-width", 4, "columns per level of indentation")
+	flags.Var(&style.Braces, "braces", "where opening braces go, either 'next-line' or 'same-line'")
+	flags.IntVar(&style.MaxWidth, "max-width", 0, "wrap call arguments on lines wider than this, unless 0")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lox fmt [--check] [--diff] [style options] [path/to/script.lox ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 64
+	}
+	if flags.NArg() == 0 {
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		formatted, ok := formatSource("", string(source), style)
+		switch {
+		case !ok:
+			return 1
+		case *diff:
+			fmt.Print(unifiedDiff("<stdin>", string(source), formatted))
+		case !*check:
+			fmt.Print(formatted)
+		}
+		if *check && formatted != string(source) {
+			return 1
+		}
+		return 0
+	}
+	status := 0
+	for _, path := range flags.Args() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			status = 1
+			continue
+		}
+		formatted, ok := formatSource(path, string(source), style)
+		if !ok {
+			status = 1
+			continue
+		}
+		if formatted == string(source) {
+			continue
+		}
+		if *check {
+			fmt.Println(path)
+			status = 1
+		}
+		if *diff {
+			fmt.Print(unifiedDiff(path, string(source), formatted))
+		}
+		if !*check && !*diff {
+			if err := writeFile(path, formatted); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
+// formatSource returns the source of a script formatted in the given style, unless it has syntax
+// errors, which are reported instead.
+func formatSource(filename string, source string, style format.Style) (string, bool) {
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(source))))
+	program, errs := p.ParseProgram()
+	if len(errs) > 0 {
+		reporter := diagnostics.NewReporter(diagnostics.Text, os.Stderr, filename)
+		for _, err := range errs {
+			reporter.Report(diagnostics.FromError(err), source)
+		}
+		reporter.Flush()
+		return "", false
+	}
+	f := format.NewFormatter()
+	f.SetStyle(style)
+	return f.FormatProgram(program), true
+}
+
+// writeFile replaces the content of a file, keeping its permissions.
+func writeFile(path string, content string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), info.Mode().Perm())
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lox/lsp"
+	"os"
+)
+
+// languageServerCommand serves the Language Server Protocol over stdio, and returns the exit
+// status once the client is done.
+func languageServerCommand(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: lox lsp")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 64
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 64
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
@@ -2,27 +2,61 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
+	"lox/builtin"
+	"lox/diagnostics"
 	"lox/runner"
 	"os"
 )
 
 func main() {
-	if len(os.Args) > 2 {
-		fmt.Println("Usage: lox [path/to/script.lox]")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(formatCommand(os.Args[2:]))
+		case "debug":
+			os.Exit(debugCommand(os.Args[2:]))
+		case "lsp":
+			os.Exit(languageServerCommand(os.Args[2:]))
+		case "dap":
+			os.Exit(debugAdapterCommand(os.Args[2:]))
+		}
+	}
+	var backend runner.Backend
+	var format diagnostics.Format
+	flag.Var(&backend, "backend", "execution backend, either 'tree' or 'vm'")
+	flag.Var(&format, "diagnostics", "how errors are reported, either 'text', 'json' or 'sarif'")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: lox [--backend=tree|vm] [--diagnostics=text|json|sarif] [path/to/script.lox]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       lox fmt [--check] [--diff] [path/to/script.lox ...]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       lox debug path/to/script.lox")
+		fmt.Fprintln(flag.CommandLine.Output(), "       lox lsp")
+		fmt.Fprintln(flag.CommandLine.Output(), "       lox dap")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
 		os.Exit(64)
 	}
-	in := os.Stdin
+	streams := builtin.StandardStreams()
+	// The REPL reads statements from stdin, so it shares its reader with the script.
+	in := streams.Stdin
 	var exec runner.Mode = &runner.Repl{}
-	if len(os.Args) == 2 {
-		file, err := os.Open(os.Args[1])
+	options := runner.Options{Backend: backend, Streams: streams, Diagnostics: format}
+	if flag.NArg() == 1 {
+		file, err := os.Open(flag.Arg(0))
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
 		defer file.Close()
-		in = file
+		in = bufio.NewReader(file)
 		exec = &runner.Script{}
+		options.Filename = flag.Arg(0)
+	}
+	if !runner.Run(in, exec, options) && flag.NArg() == 1 {
+		os.Exit(1)
 	}
-	runner.Run(bufio.NewReader(in), exec)
 }
@@ -2,8 +2,8 @@ package parser
 
 import (
 	"fmt"
-	"lox"
 	"lox/ast"
+	"lox/diagnostics"
 	"lox/scanner"
 	"lox/token"
 )
@@ -11,6 +11,12 @@ import (
 type SyntaxError struct {
 	line    int
 	message string
+	span    token.Span
+}
+
+// syntaxError returns an error about the given token.
+func syntaxError(tok token.Token, message string) *SyntaxError {
+	return &SyntaxError{line: tok.Line, message: message, span: tok.Span}
 }
 
 func (e SyntaxError) Error() string {
@@ -21,9 +27,26 @@ func (e SyntaxError) Line() int {
 	return e.line
 }
 
+func (e SyntaxError) Column() int {
+	return e.span.Start.Column
+}
+
+func (e SyntaxError) Code() string {
+	return diagnostics.CodeSyntax
+}
+
+func (e SyntaxError) Diagnostic() diagnostics.Diagnostic {
+	return diagnostics.Diagnostic{Kind: "syntax", Code: e.Code(), Message: e.message, Span: e.span}
+}
+
+// Parser reads statements from the tokens of a scanner. Tokens are read ahead into tokens, and
+// last is the token most recently consumed, where the node being parsed ends so far. The
+// comments of the tokens consumed are gathered in comments.
 type Parser struct {
-	scanner *scanner.Scanner
-	tokens  []token.Token
+	scanner  *scanner.Scanner
+	tokens   []token.Token
+	last     token.Token
+	comments []token.Comment
 }
 
 func NewParser(scanner *scanner.Scanner) *Parser {
@@ -31,12 +54,21 @@ func NewParser(scanner *scanner.Scanner) *Parser {
 }
 
 func (p *Parser) NextStatement() (stmt ast.Stmt, err error) {
+	p.comments = nil
+	return p.next()
+}
+
+func (p *Parser) next() (stmt ast.Stmt, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			if se, ok := e.(lox.Error); ok {
+			switch e := e.(type) {
+			case *SyntaxError:
 				p.sync()
-				err = se
-			} else {
+				err = e
+			case *scanner.LexicalError:
+				p.sync()
+				err = e
+			default:
 				panic(fmt.Errorf("unexpected error during parsing: %v", e))
 			}
 		}
@@ -44,11 +76,62 @@ func (p *Parser) NextStatement() (stmt ast.Stmt, err error) {
 	return p.statement(), nil
 }
 
+// ParseProgram parses every statement left, up to the end of the input. Parsing goes on after
+// an error, so that all of them are returned; the program then only has the statements that
+// could be parsed. Use NextStatement instead to parse input as it comes, like in a REPL.
+func (p *Parser) ParseProgram() (*ast.Program, []error) {
+	p.comments = nil
+	program := &ast.Program{}
+	var errs []error
+	for {
+		stmt, err := p.next()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := stmt.(*ast.EndStmt); ok {
+			program.Span.End = stmt.End()
+			program.Comments = p.comments
+			if len(program.Statements) == 0 {
+				program.Span.Start = stmt.Start()
+			}
+			return program, errs
+		}
+		if len(program.Statements) == 0 {
+			program.Span.Start = stmt.Start()
+		}
+		program.Statements = append(program.Statements, stmt)
+	}
+}
+
+// ParseExpression parses an expression making up the whole input, like those typed in a
+// debugger.
+func (p *Parser) ParseExpression() (expr ast.Expr, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch e := e.(type) {
+			case *SyntaxError:
+				err = e
+			case *scanner.LexicalError:
+				err = e
+			default:
+				panic(fmt.Errorf("unexpected error during parsing: %v", e))
+			}
+		}
+	}()
+	expr = p.expression()
+	p.expect(token.EOF, "expected end of expression")
+	return expr, nil
+}
+
 func (p *Parser) statement() ast.Stmt {
 	if p.oneOf(token.EOF) {
 		return p.endStatement()
 	}
-	if p.oneOf(token.FUN) {
+	if p.oneOf(token.CLASS) {
+		return p.classStatement()
+	}
+	if p.oneOf(token.FUN) && p.readTokenAhead(1).Type == token.IDENTIFIER {
 		return p.functionStatement()
 	}
 	if p.oneOf(token.VAR) {
@@ -72,21 +155,70 @@ func (p *Parser) statement() ast.Stmt {
 	if p.oneOf(token.WHILE) {
 		return p.whileStatement()
 	}
+	if p.oneOf(token.BREAK) {
+		keyword := p.pop()
+		p.expect(token.SEMICOLON, "expected ';' after 'break'")
+		return &ast.BreakStmt{Node: p.node(keyword.Span.Start), Keyword: keyword}
+	}
+	if p.oneOf(token.CONTINUE) {
+		keyword := p.pop()
+		p.expect(token.SEMICOLON, "expected ';' after 'continue'")
+		return &ast.ContinueStmt{Node: p.node(keyword.Span.Start), Keyword: keyword}
+	}
 	if p.oneOf(token.IF) {
 		return p.ifStatement()
 	}
+	if p.oneOf(token.THROW) {
+		keyword := p.pop()
+		value := p.expression()
+		p.expect(token.SEMICOLON, "expected ';' after thrown value")
+		return &ast.ThrowStmt{Node: p.node(keyword.Span.Start), Keyword: keyword, Value: value}
+	}
+	if p.oneOf(token.TRY) {
+		return p.tryStatement()
+	}
 	return p.expressionStatement()
 }
 
 func (p *Parser) endStatement() ast.Stmt {
-	p.pop()
-	return &ast.EndStmt{}
+	end := p.pop()
+	return &ast.EndStmt{Node: p.node(end.Span.Start)}
+}
+
+func (p *Parser) classStatement() ast.Stmt {
+	keyword := p.pop()
+	name := p.expect(token.IDENTIFIER, "expected identifier after 'class'")
+	var superclass *ast.VarExpr
+	if p.oneOf(token.LESS) {
+		p.pop()
+		superclassName := p.expect(token.IDENTIFIER, "expected superclass name")
+		superclass = &ast.VarExpr{Node: p.node(superclassName.Span.Start), Name: superclassName}
+	}
+	p.expect(token.LEFT_BRACE, "expected '{' before class body")
+	methods := []*ast.FunDeclStmt{}
+	for !p.oneOf(token.RIGHT_BRACE, token.EOF) {
+		name := p.expect(token.IDENTIFIER, "expected method name")
+		methods = append(methods, p.function(name.Span.Start, name))
+	}
+	p.expect(token.RIGHT_BRACE, "expected '}' after class body")
+	return &ast.ClassStmt{Node: p.node(keyword.Span.Start), Name: name, Superclass: superclass, Methods: methods}
 }
 
 func (p *Parser) functionStatement() ast.Stmt {
-	p.pop()
-	name := p.expect(token.IDENTIFIER, "expected identifier after 'fun'")
+	keyword := p.pop()
+	return p.function(keyword.Span.Start, p.expect(token.IDENTIFIER, "expected identifier after 'fun'"))
+}
+
+// function parses a function declaration or a method starting at the given position, from
+// the opening parenthesis following its name.
+func (p *Parser) function(start token.Position, name token.Token) *ast.FunDeclStmt {
 	p.expect(token.LEFT_PAREN, "expected '(' after function name")
+	parameters, body := p.functionBody()
+	return &ast.FunDeclStmt{Node: p.node(start), Name: name, Params: parameters, Body: body}
+}
+
+// functionBody parses what follows the opening parenthesis of a function: its parameters and body.
+func (p *Parser) functionBody() ([]token.Token, *ast.BlockStmt) {
 	parameters := []token.Token{}
 	if !p.oneOf(token.RIGHT_PAREN) {
 		parameters = append(parameters, p.expect(token.IDENTIFIER, "expected parameter name"))
@@ -97,14 +229,14 @@ func (p *Parser) functionStatement() ast.Stmt {
 	}
 	p.expect(token.RIGHT_PAREN, "expected ')' after parameters")
 	if p.readToken().Type != token.LEFT_BRACE {
-		panic(&SyntaxError{p.tokens[0].Line, "expected '{' after function declaration"})
+		panic(syntaxError(p.tokens[0], "expected '{' after function declaration"))
 	}
 	body := p.blockStatement().(*ast.BlockStmt)
-	return &ast.FunDeclStmt{Name: name, Params: parameters, Body: body}
+	return parameters, body
 }
 
 func (p *Parser) varDeclStatement() ast.Stmt {
-	p.pop()
+	keyword := p.pop()
 	p.readToken()
 	name := p.expect(token.IDENTIFIER, "expected identifier after 'var'")
 	var initializer ast.Expr
@@ -113,35 +245,35 @@ func (p *Parser) varDeclStatement() ast.Stmt {
 		initializer = p.expression()
 	}
 	p.expect(token.SEMICOLON, "expected ';' after variable declaration")
-	return &ast.VarDeclStmt{Name: name, Initializer: &initializer}
+	return &ast.VarDeclStmt{Node: p.node(keyword.Span.Start), Name: name, Initializer: &initializer}
 }
 
 func (p *Parser) assertStatement() ast.Stmt {
-	p.pop()
+	keyword := p.pop()
 	expr := p.expression()
 	p.expect(token.SEMICOLON, "expected ';' after expression")
-	return &ast.AssertStmt{Expression: expr}
+	return &ast.AssertStmt{Node: p.node(keyword.Span.Start), Keyword: keyword, Expression: expr}
 }
 
 func (p *Parser) printStatement() ast.Stmt {
-	p.pop()
+	keyword := p.pop()
 	expr := p.expression()
 	p.expect(token.SEMICOLON, "expected ';' after expression")
-	return &ast.PrintStmt{Expression: expr}
+	return &ast.PrintStmt{Node: p.node(keyword.Span.Start), Expression: expr}
 }
 
 func (p *Parser) blockStatement() ast.Stmt {
-	p.pop()
+	brace := p.pop()
 	statements := []ast.Stmt{}
-	for !p.oneOf(token.RIGHT_BRACE) {
+	for !p.oneOf(token.RIGHT_BRACE, token.EOF) {
 		statements = append(statements, p.statement())
 	}
 	p.expect(token.RIGHT_BRACE, "expected '}' after block")
-	return &ast.BlockStmt{Statements: statements}
+	return &ast.BlockStmt{Node: p.node(brace.Span.Start), Statements: statements}
 }
 
 func (p *Parser) ifStatement() ast.Stmt {
-	p.pop()
+	keyword := p.pop()
 	p.expect(token.LEFT_PAREN, "expected '(' after 'if'")
 	condition := p.expression()
 	p.expect(token.RIGHT_PAREN, "expected ')' after if condition")
@@ -152,9 +284,39 @@ func (p *Parser) ifStatement() ast.Stmt {
 		elseBranch := p.statement()
 		ifStmt.ElseBranch = &elseBranch
 	}
+	ifStmt.Node = p.node(keyword.Span.Start)
 	return ifStmt
 }
 
+func (p *Parser) tryStatement() ast.Stmt {
+	tryStmt := &ast.TryStmt{Keyword: p.pop()}
+	tryStmt.Body = p.clause("expected '{' after 'try'")
+	if p.oneOf(token.CATCH) {
+		p.pop()
+		p.expect(token.LEFT_PAREN, "expected '(' after 'catch'")
+		tryStmt.CatchName = p.expect(token.IDENTIFIER, "expected variable name after '('")
+		p.expect(token.RIGHT_PAREN, "expected ')' after catch variable")
+		tryStmt.Catch = p.clause("expected '{' after catch variable")
+	}
+	if p.oneOf(token.FINALLY) {
+		p.pop()
+		tryStmt.Finally = p.clause("expected '{' after 'finally'")
+	}
+	if tryStmt.Catch == nil && tryStmt.Finally == nil {
+		panic(syntaxError(p.tokens[0], "expected 'catch' or 'finally' after try block"))
+	}
+	tryStmt.Node = p.node(tryStmt.Keyword.Span.Start)
+	return tryStmt
+}
+
+// clause parses one of the blocks of a try statement, which unlike other bodies must be braced.
+func (p *Parser) clause(msg string) *ast.BlockStmt {
+	if p.readToken().Type != token.LEFT_BRACE {
+		panic(syntaxError(p.tokens[0], msg))
+	}
+	return p.blockStatement().(*ast.BlockStmt)
+}
+
 func (p *Parser) returnStatement() ast.Stmt {
 	tk := p.pop()
 	var value ast.Expr
@@ -162,11 +324,11 @@ func (p *Parser) returnStatement() ast.Stmt {
 		value = p.expression()
 	}
 	p.expect(token.SEMICOLON, "expected ';' after return value")
-	return &ast.ReturnStmt{Keyword: tk, Value: &value}
+	return &ast.ReturnStmt{Node: p.node(tk.Span.Start), Keyword: tk, Value: &value}
 }
 
 func (p *Parser) forStatement() ast.Stmt {
-	p.pop()
+	keyword := p.pop()
 	p.expect(token.LEFT_PAREN, "expected '(' after 'for'")
 	var initializer ast.Stmt
 	if p.oneOf(token.SEMICOLON) {
@@ -187,32 +349,22 @@ func (p *Parser) forStatement() ast.Stmt {
 	}
 	p.expect(token.RIGHT_PAREN, "expected ')' after for clauses")
 	body := p.statement()
-	if increment != nil {
-		body = &ast.BlockStmt{Statements: []ast.Stmt{body, &ast.ExprStmt{Expression: increment}}}
-	}
-	if condition == nil {
-		condition = &ast.LiteralExpr{Value: true}
-	}
-	body = &ast.WhileStmt{Condition: condition, Body: body}
-	if initializer != nil {
-		body = &ast.BlockStmt{Statements: []ast.Stmt{initializer, body}}
-	}
-	return body
+	return &ast.ForStmt{Node: p.node(keyword.Span.Start), Keyword: keyword, Initializer: initializer, Condition: condition, Increment: increment, Body: body}
 }
 
 func (p *Parser) whileStatement() ast.Stmt {
-	p.pop()
+	keyword := p.pop()
 	p.expect(token.LEFT_PAREN, "expected '(' after 'while'")
 	condition := p.expression()
 	p.expect(token.RIGHT_PAREN, "expected ')' after while condition")
 	body := p.statement()
-	return &ast.WhileStmt{Condition: condition, Body: body}
+	return &ast.WhileStmt{Node: p.node(keyword.Span.Start), Condition: condition, Body: body}
 }
 
 func (p *Parser) expressionStatement() ast.Stmt {
 	expr := p.expression()
 	p.expect(token.SEMICOLON, "expected ';' after expression")
-	return &ast.ExprStmt{Expression: expr}
+	return &ast.ExprStmt{Node: p.node(expr.Start()), Expression: expr}
 }
 
 func (p *Parser) expression() ast.Expr {
@@ -227,10 +379,16 @@ func (p *Parser) assignment() ast.Expr {
 		value := p.assignment()
 
 		if varExpr, ok := expr.(*ast.VarExpr); ok {
-			return &ast.AssignmentExpr{Name: varExpr.Name, Value: value}
+			return &ast.AssignmentExpr{Node: p.node(expr.Start()), Name: varExpr.Name, Value: value}
+		}
+		if getExpr, ok := expr.(*ast.GetExpr); ok {
+			return &ast.SetExpr{Node: p.node(expr.Start()), Object: getExpr.Object, Name: getExpr.Name, Value: value}
+		}
+		if indexExpr, ok := expr.(*ast.IndexExpr); ok {
+			return &ast.SetIndexExpr{Node: p.node(expr.Start()), Object: indexExpr.Object, Bracket: indexExpr.Bracket, Index: indexExpr.Index, Value: value}
 		}
 
-		panic(&SyntaxError{equals.Line, "invalid assignment target"})
+		panic(syntaxError(equals, "invalid assignment target"))
 	}
 
 	return expr
@@ -242,7 +400,7 @@ func (p *Parser) or() ast.Expr {
 	for p.oneOf(token.OR) {
 		operator := p.pop()
 		right := p.and()
-		left = &ast.LogicalExpr{Left: left, Operator: operator, Right: right}
+		left = &ast.LogicalExpr{Node: p.node(left.Start()), Left: left, Operator: operator, Right: right}
 	}
 
 	return left
@@ -254,7 +412,7 @@ func (p *Parser) and() ast.Expr {
 	for p.oneOf(token.AND) {
 		operator := p.pop()
 		right := p.equality()
-		left = &ast.LogicalExpr{Left: left, Operator: operator, Right: right}
+		left = &ast.LogicalExpr{Node: p.node(left.Start()), Left: left, Operator: operator, Right: right}
 	}
 
 	return left
@@ -266,7 +424,7 @@ func (p *Parser) equality() ast.Expr {
 	for p.oneOf(token.BANG_EQUAL, token.EQUAL_EQUAL) {
 		operator := p.pop()
 		right := p.comparison()
-		left = &ast.BinaryExpr{Left: left, Operator: operator, Right: right}
+		left = &ast.BinaryExpr{Node: p.node(left.Start()), Left: left, Operator: operator, Right: right}
 	}
 
 	return left
@@ -278,7 +436,7 @@ func (p *Parser) comparison() ast.Expr {
 	for p.oneOf(token.GREATER, token.GREATER_EQUAL, token.LESS, token.LESS_EQUAL) {
 		operator := p.pop()
 		right := p.term()
-		left = &ast.BinaryExpr{Left: left, Operator: operator, Right: right}
+		left = &ast.BinaryExpr{Node: p.node(left.Start()), Left: left, Operator: operator, Right: right}
 	}
 
 	return left
@@ -290,7 +448,7 @@ func (p *Parser) term() ast.Expr {
 	for p.oneOf(token.MINUS, token.PLUS) {
 		operator := p.pop()
 		right := p.factor()
-		left = &ast.BinaryExpr{Left: left, Operator: operator, Right: right}
+		left = &ast.BinaryExpr{Node: p.node(left.Start()), Left: left, Operator: operator, Right: right}
 	}
 
 	return left
@@ -302,7 +460,7 @@ func (p *Parser) factor() ast.Expr {
 	for p.oneOf(token.SLASH, token.STAR) {
 		operator := p.pop()
 		right := p.unary()
-		left = &ast.BinaryExpr{Left: left, Operator: operator, Right: right}
+		left = &ast.BinaryExpr{Node: p.node(left.Start()), Left: left, Operator: operator, Right: right}
 	}
 
 	return left
@@ -312,7 +470,7 @@ func (p *Parser) unary() ast.Expr {
 	if p.oneOf(token.BANG, token.MINUS) {
 		operator := p.pop()
 		right := p.unary()
-		return &ast.UnaryExpr{Operator: operator, Right: right}
+		return &ast.UnaryExpr{Node: p.node(operator.Span.Start), Operator: operator, Right: right}
 	}
 
 	return p.call()
@@ -320,55 +478,120 @@ func (p *Parser) unary() ast.Expr {
 
 func (p *Parser) call() ast.Expr {
 	expr := p.primary()
-	for p.oneOf(token.LEFT_PAREN) {
-		p.pop()
-		arguments := []ast.Expr{}
-		if !p.oneOf(token.RIGHT_PAREN) {
-			arguments = append(arguments, p.expression())
-		}
-		for p.oneOf(token.COMMA) {
+	for {
+		if p.oneOf(token.LEFT_PAREN) {
+			expr = p.finishCall(expr)
+		} else if p.oneOf(token.DOT) {
 			p.pop()
-			arguments = append(arguments, p.expression())
-			if len(arguments) >= 255 {
-				panic(&SyntaxError{p.tokens[0].Line, "cannot have more than 255 arguments"})
-			}
+			name := p.expect(token.IDENTIFIER, "expected property name after '.'")
+			expr = &ast.GetExpr{Node: p.node(expr.Start()), Object: expr, Name: name}
+		} else if p.oneOf(token.LEFT_BRACKET) {
+			bracket := p.pop()
+			index := p.expression()
+			p.expect(token.RIGHT_BRACKET, "expected ']' after index")
+			expr = &ast.IndexExpr{Node: p.node(expr.Start()), Object: expr, Bracket: bracket, Index: index}
+		} else {
+			return expr
 		}
-		p.expect(token.RIGHT_PAREN, "expected ')' after arguments")
-		expr = &ast.CallExpr{Callee: expr, Arguments: arguments}
 	}
-	return expr
+}
+
+func (p *Parser) finishCall(callee ast.Expr) ast.Expr {
+	p.pop()
+	arguments := []ast.Expr{}
+	if !p.oneOf(token.RIGHT_PAREN) {
+		arguments = append(arguments, p.expression())
+	}
+	for p.oneOf(token.COMMA) {
+		p.pop()
+		arguments = append(arguments, p.expression())
+		if len(arguments) >= 255 {
+			panic(syntaxError(p.tokens[0], "cannot have more than 255 arguments"))
+		}
+	}
+	paren := p.expect(token.RIGHT_PAREN, "expected ')' after arguments")
+	return &ast.CallExpr{Node: p.node(callee.Start()), Callee: callee, Paren: paren, Arguments: arguments}
 }
 
 func (p *Parser) primary() ast.Expr {
 	if p.oneOf(token.IDENTIFIER) {
 		name := p.pop()
-		return &ast.VarExpr{Name: name}
+		return &ast.VarExpr{Node: p.node(name.Span.Start), Name: name}
+	}
+	if p.oneOf(token.FUN) {
+		keyword := p.pop()
+		p.expect(token.LEFT_PAREN, "expected '(' after 'fun'")
+		parameters, body := p.functionBody()
+		return &ast.FunctionExpr{Node: p.node(keyword.Span.Start), Keyword: keyword, Params: parameters, Body: body}
+	}
+	if p.oneOf(token.SUPER) {
+		keyword := p.pop()
+		p.expect(token.DOT, "expected '.' after 'super'")
+		method := p.expect(token.IDENTIFIER, "expected superclass method name")
+		return &ast.SuperExpr{Node: p.node(keyword.Span.Start), Keyword: keyword, Method: method}
+	}
+	if p.oneOf(token.THIS) {
+		keyword := p.pop()
+		return &ast.ThisExpr{Node: p.node(keyword.Span.Start), Keyword: keyword}
 	}
 	if p.oneOf(token.FALSE) {
-		p.pop()
-		return &ast.LiteralExpr{Value: false}
+		literal := p.pop()
+		return &ast.LiteralExpr{Node: p.node(literal.Span.Start), Value: false}
 	}
 	if p.oneOf(token.TRUE) {
-		p.pop()
-		return &ast.LiteralExpr{Value: true}
+		literal := p.pop()
+		return &ast.LiteralExpr{Node: p.node(literal.Span.Start), Value: true}
 	}
 	if p.oneOf(token.NIL) {
-		p.pop()
-		return &ast.LiteralExpr{Value: nil}
+		literal := p.pop()
+		return &ast.LiteralExpr{Node: p.node(literal.Span.Start), Value: nil}
 	}
 	if p.oneOf(token.NUMBER, token.STRING) {
-		token := p.pop()
-		return &ast.LiteralExpr{Value: token.Literal}
+		literal := p.pop()
+		return &ast.LiteralExpr{Node: p.node(literal.Span.Start), Value: literal.Literal}
+	}
+
+	if p.oneOf(token.LEFT_BRACKET) {
+		bracket := p.pop()
+		elements := []ast.Expr{}
+		if !p.oneOf(token.RIGHT_BRACKET) {
+			elements = append(elements, p.expression())
+			for p.oneOf(token.COMMA) {
+				p.pop()
+				elements = append(elements, p.expression())
+			}
+		}
+		p.expect(token.RIGHT_BRACKET, "expected ']' after list elements")
+		return &ast.ListExpr{Node: p.node(bracket.Span.Start), Bracket: bracket, Elements: elements}
+	}
+
+	// Statements starting with a brace are blocks, so this is only reached in expression position.
+	if p.oneOf(token.LEFT_BRACE) {
+		brace := p.pop()
+		keys, values := []ast.Expr{}, []ast.Expr{}
+		if !p.oneOf(token.RIGHT_BRACE) {
+			for {
+				keys = append(keys, p.expression())
+				p.expect(token.COLON, "expected ':' after map key")
+				values = append(values, p.expression())
+				if !p.oneOf(token.COMMA) {
+					break
+				}
+				p.pop()
+			}
+		}
+		p.expect(token.RIGHT_BRACE, "expected '}' after map entries")
+		return &ast.MapExpr{Node: p.node(brace.Span.Start), Brace: brace, Keys: keys, Values: values}
 	}
 
 	if p.oneOf(token.LEFT_PAREN) {
-		p.pop()
+		paren := p.pop()
 		group := p.expression()
 		p.expect(token.RIGHT_PAREN, "expected ')' after expression")
-		return &ast.GroupingExpr{Expression: group}
+		return &ast.GroupingExpr{Node: p.node(paren.Span.Start), Expression: group}
 	}
 
-	panic(&SyntaxError{p.tokens[0].Line, "expected expression"})
+	panic(syntaxError(p.tokens[0], "expected expression"))
 
 }
 
@@ -390,7 +613,7 @@ func (p *Parser) expect(t token.Type, msg string) token.Token {
 		} else {
 			msg = fmt.Sprintf("%s (at '%s')", msg, tok.Lexeme)
 		}
-		panic(&SyntaxError{tok.Line, msg})
+		panic(syntaxError(tok, msg))
 	}
 	p.pop()
 	return tok
@@ -401,14 +624,19 @@ func (p *Parser) check(t token.Type) bool {
 }
 
 func (p *Parser) pop() token.Token {
-	if len(p.tokens) == 0 {
-		return p.readToken()
-	}
+	p.readToken()
 	head, tail := p.tokens[0], p.tokens[1:]
 	p.tokens = tail
+	p.last = head
+	p.comments = append(p.comments, head.Comments...)
 	return head
 }
 
+// node returns the node for what was parsed from the given position up to the last token.
+func (p *Parser) node(start token.Position) ast.Node {
+	return ast.Node{Span: token.Span{Start: start, End: p.last.Span.End}}
+}
+
 func (p *Parser) sync() {
 	defer func() {
 		if e := recover(); e != nil {
@@ -418,9 +646,8 @@ func (p *Parser) sync() {
 			}
 		}
 	}()
-	p.pop()
-	for !syncPoint(p.readToken().Type) {
-		p.pop()
+	// Skip to the end of the statement, or the start of the next one.
+	for p.pop().Type != token.SEMICOLON && !syncPoint(p.readToken().Type) {
 	}
 }
 
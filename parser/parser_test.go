@@ -5,6 +5,7 @@ import (
 	"lox/ast"
 	"lox/format"
 	"lox/scanner"
+	"lox/token"
 	"regexp"
 	"strings"
 	"testing"
@@ -78,6 +79,10 @@ func TestParserMultiErr(t *testing.T) {
 	expectErrors(t, "1 = 2; print;", "invalid assignment target", "expected expression")
 }
 
+func TestParserSyncAfterSemicolon(t *testing.T) {
+	expectErrors(t, "a = ;\nb = ;\n", "expected expression", "expected expression")
+}
+
 func TestParserAssert(t *testing.T) {
 	expectFormatted(t, "assert true;")
 }
@@ -90,6 +95,15 @@ func TestParserIf(t *testing.T) {
 	expectFormatted(t, "if (true)\n{\n\tprint 1;\n}")
 }
 
+func TestParserDeclarationAsBranch(t *testing.T) {
+	expectFormatted(t, "if (true)\n{\n\tvar x = 1;\n}")
+	expectFormatted(t, "while (true)\n{\n\tfun f()\n\t{\n\t}\n}")
+}
+
+func TestParserUnclosedBlock(t *testing.T) {
+	expectErrors(t, "{ print 1;", "expected '}' after block \\(at end\\)")
+}
+
 func TestParserCall(t *testing.T) {
 	expectFormatted(t, "foo(1);")
 	expectFormatted(t, "curried(1)(2);")
@@ -99,6 +113,59 @@ func TestParserFunDecl(t *testing.T) {
 	expectFormatted(t, "fun foo()\n{\n\tprint \"a\";\n\tprint clock();\n}")
 }
 
+func TestParserClass(t *testing.T) {
+	expectFormatted(t, "class Foo\n{\n\tinit(x)\n\t{\n\t\tthis.x = x;\n\t}\n\tbar()\n\t{\n\t\treturn this.x;\n\t}\n}")
+}
+
+func TestParserSubclass(t *testing.T) {
+	expectFormatted(t, "class Bar < Foo\n{\n\tbaz()\n\t{\n\t\treturn super.baz();\n\t}\n}")
+	expectErrors(t, "class Bar < {}", "expected superclass name")
+	expectErrors(t, "super;", "expected '.' after 'super'")
+}
+
+func TestParserGetSet(t *testing.T) {
+	expectFormatted(t, "a.b.c = d.e(1).f;")
+}
+
+func TestParserInvalidPropertyName(t *testing.T) {
+	expectErrors(t, "a.1;", "expected property name after '.'")
+}
+
+func TestParserLambda(t *testing.T) {
+	expectFormatted(t, "var f = fun (a, b)\n{\n\treturn a + b;\n};")
+	expectFormatted(t, "apply(fun ()\n{\n\tprint 1;\n});")
+	expectErrors(t, "var f = fun a() {};", "expected '\\(' after 'fun'")
+}
+
+func TestParserList(t *testing.T) {
+	expectFormatted(t, "var xs = [1, \"a\", [], [nil]];")
+	expectFormatted(t, "xs[0][i + 1] = ys[f(2)];")
+	expectErrors(t, "xs[0;", "expected '\\]' after index")
+	expectErrors(t, "[1, 2;", "expected '\\]' after list elements")
+}
+
+func TestParserMap(t *testing.T) {
+	expectFormatted(t, "var m = {\"a\": 1, 2: [], nil: {}};")
+	expectFormatted(t, "m[\"a\"] = ({}).x;")
+	expectErrors(t, "var m = {\"a\" 1};", "expected ':' after map key")
+	expectErrors(t, "var m = {1: 2;", "expected '}' after map entries")
+}
+
+func TestParserFor(t *testing.T) {
+	expectFormatted(t, "for (var i = 0; i < 10; i = i + 1)\n{\n\tprint i;\n}")
+	expectFormatted(t, "for (i = 0; i < 10;)\n{\n\tcontinue;\n}")
+	expectFormatted(t, "for (;;)\n{\n\tbreak;\n}")
+	expectErrors(t, "while (true) break", "expected ';' after 'break'")
+}
+
+func TestParserTry(t *testing.T) {
+	expectFormatted(t, "try\n{\n\tthrow \"a\";\n}\ncatch (e)\n{\n\tprint e;\n}\nfinally\n{\n\tprint 1;\n}")
+	expectFormatted(t, "try\n{\n\tf();\n}\nfinally\n{\n\tg();\n}")
+	expectErrors(t, "try {}", "expected 'catch' or 'finally' after try block")
+	expectErrors(t, "try print 1;", "expected '{' after 'try'")
+	expectErrors(t, "try {} catch e {}", "expected '\\(' after 'catch'")
+}
+
 func expectErrors(t *testing.T, src string, regexps ...string) {
 	t.Helper()
 	p := NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
@@ -121,19 +188,70 @@ func expectErrors(t *testing.T, src string, regexps ...string) {
 	}
 }
 
+func TestParserProgram(t *testing.T) {
+	p := NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader("\n var a = 1;\nprint a +;\n# a;\nprint a;\n"))))
+	program, errs := p.ParseProgram()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(program.Statements))
+	}
+	if _, ok := program.Statements[1].(*ast.PrintStmt); !ok {
+		t.Errorf("expected a print statement, got %T", program.Statements[1])
+	}
+	expectNodeSpan(t, program, "2:2", "6:1")
+	program, errs = p.ParseProgram()
+	if len(errs) != 0 || len(program.Statements) != 0 {
+		t.Errorf("expected an empty program, got %v and %v", program.Statements, errs)
+	}
+}
+
+func TestParserSpans(t *testing.T) {
+	p := NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader("print 1 +\n  (2 * x);\nfoo.bar(1)[2] = 3;"))))
+	print := expectStatement(t, p).(*ast.PrintStmt)
+	expectNodeSpan(t, print, "1:1", "2:11")
+	binary := print.Expression.(*ast.BinaryExpr)
+	expectNodeSpan(t, binary, "1:7", "2:10")
+	expectNodeSpan(t, binary.Right, "2:3", "2:10")
+	expectNodeSpan(t, binary.Right.(*ast.GroupingExpr).Expression, "2:4", "2:9")
+	set := expectStatement(t, p).(*ast.ExprStmt)
+	expectNodeSpan(t, set, "3:1", "3:19")
+	expectNodeSpan(t, set.Expression, "3:1", "3:18")
+	expectNodeSpan(t, set.Expression.(*ast.SetIndexExpr).Object, "3:1", "3:11")
+	expectNodeSpan(t, expectStatement(t, p), "3:19", "3:19")
+}
+
+func expectStatement(t *testing.T, p *Parser) ast.Stmt {
+	t.Helper()
+	stmt, err := p.NextStatement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stmt
+}
+
+func expectNodeSpan(t *testing.T, node interface {
+	Start() token.Position
+	End() token.Position
+}, start string, end string) {
+	t.Helper()
+	if node.Start().String() != start || node.End().String() != end {
+		t.Errorf("expected %T to span %s-%s, got %v-%v", node, start, end, node.Start(), node.End())
+	}
+}
+
 func expectFormatted(t *testing.T, src string) {
 	t.Helper()
 	p := NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
 	f := format.NewFormatter()
 	builder := strings.Builder{}
-	for {
-		if stmt, err := p.NextStatement(); err != nil {
-			t.Error(err)
-		} else if _, ok := stmt.(*ast.EndStmt); !ok {
-			builder.WriteString(f.Format(stmt))
-		} else {
-			break
-		}
+	program, errs := p.ParseProgram()
+	for _, err := range errs {
+		t.Error(err)
+	}
+	for _, stmt := range program.Statements {
+		builder.WriteString(f.Format(stmt))
 	}
 	result := builder.String()
 	if result != src {
@@ -3,40 +3,155 @@ package resolver
 import (
 	"fmt"
 	"lox/ast"
-	"lox/interpreter"
+	"lox/diagnostics"
 	"lox/token"
+	"sort"
+)
+
+// Interpreter is notified of every reference to a local variable the resolver finds, along
+// with the number of scopes between the reference and the variable, and the slot of the
+// variable within its scope. Slots are numbered from zero in order of declaration.
+type Interpreter interface {
+	Resolve(expr ast.Expr, depth int, slot int)
+}
+
+// Listener is told about the variables the resolver comes across, for tools that need to know
+// where they are declared and used. Uses of global variables have no declaration, as globals
+// are only looked up by name when running. The implicit this and super are left out.
+type Listener interface {
+	Declare(name token.Token, global bool)
+	Use(name token.Token, declaration *token.Token)
+}
+
+// variable is a local variable, declared by name unless it is this or super.
+type variable struct {
+	defined bool
+	slot    int
+	name    token.Token
+}
+
+type functionType int
+
+const (
+	noFunction functionType = iota
+	function
+	method
+	initializer
+)
+
+type classType int
+
+const (
+	noClass classType = iota
+	class
+	subclass
 )
 
 type Resolver struct {
-	interpreter *interpreter.Interpreter
-	scopes      []map[string]bool
+	interpreter     Interpreter
+	listener        Listener
+	scopes          []map[string]*variable
+	currentFunction functionType
+	currentClass    classType
+	loopDepth       int
 }
 
-func NewResolver(interpreter *interpreter.Interpreter) *Resolver {
+func NewResolver(interpreter Interpreter) *Resolver {
 	return &Resolver{interpreter: interpreter}
 }
 
+func (r *Resolver) SetListener(listener Listener) {
+	r.listener = listener
+}
+
 type ResolutionError struct {
 	line    int
 	message string
+	span    token.Span
+}
+
+// resolutionError returns an error about the given token.
+func resolutionError(tok token.Token, message string) *ResolutionError {
+	return &ResolutionError{line: tok.Line, message: message, span: tok.Span}
 }
 
 func (e ResolutionError) Error() string {
 	return fmt.Sprintf("resolution error on line %d: %s", e.line, e.message)
 }
 
+func (e ResolutionError) Line() int {
+	return e.line
+}
+
+func (e ResolutionError) Column() int {
+	return e.span.Start.Column
+}
+
+func (e ResolutionError) Code() string {
+	return diagnostics.CodeResolution
+}
+
+func (e ResolutionError) Diagnostic() diagnostics.Diagnostic {
+	return diagnostics.Diagnostic{Kind: "resolution", Code: e.Code(), Message: e.message, Span: e.span}
+}
+
 func (r *Resolver) Resolve(stmt ast.Stmt) (err error) {
+	defer r.recoverError(&err)
+	r.resolveStmt(stmt)
+	return err
+}
+
+// ResolveProgram resolves every statement of a program, and returns the errors found while
+// parsing it along with those of resolution, in the order they appear in the source.
+func (r *Resolver) ResolveProgram(program *ast.Program, errs []error) []error {
+	for _, stmt := range program.Statements {
+		if err := r.Resolve(stmt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sort.SliceStable(errs, func(a, b int) bool {
+		return diagnostics.FromError(errs[a]).Span.Start.Offset < diagnostics.FromError(errs[b]).Span.Start.Offset
+	})
+	return errs
+}
+
+// ResolveExpr resolves an expression as if it appeared where the given scopes are visible,
+// outermost first, each naming its variables in the order of their slots. Debuggers use it to
+// evaluate expressions where a script is paused.
+func (r *Resolver) ResolveExpr(expr ast.Expr, scopes [][]string) (err error) {
+	defer r.recoverError(&err)
 	defer func() {
-		if e := recover(); e != nil {
-			if re, ok := e.(*ResolutionError); ok {
-				err = re
-			} else {
-				panic(fmt.Errorf("unexpected error during resolution: %v", e))
+		r.scopes = nil
+		r.currentClass = noClass
+	}()
+	for _, names := range scopes {
+		scope := make(map[string]*variable)
+		for slot, name := range names {
+			scope[name] = &variable{defined: true, slot: slot}
+			switch name {
+			case "this":
+				r.currentClass = max(r.currentClass, class)
+			case "super":
+				r.currentClass = subclass
 			}
 		}
-	}()
-	r.resolveStmt(stmt)
-	return err
+		r.scopes = append(r.scopes, scope)
+	}
+	r.resolveExpr(expr)
+	return nil
+}
+
+func (r *Resolver) recoverError(err *error) {
+	if e := recover(); e != nil {
+		if re, ok := e.(*ResolutionError); ok {
+			// Start afresh with the next statement.
+			r.scopes = nil
+			r.currentFunction, r.currentClass, r.loopDepth = noFunction, noClass, 0
+			*err = re
+		} else {
+			panic(fmt.Errorf("unexpected error during resolution: %v", e))
+		}
+	}
 }
 
 func (r *Resolver) VisitAssignmentExpr(expr *ast.AssignmentExpr) interface{} {
@@ -59,11 +174,42 @@ func (r *Resolver) VisitCallExpr(expr *ast.CallExpr) interface{} {
 	return nil
 }
 
+func (r *Resolver) VisitFunctionExpr(expr *ast.FunctionExpr) interface{} {
+	r.resolveFunction(expr.Params, expr.Body, function)
+	return nil
+}
+
+func (r *Resolver) VisitGetExpr(expr *ast.GetExpr) interface{} {
+	r.resolveExpr(expr.Object)
+	return nil
+}
+
 func (r *Resolver) VisitGroupingExpr(expr *ast.GroupingExpr) interface{} {
 	r.resolveExpr(expr.Expression)
 	return nil
 }
 
+func (r *Resolver) VisitIndexExpr(expr *ast.IndexExpr) interface{} {
+	r.resolveExpr(expr.Object)
+	r.resolveExpr(expr.Index)
+	return nil
+}
+
+func (r *Resolver) VisitListExpr(expr *ast.ListExpr) interface{} {
+	for _, element := range expr.Elements {
+		r.resolveExpr(element)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitMapExpr(expr *ast.MapExpr) interface{} {
+	for index, key := range expr.Keys {
+		r.resolveExpr(key)
+		r.resolveExpr(expr.Values[index])
+	}
+	return nil
+}
+
 func (r *Resolver) VisitLiteralExpr(expr *ast.LiteralExpr) interface{} {
 	return nil
 }
@@ -74,6 +220,38 @@ func (r *Resolver) VisitLogicalExpr(expr *ast.LogicalExpr) interface{} {
 	return nil
 }
 
+func (r *Resolver) VisitSetExpr(expr *ast.SetExpr) interface{} {
+	r.resolveExpr(expr.Value)
+	r.resolveExpr(expr.Object)
+	return nil
+}
+
+func (r *Resolver) VisitSetIndexExpr(expr *ast.SetIndexExpr) interface{} {
+	r.resolveExpr(expr.Object)
+	r.resolveExpr(expr.Index)
+	r.resolveExpr(expr.Value)
+	return nil
+}
+
+func (r *Resolver) VisitSuperExpr(expr *ast.SuperExpr) interface{} {
+	switch r.currentClass {
+	case noClass:
+		panic(resolutionError(expr.Keyword, "cannot use 'super' outside of a class"))
+	case class:
+		panic(resolutionError(expr.Keyword, "cannot use 'super' in a class with no superclass"))
+	}
+	r.resolveLocal(expr, expr.Keyword)
+	return nil
+}
+
+func (r *Resolver) VisitThisExpr(expr *ast.ThisExpr) interface{} {
+	if r.currentClass == noClass {
+		panic(resolutionError(expr.Keyword, "cannot use 'this' outside of a class"))
+	}
+	r.resolveLocal(expr, expr.Keyword)
+	return nil
+}
+
 func (r *Resolver) VisitUnaryExpr(expr *ast.UnaryExpr) interface{} {
 	r.resolveExpr(expr.Right)
 	return nil
@@ -81,16 +259,24 @@ func (r *Resolver) VisitUnaryExpr(expr *ast.UnaryExpr) interface{} {
 
 func (r *Resolver) resolveLocal(expr ast.Expr, name token.Token) {
 	for i := len(r.scopes) - 1; i >= 0; i-- {
-		if _, ok := r.scopes[i][name.Lexeme]; ok {
-			r.interpreter.Resolve(expr, len(r.scopes)-1-i)
+		if v, ok := r.scopes[i][name.Lexeme]; ok {
+			r.interpreter.Resolve(expr, len(r.scopes)-1-i, v.slot)
+			if r.listener != nil && v.name.Lexeme != "" {
+				r.listener.Use(name, &v.name)
+			}
 			return
 		}
 	}
+	if r.listener != nil {
+		r.listener.Use(name, nil)
+	}
 }
 
 func (r *Resolver) VisitVarExpr(expr *ast.VarExpr) interface{} {
-	if len(r.scopes) > 0 && !r.scopes[len(r.scopes)-1][expr.Name.Lexeme] {
-		panic(&ResolutionError{line: expr.Name.Line, message: "cannot read local variable in its own initializer"})
+	if len(r.scopes) > 0 {
+		if v, ok := r.scopes[len(r.scopes)-1][expr.Name.Lexeme]; ok && !v.defined {
+			panic(resolutionError(expr.Name, "cannot read local variable in its own initializer"))
+		}
 	}
 	r.resolveLocal(expr, expr.Name)
 	return nil
@@ -98,46 +284,88 @@ func (r *Resolver) VisitVarExpr(expr *ast.VarExpr) interface{} {
 
 func (r *Resolver) declare(name token.Token) {
 	if len(r.scopes) == 0 {
+		if r.listener != nil {
+			r.listener.Declare(name, true)
+		}
 		return
 	}
 	if _, ok := r.scopes[len(r.scopes)-1][name.Lexeme]; ok {
-		panic(&ResolutionError{line: name.Line, message: "variable with this name already declared in this scope"})
+		panic(resolutionError(name, "variable with this name already declared in this scope"))
 	}
 	scope := r.scopes[len(r.scopes)-1]
-	scope[name.Lexeme] = false
+	scope[name.Lexeme] = &variable{slot: len(scope), name: name}
+	if r.listener != nil {
+		r.listener.Declare(name, false)
+	}
 }
 
 func (r *Resolver) define(name token.Token) {
 	if len(r.scopes) == 0 {
 		return
 	}
-	scope := r.scopes[len(r.scopes)-1]
-	scope[name.Lexeme] = true
+	r.scopes[len(r.scopes)-1][name.Lexeme].defined = true
 }
 
 func (r *Resolver) VisitVarDeclStmt(stmt *ast.VarDeclStmt) interface{} {
 	r.declare(stmt.Name)
-	if stmt.Initializer != nil {
+	if stmt.Initializer != nil && *stmt.Initializer != nil {
 		r.resolveExpr(*stmt.Initializer)
 	}
 	r.define(stmt.Name)
 	return nil
 }
 
-func (r *Resolver) resolveFunction(stmt *ast.FunDeclStmt) {
+func (r *Resolver) resolveFunction(params []token.Token, body *ast.BlockStmt, kind functionType) {
+	// Loops do not extend into the functions declared in them.
+	enclosingFunction, enclosingLoopDepth := r.currentFunction, r.loopDepth
+	r.currentFunction, r.loopDepth = kind, 0
+	defer func() {
+		r.currentFunction, r.loopDepth = enclosingFunction, enclosingLoopDepth
+	}()
 	r.beginScope()
-	for _, param := range stmt.Params {
+	for _, param := range params {
 		r.declare(param)
 		r.define(param)
 	}
-	r.resolveStmts(stmt.Body.Statements)
+	r.resolveStmts(body.Statements)
 	r.endScope()
 }
 
 func (r *Resolver) VisitFunDeclStmt(stmt *ast.FunDeclStmt) interface{} {
 	r.declare(stmt.Name)
 	r.define(stmt.Name)
-	r.resolveFunction(stmt)
+	r.resolveFunction(stmt.Params, stmt.Body, function)
+	return nil
+}
+
+func (r *Resolver) VisitClassStmt(stmt *ast.ClassStmt) interface{} {
+	enclosingClass := r.currentClass
+	r.currentClass = class
+	defer func() {
+		r.currentClass = enclosingClass
+	}()
+	r.declare(stmt.Name)
+	r.define(stmt.Name)
+	if stmt.Superclass != nil {
+		if stmt.Superclass.Name.Lexeme == stmt.Name.Lexeme {
+			panic(resolutionError(stmt.Superclass.Name, "a class cannot inherit from itself"))
+		}
+		r.currentClass = subclass
+		r.resolveExpr(stmt.Superclass)
+		r.beginScope()
+		r.scopes[len(r.scopes)-1]["super"] = &variable{defined: true}
+		defer r.endScope()
+	}
+	r.beginScope()
+	r.scopes[len(r.scopes)-1]["this"] = &variable{defined: true}
+	for _, m := range stmt.Methods {
+		kind := method
+		if m.Name.Lexeme == "init" {
+			kind = initializer
+		}
+		r.resolveFunction(m.Params, m.Body, kind)
+	}
+	r.endScope()
 	return nil
 }
 
@@ -156,7 +384,7 @@ func (r *Resolver) resolveStmts(stmts []ast.Stmt) {
 }
 
 func (r *Resolver) beginScope() {
-	r.scopes = append(r.scopes, make(map[string]bool))
+	r.scopes = append(r.scopes, make(map[string]*variable))
 }
 
 func (r *Resolver) endScope() {
@@ -177,9 +405,9 @@ func (r *Resolver) VisitExprStmt(stmt *ast.ExprStmt) interface{} {
 
 func (r *Resolver) VisitIfStmt(stmt *ast.IfStmt) interface{} {
 	r.resolveExpr(stmt.Condition)
-	r.resolveStmt(*stmt.ThenBranch)
+	r.resolveBody(*stmt.ThenBranch)
 	if stmt.ElseBranch != nil {
-		r.resolveStmt(*stmt.ElseBranch)
+		r.resolveBody(*stmt.ElseBranch)
 	}
 	return nil
 }
@@ -196,11 +424,89 @@ func (r *Resolver) VisitPrintStmt(stmt *ast.PrintStmt) interface{} {
 
 func (r *Resolver) VisitWhileStmt(stmt *ast.WhileStmt) interface{} {
 	r.resolveExpr(stmt.Condition)
+	r.resolveLoopBody(stmt.Body)
+	return nil
+}
+
+func (r *Resolver) VisitForStmt(stmt *ast.ForStmt) interface{} {
+	r.beginScope()
+	if stmt.Initializer != nil {
+		r.resolveStmt(stmt.Initializer)
+	}
+	if stmt.Condition != nil {
+		r.resolveExpr(stmt.Condition)
+	}
+	if stmt.Increment != nil {
+		r.resolveExpr(stmt.Increment)
+	}
+	r.resolveLoopBody(stmt.Body)
+	r.endScope()
+	return nil
+}
+
+func (r *Resolver) resolveLoopBody(body ast.Stmt) {
+	r.loopDepth++
+	defer func() {
+		r.loopDepth--
+	}()
+	r.resolveBody(body)
+}
+
+// resolveBody resolves a branch or the body of a loop. A declaration there gets a scope of its
+// own, as if it were in a block: it does not always run, so it cannot take a slot in the
+// enclosing scope.
+func (r *Resolver) resolveBody(body ast.Stmt) {
+	if ast.IsDeclaration(body) {
+		r.beginScope()
+		defer r.endScope()
+	}
+	r.resolveStmt(body)
+}
+
+func (r *Resolver) VisitThrowStmt(stmt *ast.ThrowStmt) interface{} {
+	r.resolveExpr(stmt.Value)
+	return nil
+}
+
+func (r *Resolver) VisitTryStmt(stmt *ast.TryStmt) interface{} {
 	r.resolveStmt(stmt.Body)
+	if stmt.Catch != nil {
+		r.beginScope()
+		r.declare(stmt.CatchName)
+		r.define(stmt.CatchName)
+		r.resolveStmt(stmt.Catch)
+		r.endScope()
+	}
+	if stmt.Finally != nil {
+		r.resolveStmt(stmt.Finally)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitBreakStmt(stmt *ast.BreakStmt) interface{} {
+	if r.loopDepth == 0 {
+		panic(resolutionError(stmt.Keyword, "cannot use 'break' outside of a loop"))
+	}
+	return nil
+}
+
+func (r *Resolver) VisitContinueStmt(stmt *ast.ContinueStmt) interface{} {
+	if r.loopDepth == 0 {
+		panic(resolutionError(stmt.Keyword, "cannot use 'continue' outside of a loop"))
+	}
 	return nil
 }
 
 func (r *Resolver) VisitReturnStmt(stmt *ast.ReturnStmt) interface{} {
+	if r.currentFunction == noFunction {
+		panic(resolutionError(stmt.Keyword, "cannot return from top-level code"))
+	}
+	if stmt.Value != nil && *stmt.Value != nil {
+		if r.currentFunction == initializer {
+			panic(resolutionError(stmt.Keyword, "cannot return a value from an initializer"))
+		}
+		r.resolveExpr(*stmt.Value)
+	}
 	return nil
 }
 
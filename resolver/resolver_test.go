@@ -0,0 +1,152 @@
+package resolver
+
+import (
+	"bufio"
+	"fmt"
+	"lox/ast"
+	"lox/parser"
+	"lox/scanner"
+	"lox/token"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestResolverOwnInitializer(t *testing.T) {
+	expectResolutionError(t, "{ var a = a; }", 1, "cannot read local variable in its own initializer")
+}
+
+func TestResolverDuplicateDeclaration(t *testing.T) {
+	expectResolutionError(t, "{\nvar a;\nvar a;\n}", 3, "variable with this name already declared in this scope")
+}
+
+func TestResolverTopLevelReturn(t *testing.T) {
+	expectResolutionError(t, "return 1;", 1, "cannot return from top-level code")
+}
+
+func TestResolverInitializerReturn(t *testing.T) {
+	expectResolved(t, "class A { init() { return; } }")
+	expectResolutionError(t, "class A { init() { return 1; } }", 1, "cannot return a value from an initializer")
+}
+
+func TestResolverThis(t *testing.T) {
+	expectResolved(t, "class A { m() { return this; } }")
+	expectResolutionError(t, "print this;", 1, "cannot use 'this' outside of a class")
+	expectResolutionError(t, "fun f() {\nreturn this;\n}", 2, "cannot use 'this' outside of a class")
+}
+
+func TestResolverSuper(t *testing.T) {
+	expectResolved(t, "class A {} class B < A { m() { return super.m(); } }")
+	expectResolutionError(t, "super.m();", 1, "cannot use 'super' outside of a class")
+	expectResolutionError(t, "class A {\nm() {\nsuper.m();\n}\n}", 3, "cannot use 'super' in a class with no superclass")
+}
+
+func TestResolverInheritFromItself(t *testing.T) {
+	expectResolutionError(t, "class A\n< A {}", 2, "a class cannot inherit from itself")
+}
+
+func TestResolverBreakContinue(t *testing.T) {
+	expectResolved(t, "while (true) { if (true) break; else continue; }")
+	expectResolved(t, "for (;;) { { break; } }")
+	expectResolutionError(t, "break;", 1, "cannot use 'break' outside of a loop")
+	expectResolutionError(t, "if (true) {\ncontinue;\n}", 2, "cannot use 'continue' outside of a loop")
+	expectResolutionError(t, "while (true) {\nfun f() {\nbreak;\n}\n}", 3, "cannot use 'break' outside of a loop")
+}
+
+func TestResolverCatch(t *testing.T) {
+	expectResolved(t, "fun f() { try {} catch (e) { return e; } }")
+	expectResolutionError(t, "try {} catch (e) {\nvar e = e;\n}", 2, "cannot read local variable in its own initializer")
+}
+
+func TestResolverListener(t *testing.T) {
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(
+		"var a = 1;\nfun f(b) {\nvar c = a + b;\nreturn c;\n}\nclass A { m() { return this; } }"))))
+	program, errs := p.ParseProgram()
+	if len(errs) > 0 {
+		t.Fatalf("unexpected syntax errors: %v", errs)
+	}
+	var events []string
+	r := NewResolver(locals{})
+	r.SetListener(listener(func(event string) { events = append(events, event) }))
+	for _, stmt := range program.Statements {
+		if err := r.Resolve(stmt); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expected := []string{
+		"declare a global at 1:5", "declare f global at 2:5", "declare b at 2:7", "declare c at 3:5",
+		"use a at 3:9 global", "use b at 3:13 declared at 2:7", "use c at 4:8 declared at 3:5",
+		"declare A global at 6:7",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected events:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(events, "\n"))
+	}
+}
+
+type locals map[ast.Expr]int
+
+func (l locals) Resolve(expr ast.Expr, depth int, slot int) {
+	l[expr] = depth
+}
+
+// listener describes the declarations and uses it is told about.
+type listener func(event string)
+
+func (l listener) Declare(name token.Token, global bool) {
+	if global {
+		l(fmt.Sprintf("declare %s global at %v", name.Lexeme, name.Span.Start))
+	} else {
+		l(fmt.Sprintf("declare %s at %v", name.Lexeme, name.Span.Start))
+	}
+}
+
+func (l listener) Use(name token.Token, declaration *token.Token) {
+	if declaration == nil {
+		l(fmt.Sprintf("use %s at %v global", name.Lexeme, name.Span.Start))
+	} else {
+		l(fmt.Sprintf("use %s at %v declared at %v", name.Lexeme, name.Span.Start, declaration.Span.Start))
+	}
+}
+
+func resolve(t *testing.T, src string) error {
+	t.Helper()
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
+	r := NewResolver(locals{})
+	for {
+		stmt, err := p.NextStatement()
+		if err != nil {
+			t.Fatalf("unexpected syntax error: %v", err)
+		}
+		if _, ok := stmt.(*ast.EndStmt); ok {
+			return nil
+		}
+		if err := r.Resolve(stmt); err != nil {
+			return err
+		}
+	}
+}
+
+func expectResolved(t *testing.T, src string) {
+	t.Helper()
+	if err := resolve(t, src); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func expectResolutionError(t *testing.T, src string, line int, regex string) {
+	t.Helper()
+	err := resolve(t, src)
+	if err == nil {
+		t.Fatalf("expected resolution error matching '%s', got none", regex)
+	}
+	re, ok := err.(*ResolutionError)
+	if !ok {
+		t.Fatalf("expected resolution error, got '%v'", err)
+	}
+	if re.Line() != line {
+		t.Errorf("expected resolution error on line %d, got %d", line, re.Line())
+	}
+	if !regexp.MustCompile(regex).MatchString(re.Error()) {
+		t.Errorf("expected resolution error matching '%s', got '%v'", regex, re.Error())
+	}
+}
@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"fmt"
+	"lox/ast"
+	"lox/builtin"
+	"lox/interpreter"
+	"lox/resolver"
+	"lox/vm"
+)
+
+// Backend selects how statements are executed: by walking the AST, or by compiling
+// them to bytecode for the virtual machine.
+type Backend int
+
+const (
+	TreeWalker Backend = iota
+	Bytecode
+)
+
+func (b *Backend) String() string {
+	switch *b {
+	case Bytecode:
+		return "vm"
+	default:
+		return "tree"
+	}
+}
+
+func (b *Backend) Set(s string) error {
+	switch s {
+	case "tree":
+		*b = TreeWalker
+	case "vm":
+		*b = Bytecode
+	default:
+		return fmt.Errorf("unknown backend '%s', expected 'tree' or 'vm'", s)
+	}
+	return nil
+}
+
+// Engine is what both backends provide: running resolved statements, and giving access to
+// global variables and functions to the host.
+type Engine interface {
+	resolver.Interpreter
+	Interpret(ast.Stmt) (interface{}, error)
+	Done() bool
+	Global(name string) (interface{}, bool)
+	SetGlobal(name string, value interface{})
+	Call(callee interface{}, arguments []interface{}) (interface{}, error)
+	DefineNative(builtin.Native)
+	SetStreams(builtin.Streams)
+	SetLimits(builtin.Limits)
+}
+
+// Engine creates a fresh engine for the backend, with only the builtins defined.
+func (b Backend) Engine() Engine {
+	switch b {
+	case Bytecode:
+		return &bytecode{vm.NewVM()}
+	default:
+		return interpreter.NewInterpreter()
+	}
+}
+
+type bytecode struct {
+	*vm.VM
+}
+
+// Resolve does nothing, as the compiler works out where variables live on its own.
+// The resolver still runs to report semantic errors before compiling.
+func (b *bytecode) Resolve(expr ast.Expr, depth int, slot int) {
+}
@@ -2,25 +2,28 @@ package runner
 
 import (
 	"fmt"
-	"os"
+	"io"
 )
 
 type Mode interface {
-	PreStmt()
-	PostStmt(interface{})
+	PreStmt(out io.Writer)
+	PostStmt(out io.Writer, res interface{})
 	PostGrammarError(error)
 	PostRuntimeError(error)
 	Execute() bool
+	// Check tells whether the whole input is parsed and resolved before running any of it, so
+	// that nothing runs unless there are no errors.
+	Check() bool
 }
 
 type Repl struct{}
 
-func (m *Repl) PreStmt() {
-	fmt.Print("> ")
+func (m *Repl) PreStmt(out io.Writer) {
+	fmt.Fprint(out, "> ")
 }
 
-func (m *Repl) PostStmt(res interface{}) {
-	fmt.Printf("%v\n", res)
+func (m *Repl) PostStmt(out io.Writer, res interface{}) {
+	fmt.Fprintf(out, "%v\n", res)
 }
 
 func (m *Repl) PostGrammarError(err error) {
@@ -33,14 +36,20 @@ func (m *Repl) Execute() bool {
 	return true
 }
 
+func (m *Repl) Check() bool {
+	return false
+}
+
+// Script stops at the first statement after an error.
 type Script struct {
 	grammarError bool
+	runtimeError bool
 }
 
-func (m *Script) PreStmt() {
+func (m *Script) PreStmt(out io.Writer) {
 }
 
-func (m *Script) PostStmt(res interface{}) {
+func (m *Script) PostStmt(out io.Writer, res interface{}) {
 }
 
 func (m *Script) PostGrammarError(err error) {
@@ -48,9 +57,13 @@ func (m *Script) PostGrammarError(err error) {
 }
 
 func (m *Script) PostRuntimeError(err error) {
-	os.Exit(1)
+	m.runtimeError = true
 }
 
 func (m *Script) Execute() bool {
-	return !m.grammarError
+	return !m.grammarError && !m.runtimeError
+}
+
+func (m *Script) Check() bool {
+	return true
 }
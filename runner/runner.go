@@ -2,39 +2,89 @@ package runner
 
 import (
 	"bufio"
-	"fmt"
-	"lox/interpreter"
+	"lox/ast"
+	"lox/builtin"
+	"lox/diagnostics"
 	"lox/parser"
 	"lox/resolver"
 	"lox/scanner"
-	"os"
+	"lox/token"
 )
 
-func Run(reader *bufio.Reader, mode Mode) {
-	p := parser.NewParser(scanner.NewScanner(reader))
-	i := interpreter.NewInterpreter()
+// Options configures a run.
+type Options struct {
+	// Filename is how errors refer to the script. It is empty when reading from stdin.
+	Filename string
+	Backend  Backend
+	// Streams are what scripts print to and read from. Errors are reported to their Stderr.
+	Streams builtin.Streams
+	// Diagnostics is the format errors are reported in.
+	Diagnostics diagnostics.Format
+}
+
+// Run reads statements from the reader and runs them as the mode dictates, and returns whether
+// they all ran without errors. Errors are reported as diagnostics, which quote the line of the
+// script they happened on when rendered as text. If the mode checks the input first, every
+// grammar error in it is reported, and nothing runs if there is any.
+func Run(reader *bufio.Reader, mode Mode, options Options) bool {
+	streams := options.Streams
+	s := scanner.NewScanner(reader)
+	p := parser.NewParser(s)
+	i := options.Backend.Engine()
+	i.SetStreams(streams)
 	r := resolver.NewResolver(i)
+	reporter := diagnostics.NewReporter(options.Diagnostics, streams.Stderr, options.Filename)
+	defer reporter.Flush()
+	ok := true
+	report := func(err error) {
+		reporter.Report(diagnostics.FromError(err), s.Source())
+		ok = false
+	}
+	next := p.NextStatement
+	resolve := r.Resolve
+	if mode.Check() {
+		program, errs := p.ParseProgram()
+		for _, err := range r.ResolveProgram(program, errs) {
+			report(err)
+			mode.PostGrammarError(err)
+		}
+		if !ok {
+			return false
+		}
+		stmts := program.Statements
+		next = func() (ast.Stmt, error) {
+			if len(stmts) == 0 {
+				return &ast.EndStmt{Node: ast.Node{Span: token.Span{Start: program.Span.End, End: program.Span.End}}}, nil
+			}
+			stmt := stmts[0]
+			stmts = stmts[1:]
+			return stmt, nil
+		}
+		resolve = func(ast.Stmt) error { return nil }
+	}
 	for {
-		mode.PreStmt()
-		if stmt, err := p.NextStatement(); err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
+		mode.PreStmt(streams.Stdout)
+		if stmt, err := next(); err != nil {
+			report(err)
 			mode.PostGrammarError(err)
 		} else if mode.Execute() {
-			err := r.Resolve(stmt)
+			err := resolve(stmt)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err.Error())
+				report(err)
 				mode.PostGrammarError(err)
+				continue
 			}
 			res, err := i.Interpret(stmt)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err.Error())
+				report(err)
 				mode.PostRuntimeError(err)
 			} else if i.Done() {
 				break
 			}
-			mode.PostStmt(res)
+			mode.PostStmt(streams.Stdout, res)
 		} else {
 			break
 		}
 	}
+	return ok
 }
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"lox/builtin"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRunnerScripts runs every script in the tests directory on each backend, and compares
+// what it prints (on both stdout and stderr) with the .out file next to it.
+func TestRunnerScripts(t *testing.T) {
+	scripts, err := filepath.Glob("../tests/*.lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range scripts {
+		name := strings.TrimSuffix(filepath.Base(script), ".lox")
+		if (name == "busy" || name == "fibf") && testing.Short() {
+			continue
+		}
+		expected, err := os.ReadFile(strings.TrimSuffix(script, ".lox") + ".out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, backend := range []Backend{TreeWalker, Bytecode} {
+			t.Run(name+"/"+backend.String(), func(t *testing.T) {
+				if output := run(t, script, backend); output != string(expected) {
+					t.Errorf("expected output:\n%s\ngot:\n%s", expected, output)
+				}
+			})
+		}
+	}
+}
+
+func run(t *testing.T, script string, backend Backend) string {
+	t.Helper()
+	file, err := os.Open(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var output bytes.Buffer
+	streams := builtin.NewStreams(strings.NewReader(""), &output, &output)
+	Run(bufio.NewReader(file), &Script{}, Options{Filename: filepath.Base(script), Backend: backend, Streams: streams})
+	return output.String()
+}
@@ -2,25 +2,35 @@ package scanner
 
 import (
 	"bufio"
-	"errors"
+	"bytes"
 	"fmt"
 	"io"
+	"lox/diagnostics"
 	"lox/token"
 	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
+// Scanner splits source into tokens. The runes of the token being scanned are buffered in
+// chars, and start is where the first of them is in the source. Everything read so far is kept
+// in source, so that errors can quote it. Comments are kept until the next token, which they
+// are attached to.
 type Scanner struct {
-	reader  *bufio.Reader
-	chars   []rune
-	current int
-	line    int
+	reader   *bufio.Reader
+	chars    []rune
+	current  int
+	start    token.Position
+	source   strings.Builder
+	comments []token.Comment
 }
 
 type LexicalError struct {
 	line    int
 	message string
+	span    token.Span
+	hint    string
 }
 
 func (e LexicalError) Error() string {
@@ -31,11 +41,39 @@ func (e LexicalError) Line() int {
 	return e.line
 }
 
+func (e LexicalError) Column() int {
+	return e.span.Start.Column
+}
+
+func (e LexicalError) Code() string {
+	return diagnostics.CodeLexical
+}
+
+func (e LexicalError) Diagnostic() diagnostics.Diagnostic {
+	d := diagnostics.Diagnostic{Kind: "lexical", Code: e.Code(), Message: e.message, Span: e.span}
+	if e.hint != "" {
+		d.Hints = []string{e.hint}
+	}
+	return d
+}
+
 func NewScanner(reader *bufio.Reader) *Scanner {
-	return &Scanner{reader: reader, line: 1}
+	return &Scanner{reader: reader, start: token.Position{Line: 1, Column: 1}}
+}
+
+// Source returns the source read so far, which goes up to the last token scanned and possibly
+// a little past it. The last line is completed with what the reader has already buffered of it,
+// so that errors can quote it whole.
+func (s *Scanner) Source() string {
+	buffered, _ := s.reader.Peek(s.reader.Buffered())
+	if newline := bytes.IndexByte(buffered, '\n'); newline >= 0 {
+		buffered = buffered[:newline]
+	}
+	return s.source.String() + string(buffered)
 }
 
 func (s *Scanner) NextToken() (token.Token, error) {
+	s.start = s.position()
 	s.chars = s.chars[s.current:]
 	s.current = 0
 	r := s.advance()
@@ -50,6 +88,12 @@ func (s *Scanner) NextToken() (token.Token, error) {
 		return s.mkToken(token.LEFT_BRACE), nil
 	case r == '}':
 		return s.mkToken(token.RIGHT_BRACE), nil
+	case r == '[':
+		return s.mkToken(token.LEFT_BRACKET), nil
+	case r == ']':
+		return s.mkToken(token.RIGHT_BRACKET), nil
+	case r == ':':
+		return s.mkToken(token.COLON), nil
 	case r == ',':
 		return s.mkToken(token.COMMA), nil
 	case r == '.':
@@ -89,29 +133,24 @@ func (s *Scanner) NextToken() (token.Token, error) {
 	case r == '/':
 		if s.match('/') {
 			s.skipUntil(func(r rune) bool { return r == '\n' })
+			text := string(s.chars[:s.current])
+			span := token.Span{Start: s.start, End: s.position()}
+			s.comments = append(s.comments, token.Comment{Text: strings.TrimSuffix(text, "\r"), Span: span})
 			return s.NextToken()
 		} else {
 			return s.mkToken(token.SLASH), nil
 		}
 	case unicode.IsSpace(r):
-		if r == '\n' {
-			s.line += 1
-		}
-		s.skipUntil(func(r rune) bool {
-			if r == '\n' {
-				s.line += 1
-			}
-			return !unicode.IsSpace(r)
-		})
+		s.skipUntil(func(r rune) bool { return !unicode.IsSpace(r) })
 		return s.NextToken()
 	case r == '"':
-		return s.str(), nil
+		return s.str()
 	case unicode.IsDigit(r):
 		return s.num()
 	case unicode.IsLetter(r):
 		return s.id(), nil
 	default:
-		return s.mkToken(token.ERROR), &LexicalError{s.line, "unexpected character"}
+		return s.mkToken(token.ERROR), s.error("unexpected character", "")
 	}
 }
 
@@ -132,18 +171,20 @@ func (s *Scanner) num() (token.Token, error) {
 		s.skipUntil(func(r rune) bool { return !unicode.IsDigit(r) })
 	}
 	if x, err := strconv.ParseFloat(string(s.chars[:s.current]), 64); err != nil {
-		return s.mkToken(token.ERROR), &LexicalError{s.line, "invalid number"}
+		return s.mkToken(token.ERROR), s.error("invalid number", "")
 	} else {
 		return s.mkLiteral(token.NUMBER, x), nil
 	}
 }
 
-func (s *Scanner) str() token.Token {
-	s.current += 1 // skip the opening quote
+func (s *Scanner) str() (token.Token, error) {
 	s.skipUntil(func(r rune) bool { return r == '"' })
+	if s.readRune() == utf8.RuneError {
+		return s.mkToken(token.ERROR), s.error("unterminated string", "strings end with a double quote")
+	}
 	value := string(s.chars[1:s.current])
 	s.current += 1 // skip the closing quote
-	return s.mkLiteral(token.STRING, value)
+	return s.mkLiteral(token.STRING, value), nil
 }
 
 func (s *Scanner) match(expected rune) bool {
@@ -182,14 +223,16 @@ func (s *Scanner) readRuneAhead(offset int) rune {
 	for d := offset - len(s.chars) + 1; d > 0; d-- {
 		r, sz, err := s.reader.ReadRune()
 		if r == utf8.RuneError && sz == 1 {
-			panic(errors.New("invalid UTF-8 sequence"))
+			panic(s.error("invalid UTF-8 sequence", ""))
 		}
 		if err != nil {
 			if err == io.EOF {
 				s.chars = append(s.chars, utf8.RuneError)
 			} else {
-				panic(err)
+				panic(s.error(err.Error(), ""))
 			}
+		} else {
+			s.source.WriteRune(r)
 		}
 		s.chars = append(s.chars, r)
 	}
@@ -202,25 +245,49 @@ func (s *Scanner) mkToken(t token.Type) token.Token {
 
 func (s *Scanner) mkLiteral(t token.Type, literal interface{}) token.Token {
 	lexeme := string(s.chars[:s.current])
-	return token.Token{Type: t, Lexeme: lexeme, Literal: literal, Line: s.line}
+	span := token.Span{Start: s.start, End: s.position()}
+	comments := s.comments
+	s.comments = nil
+	return token.Token{Type: t, Lexeme: lexeme, Literal: literal, Line: s.start.Line, Span: span, Comments: comments}
+}
+
+// error returns an error about the token being scanned.
+func (s *Scanner) error(message string, hint string) *LexicalError {
+	span := token.Span{Start: s.start, End: s.position()}
+	return &LexicalError{line: s.start.Line, message: message, span: span, hint: hint}
+}
+
+// position returns the position of the rune being scanned.
+func (s *Scanner) position() token.Position {
+	position := s.start
+	for _, r := range s.chars[:s.current] {
+		position = position.Next(r)
+	}
+	return position
 }
 
 var keywords = map[string]token.Type{
-	"and":    token.AND,
-	"assert": token.ASSERT,
-	"class":  token.CLASS,
-	"else":   token.ELSE,
-	"false":  token.FALSE,
-	"for":    token.FOR,
-	"fun":    token.FUN,
-	"if":     token.IF,
-	"nil":    token.NIL,
-	"or":     token.OR,
-	"print":  token.PRINT,
-	"return": token.RETURN,
-	"super":  token.SUPER,
-	"this":   token.THIS,
-	"true":   token.TRUE,
-	"var":    token.VAR,
-	"while":  token.WHILE,
+	"and":      token.AND,
+	"assert":   token.ASSERT,
+	"break":    token.BREAK,
+	"catch":    token.CATCH,
+	"class":    token.CLASS,
+	"continue": token.CONTINUE,
+	"else":     token.ELSE,
+	"false":    token.FALSE,
+	"finally":  token.FINALLY,
+	"for":      token.FOR,
+	"fun":      token.FUN,
+	"if":       token.IF,
+	"nil":      token.NIL,
+	"or":       token.OR,
+	"print":    token.PRINT,
+	"return":   token.RETURN,
+	"super":    token.SUPER,
+	"this":     token.THIS,
+	"throw":    token.THROW,
+	"true":     token.TRUE,
+	"try":      token.TRY,
+	"var":      token.VAR,
+	"while":    token.WHILE,
 }
@@ -39,12 +39,15 @@ func TestScannerNumberTooBig(t *testing.T) {
 }
 
 func TestScannerSimpleTokens(t *testing.T) {
-	src := "(){},.+-;*"
+	src := "(){}[]:,.+-;*"
 	s := NewScanner(bufio.NewReader(strings.NewReader(src)))
 	expectTokenType(t, expectNext(t, s), token.LEFT_PAREN)
 	expectTokenType(t, expectNext(t, s), token.RIGHT_PAREN)
 	expectTokenType(t, expectNext(t, s), token.LEFT_BRACE)
 	expectTokenType(t, expectNext(t, s), token.RIGHT_BRACE)
+	expectTokenType(t, expectNext(t, s), token.LEFT_BRACKET)
+	expectTokenType(t, expectNext(t, s), token.RIGHT_BRACKET)
+	expectTokenType(t, expectNext(t, s), token.COLON)
 	expectTokenType(t, expectNext(t, s), token.COMMA)
 	expectTokenType(t, expectNext(t, s), token.DOT)
 	expectTokenType(t, expectNext(t, s), token.PLUS)
@@ -84,9 +87,10 @@ func TestScannerNumbers(t *testing.T) {
 }
 
 func TestScannerStrings(t *testing.T) {
-	src := `"hello" "world"`
+	src := `"hello" "" "world"`
 	s := NewScanner(bufio.NewReader(strings.NewReader(src)))
 	expectStringLiteral(t, expectNext(t, s), "hello")
+	expectStringLiteral(t, expectNext(t, s), "")
 	expectStringLiteral(t, expectNext(t, s), "world")
 	expectTokenType(t, expectNext(t, s), token.EOF)
 }
@@ -118,6 +122,38 @@ func TestScannerLineNumbers(t *testing.T) {
 	expectTokenType(t, expectNext(t, s), token.EOF)
 }
 
+func TestScannerSpans(t *testing.T) {
+	src := "var é =\n  \"a\nb\"; // c\nx"
+	s := NewScanner(bufio.NewReader(strings.NewReader(src)))
+	expectSpan(t, expectNext(t, s), "1:1-1:4")
+	expectSpan(t, expectNext(t, s), "1:5-1:6")
+	expectSpan(t, expectNext(t, s), "1:7-1:8")
+	expectSpan(t, expectNext(t, s), "2:3-3:3")
+	expectSpan(t, expectNext(t, s), "3:3-3:4")
+	tk := expectNext(t, s)
+	expectSpan(t, tk, "4:1-4:2")
+	if tk.Span.Start.Offset != 23 || tk.Line != 4 {
+		t.Errorf("expected offset 23 on line 4, got offset %d on line %d", tk.Span.Start.Offset, tk.Line)
+	}
+	expectSpan(t, expectNext(t, s), "4:2-4:2")
+}
+
+func TestScannerUnterminatedString(t *testing.T) {
+	s := NewScanner(bufio.NewReader(strings.NewReader("\"abc")))
+	if _, err := s.NextToken(); err == nil || err.Error() != "lexical error on line 1: unterminated string" {
+		t.Errorf("expected unterminated string error, got %v", err)
+	}
+	expectTokenType(t, expectNext(t, s), token.EOF)
+}
+
+func TestScannerSource(t *testing.T) {
+	s := NewScanner(bufio.NewReader(strings.NewReader("a b\nc")))
+	expectIdentifier(t, expectNext(t, s), "a")
+	if source := s.Source(); source != "a b" {
+		t.Errorf("expected the source to go up to the end of the line, got %q", source)
+	}
+}
+
 func TestScannerAssert(t *testing.T) {
 	src := `assert true;`
 	s := NewScanner(bufio.NewReader(strings.NewReader(src)))
@@ -187,6 +223,13 @@ func expectIdentifier(t *testing.T, tk token.Token, expected string) {
 	}
 }
 
+func expectSpan(t *testing.T, tk token.Token, expected string) {
+	t.Helper()
+	if tk.Span.String() != expected {
+		t.Errorf("expected span %s for '%s', got %v", expected, tk.Lexeme, tk.Span)
+	}
+}
+
 func expectLineNumber(t *testing.T, tk token.Token, expected int) {
 	t.Helper()
 	if tk.Line != expected {
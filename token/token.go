@@ -1,18 +1,73 @@
 package token
 
-import "fmt"
+import (
+	"fmt"
+	"unicode/utf8"
+)
 
+// Token is a lexeme of the source. Comments are not tokens: they are kept as trivia, with the
+// token following them.
 type Token struct {
-	Type    Type
-	Lexeme  string
-	Literal interface{}
-	Line    int
+	Type     Type
+	Lexeme   string
+	Literal  interface{}
+	Line     int
+	Span     Span
+	Comments []Comment
+}
+
+// Comment is a line comment, with its leading slashes but without its line ending.
+type Comment struct {
+	Text string
+	Span Span
 }
 
 func (t Token) String() string {
 	return fmt.Sprintf("%v %v %v", t.Type, t.Lexeme, t.Literal)
 }
 
+// Position is a location in the source: a byte offset from its start, and a line and a column
+// starting from 1. Columns count runes, not bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Advance returns the position after the given text, when it starts at p.
+func (p Position) Advance(text string) Position {
+	for _, r := range text {
+		p = p.Next(r)
+	}
+	return p
+}
+
+// Next returns the position after the given rune, when it is at p.
+func (p Position) Next(r rune) Position {
+	if r == '\n' {
+		p.Line++
+		p.Column = 1
+	} else {
+		p.Column++
+	}
+	p.Offset += utf8.RuneLen(r)
+	return p
+}
+
+// Span is the part of the source between two positions, the end being excluded.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf("%v-%v", s.Start, s.End)
+}
+
 type Type int
 
 const (
@@ -24,6 +79,9 @@ const (
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_BRACKET
+	RIGHT_BRACKET
+	COLON
 	COMMA
 	DOT
 	MINUS
@@ -50,9 +108,13 @@ const (
 	// Keywords.
 	AND
 	ASSERT
+	BREAK
+	CATCH
 	CLASS
+	CONTINUE
 	ELSE
 	FALSE
+	FINALLY
 	FUN
 	FOR
 	IF
@@ -62,7 +124,9 @@ const (
 	RETURN
 	SUPER
 	THIS
+	THROW
 	TRUE
+	TRY
 	VAR
 	WHILE
 
@@ -79,6 +143,12 @@ func (t Type) String() string {
 		return "LEFT_BRACE"
 	case RIGHT_BRACE:
 		return "RIGHT_BRACE"
+	case LEFT_BRACKET:
+		return "LEFT_BRACKET"
+	case RIGHT_BRACKET:
+		return "RIGHT_BRACKET"
+	case COLON:
+		return "COLON"
 	case COMMA:
 		return "COMMA"
 	case DOT:
@@ -117,12 +187,20 @@ func (t Type) String() string {
 		return "NUMBER"
 	case AND:
 		return "AND"
+	case BREAK:
+		return "BREAK"
+	case CATCH:
+		return "CATCH"
 	case CLASS:
 		return "CLASS"
+	case CONTINUE:
+		return "CONTINUE"
 	case ELSE:
 		return "ELSE"
 	case FALSE:
 		return "FALSE"
+	case FINALLY:
+		return "FINALLY"
 	case FUN:
 		return "FUN"
 	case FOR:
@@ -141,8 +219,12 @@ func (t Type) String() string {
 		return "SUPER"
 	case THIS:
 		return "THIS"
+	case THROW:
+		return "THROW"
 	case TRUE:
 		return "TRUE"
+	case TRY:
+		return "TRY"
 	case VAR:
 		return "VAR"
 	case WHILE:
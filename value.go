@@ -0,0 +1,72 @@
+package lox
+
+import (
+	"fmt"
+	"lox/builtin"
+)
+
+// Value is a value computed by a script. The zero Value is nil.
+type Value struct {
+	value interface{}
+}
+
+func (v Value) IsNil() bool {
+	return v.value == nil
+}
+
+func (v Value) Bool() (bool, error) {
+	if b, ok := v.value.(bool); ok {
+		return b, nil
+	}
+	return false, v.mismatch("a boolean")
+}
+
+func (v Value) Number() (float64, error) {
+	if n, ok := v.value.(float64); ok {
+		return n, nil
+	}
+	return 0, v.mismatch("a number")
+}
+
+// Str returns the value if it is a string. Use String for the text of any value.
+func (v Value) Str() (string, error) {
+	if s, ok := v.value.(string); ok {
+		return s, nil
+	}
+	return "", v.mismatch("a string")
+}
+
+func (v Value) List() ([]Value, error) {
+	list, ok := v.value.(*builtin.List)
+	if !ok {
+		return nil, v.mismatch("a list")
+	}
+	elements := make([]Value, len(list.Elements))
+	for i, element := range list.Elements {
+		elements[i] = Value{element}
+	}
+	return elements, nil
+}
+
+// Map returns the entries of the value if it is a map. Keys are nil, or a bool, a float64 or a string.
+func (v Value) Map() (map[interface{}]Value, error) {
+	m, ok := v.value.(*builtin.Map)
+	if !ok {
+		return nil, v.mismatch("a map")
+	}
+	entries := make(map[interface{}]Value, m.Len())
+	for _, key := range m.Keys() {
+		value, _ := m.Get(key)
+		entries[key] = Value{value}
+	}
+	return entries, nil
+}
+
+// String renders the value the way print does.
+func (v Value) String() string {
+	return fmt.Sprint(v.value)
+}
+
+func (v Value) mismatch(expected string) error {
+	return fmt.Errorf("expected %s, got %s", expected, builtin.Repr(v.value))
+}
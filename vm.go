@@ -0,0 +1,217 @@
+package lox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"lox/ast"
+	"lox/builtin"
+	"lox/parser"
+	"lox/resolver"
+	"lox/runner"
+	"lox/scanner"
+	"reflect"
+	"strings"
+)
+
+// Backend selects how a VM executes scripts.
+type Backend = runner.Backend
+
+const (
+	TreeWalker = runner.TreeWalker
+	Bytecode   = runner.Bytecode
+)
+
+type Options struct {
+	Backend Backend
+	// Stdin is what natives like readLine read from, and Stdout is where print writes to.
+	// They default to the standard streams of the process.
+	Stdin  io.Reader
+	Stdout io.Writer
+	// MaxSteps and MaxCallDepth limit each run and each call, as described by builtin.Limits.
+	// Going over them fails with an error wrapping ErrStepLimit or ErrCallDepth.
+	MaxSteps     int
+	MaxCallDepth int
+}
+
+// VM runs Lox scripts on behalf of a Go program. Globals outlive the script defining them, so
+// the program can read them, or call the functions a script declared. Errors in scripts are
+// returned, and never end the process. A VM must not be used by several goroutines at once.
+type VM struct {
+	engine   runner.Engine
+	resolver *resolver.Resolver
+	options  Options
+	// running is how many runs and calls are in progress, as natives can call back into the VM.
+	running int
+}
+
+func NewVM(options Options) *VM {
+	engine := options.Backend.Engine()
+	streams := builtin.StandardStreams()
+	if options.Stdin != nil {
+		streams.Stdin = bufio.NewReader(options.Stdin)
+	}
+	if options.Stdout != nil {
+		streams.Stdout = options.Stdout
+	}
+	engine.SetStreams(streams)
+	return &VM{engine: engine, resolver: resolver.NewResolver(engine), options: options}
+}
+
+// Run runs a script, returning the value of its last statement if it is an expression.
+func (vm *VM) Run(src string) (Value, error) {
+	return vm.RunReaderContext(context.Background(), strings.NewReader(src))
+}
+
+// RunContext is like Run, but stops the script with an error wrapping ErrCanceled once the
+// context is done.
+func (vm *VM) RunContext(ctx context.Context, src string) (Value, error) {
+	return vm.RunReaderContext(ctx, strings.NewReader(src))
+}
+
+// RunReader is like Run, but reads the script from a reader. Statements run as soon as they
+// are read, so the ones before an error keep their effects.
+func (vm *VM) RunReader(reader io.Reader) (Value, error) {
+	return vm.RunReaderContext(context.Background(), reader)
+}
+
+// RunReaderContext is like RunReader, but stops the script once the context is done.
+func (vm *VM) RunReaderContext(ctx context.Context, reader io.Reader) (Value, error) {
+	vm.setLimits(ctx)
+	vm.running++
+	defer func() {
+		vm.running--
+	}()
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(reader)))
+	var result interface{}
+	for {
+		stmt, err := p.NextStatement()
+		if err != nil {
+			return Value{}, err
+		}
+		if _, ok := stmt.(*ast.EndStmt); ok {
+			return Value{result}, nil
+		}
+		if err := vm.resolver.Resolve(stmt); err != nil {
+			return Value{}, err
+		}
+		if result, err = vm.engine.Interpret(stmt); err != nil {
+			return Value{}, err
+		}
+	}
+}
+
+// setLimits gives the engine a fresh budget for a run or a call. Runs and calls made by natives
+// while a script runs share its budget and context instead.
+func (vm *VM) setLimits(ctx context.Context) {
+	if vm.running > 0 {
+		return
+	}
+	vm.engine.SetLimits(builtin.Limits{Context: ctx, MaxSteps: vm.options.MaxSteps, MaxCallDepth: vm.options.MaxCallDepth})
+}
+
+// Global returns the value of a global variable, if it is defined.
+func (vm *VM) Global(name string) (Value, bool) {
+	value, ok := vm.engine.Global(name)
+	return Value{value}, ok
+}
+
+// SetGlobal defines a global variable, or assigns it if it is already defined. The value is
+// either a Value, or a Go value with a Lox counterpart: nil, a bool, a number, a string, or
+// a slice, array or map of those.
+func (vm *VM) SetGlobal(name string, value interface{}) error {
+	v, err := toLox(value)
+	if err != nil {
+		return err
+	}
+	vm.engine.SetGlobal(name, v)
+	return nil
+}
+
+// Call calls the function or class in a global variable, with arguments of the same types
+// SetGlobal accepts.
+func (vm *VM) Call(name string, arguments ...interface{}) (Value, error) {
+	callee, ok := vm.engine.Global(name)
+	if !ok {
+		return Value{}, fmt.Errorf("global variable '%s' is not defined", name)
+	}
+	return vm.CallValue(Value{callee}, arguments...)
+}
+
+// CallValue calls a function or class a script returned, like Call does.
+func (vm *VM) CallValue(callee Value, arguments ...interface{}) (Value, error) {
+	values := make([]interface{}, len(arguments))
+	for i, argument := range arguments {
+		value, err := toLox(argument)
+		if err != nil {
+			return Value{}, err
+		}
+		values[i] = value
+	}
+	vm.setLimits(context.Background())
+	vm.running++
+	defer func() {
+		vm.running--
+	}()
+	result, err := vm.engine.Call(callee.value, values)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{result}, nil
+}
+
+// Define makes a Go function available to scripts as a global function. Arguments are
+// converted to the types of its parameters: numbers to integers and floats, lists to slices,
+// maps to maps, and anything to a Value. The function can return nothing, a value, an error,
+// or a value and an error, and its value is converted back like SetGlobal does. Arguments that
+// do not fit, as well as errors the function returns, fail the call with a runtime error.
+func (vm *VM) Define(name string, function interface{}) error {
+	f := reflect.ValueOf(function)
+	if f.Kind() != reflect.Func {
+		return fmt.Errorf("cannot define '%s': expected a function, got %T", name, function)
+	}
+	t := f.Type()
+	if t.NumOut() > 2 || t.NumOut() == 2 && t.Out(1) != errorType {
+		return fmt.Errorf("cannot define '%s': a function can only return a value, an error, or both", name)
+	}
+	arity := t.NumIn()
+	if t.IsVariadic() {
+		arity--
+	}
+	vm.engine.DefineNative(builtin.Native{Name: name, Arity: arity, Variadic: t.IsVariadic(), Call: func(arguments []interface{}) (result interface{}, err error) {
+		in := make([]reflect.Value, len(arguments))
+		for i, argument := range arguments {
+			parameter := t.In(min(i, t.NumIn()-1))
+			if t.IsVariadic() && i >= arity {
+				parameter = parameter.Elem()
+			}
+			v, err := fromLox(argument, parameter)
+			if err != nil {
+				return nil, fmt.Errorf("invalid argument %d to '%s': %v", i+1, name, err)
+			}
+			in[i] = v
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				result, err = nil, fmt.Errorf("'%s' panicked: %v", name, r)
+			}
+		}()
+		out := f.Call(in)
+		if len(out) > 0 && t.Out(len(out)-1) == errorType {
+			if err := out[len(out)-1]; !err.IsNil() {
+				return nil, err.Interface().(error)
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return nil, nil
+		}
+		result, err = toLoxValue(out[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid result from '%s': %v", name, err)
+		}
+		return result, nil
+	}})
+	return nil
+}
@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"fmt"
+	"lox/builtin"
+	"lox/compiler"
+)
+
+type closure struct {
+	function *compiler.Function
+	upvalues []*upvalue
+}
+
+func (c *closure) String() string {
+	return c.function.String()
+}
+
+// upvalue refers to a variable captured by a closure. While the upvalue is open, the variable
+// is still in its slot of the stack; once the variable goes out of scope, it is moved into
+// closed.
+type upvalue struct {
+	open   bool
+	slot   int
+	closed interface{}
+	next   *upvalue
+}
+
+type native struct {
+	builtin.Native
+}
+
+func (n *native) String() string {
+	return fmt.Sprintf("<fn %s>", n.Name)
+}
+
+type class struct {
+	name    string
+	methods map[string]*closure
+}
+
+func (c *class) String() string {
+	return c.name
+}
+
+type instance struct {
+	class  *class
+	fields map[string]interface{}
+}
+
+func (o *instance) String() string {
+	return fmt.Sprintf("%s instance", o.class.name)
+}
+
+type boundMethod struct {
+	receiver interface{}
+	method   *closure
+}
+
+func (b *boundMethod) String() string {
+	return b.method.String()
+}
+
+func truthy(value interface{}) bool {
+	switch x := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	default:
+		return true
+	}
+}
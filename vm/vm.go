@@ -0,0 +1,756 @@
+package vm
+
+import (
+	"fmt"
+	"lox/ast"
+	"lox/builtin"
+	"lox/compiler"
+	"lox/diagnostics"
+	"lox/token"
+)
+
+// RuntimeError is an error happening while running a script. Errors caused by going over the
+// limits of the script wrap the corresponding error from builtin.
+type RuntimeError struct {
+	span    token.Span
+	message string
+	err     error
+	trace   []builtin.Frame
+}
+
+func (e RuntimeError) Error() string {
+	return fmt.Sprintf("runtime error on line %d: %s", e.span.Start.Line, e.message)
+}
+
+// Trace returns the calls that were in progress when the error happened, innermost first.
+func (e RuntimeError) Trace() []builtin.Frame {
+	return e.trace
+}
+
+func (e RuntimeError) Line() int {
+	return e.span.Start.Line
+}
+
+func (e RuntimeError) Column() int {
+	return e.span.Start.Column
+}
+
+func (e RuntimeError) Code() string {
+	return diagnostics.CodeRuntime
+}
+
+func (e RuntimeError) Diagnostic() diagnostics.Diagnostic {
+	d := diagnostics.Diagnostic{Kind: "runtime", Code: e.Code(), Message: e.message, Span: e.span}
+	if note := builtin.TraceNote(e.trace); note != "" {
+		d.Notes = []string{note}
+	}
+	return d
+}
+
+func (e RuntimeError) Unwrap() error {
+	return e.err
+}
+
+// thrown unwinds the VM up to the closest handler, like RuntimeError does. Both keep the trace
+// of where they were raised, since finally blocks unwind the stack before rethrowing them.
+type thrown struct {
+	value interface{}
+	span  token.Span
+	trace []builtin.Frame
+}
+
+// uncaught turns a value that was thrown and never caught into the error reported for it.
+func (t *thrown) uncaught() *RuntimeError {
+	if e, ok := t.value.(*builtin.Error); ok {
+		return &RuntimeError{span: e.Span, message: e.Message, trace: t.trace}
+	}
+	return &RuntimeError{span: t.span, message: "uncaught exception: " + builtin.Repr(t.value), trace: t.trace}
+}
+
+// handler is where execution resumes when a value is thrown inside a try statement. Handlers
+// of finally blocks get what is unwinding the stack as it is, for them to rethrow it.
+type handler struct {
+	frameCount int
+	sp         int
+	ip         int
+	finally    bool
+}
+
+type frame struct {
+	closure   *closure
+	code      []byte
+	constants []interface{}
+	ip        int
+	// base is the stack index of slot zero of the frame, i.e. the callee (or receiver).
+	base int
+}
+
+func (f *frame) readByte() byte {
+	b := f.code[f.ip]
+	f.ip++
+	return b
+}
+
+func (f *frame) readShort() int {
+	s := int(f.code[f.ip])<<8 | int(f.code[f.ip+1])
+	f.ip += 2
+	return s
+}
+
+func (f *frame) readString() string {
+	return f.constants[f.readShort()].(string)
+}
+
+// VM runs compiled statements. The stack and the frames grow as calls are nested, up to the
+// call depth limit; open upvalues refer to stack slots by index, so that they survive growing.
+type VM struct {
+	stack        []interface{}
+	sp           int
+	frames       []frame
+	frameCount   int
+	globals      map[string]interface{}
+	openUpvalues *upvalue
+	handlers     []handler
+	streams      *builtin.Streams
+	budget       builtin.Budget
+	maxDepth     int
+	outerFrames  int
+	// base is how many frames there were when the current run started: more than zero when a
+	// native calls back into the VM while a script runs.
+	base int
+	done bool
+}
+
+// entry is the state of the VM when a run starts, which it goes back to once it is done.
+type entry struct {
+	base       int
+	frameCount int
+	sp         int
+	handlers   int
+}
+
+// enter starts a run on top of the frames already on the stack, if any.
+func (vm *VM) enter() entry {
+	start := entry{base: vm.base, frameCount: vm.frameCount, sp: vm.sp, handlers: len(vm.handlers)}
+	vm.base = vm.frameCount
+	return start
+}
+
+// NewVM creates a virtual machine using the standard streams of the process.
+func NewVM() *VM {
+	streams := builtin.StandardStreams()
+	vm := &VM{globals: make(map[string]interface{}), streams: &streams}
+	vm.SetLimits(builtin.Limits{})
+	for _, n := range builtin.Natives {
+		vm.DefineNative(n)
+	}
+	for _, n := range vm.streams.Natives() {
+		vm.DefineNative(n)
+	}
+	return vm
+}
+
+// SetStreams replaces the streams that scripts print to and read from.
+func (vm *VM) SetStreams(streams builtin.Streams) {
+	*vm.streams = streams
+}
+
+// SetLimits sets the limits of the scripts run from now on, with a fresh budget of steps.
+func (vm *VM) SetLimits(limits builtin.Limits) {
+	vm.budget = builtin.NewBudget(limits)
+	vm.maxDepth = limits.CallDepth(builtin.DefaultMaxCallDepth)
+}
+
+// DefineNative defines a global variable holding the native, replacing any previous value.
+func (vm *VM) DefineNative(n builtin.Native) {
+	vm.globals[n.Name] = &native{n}
+}
+
+// Interpret compiles and runs a single top-level statement, returning its value if it is an expression.
+func (vm *VM) Interpret(stmt ast.Stmt) (result interface{}, err error) {
+	if _, ok := stmt.(*ast.EndStmt); ok {
+		vm.done = true
+		return nil, nil
+	}
+	function, err := compiler.Compile(stmt)
+	if err != nil {
+		return nil, err
+	}
+	start := vm.enter()
+	defer vm.recoverError(&err, start)
+	script := &closure{function: function}
+	if start.frameCount == 0 {
+		// The frame running the statement itself does not count towards the call depth.
+		vm.outerFrames = 1
+	}
+	vm.push(script)
+	vm.call(script, 0)
+	result = vm.run()
+	vm.base = start.base
+	return result, nil
+}
+
+// Call calls a function or class from outside of any script, with values that are already
+// Lox values. Errors are reported as happening on line zero. Natives can call it while a script
+// runs, and the calls in progress are then left as they were, even if the call fails.
+func (vm *VM) Call(callee interface{}, arguments []interface{}) (result interface{}, err error) {
+	start := vm.enter()
+	defer vm.recoverError(&err, start)
+	if start.frameCount == 0 {
+		vm.outerFrames = 0
+	}
+	vm.push(callee)
+	for _, argument := range arguments {
+		vm.push(argument)
+	}
+	vm.callValue(callee, len(arguments))
+	// Natives, and classes without an initializer, are done by now and left their result on the stack.
+	if vm.frameCount == vm.base {
+		result = vm.pop()
+	} else {
+		result = vm.run()
+	}
+	vm.base = start.base
+	return result, nil
+}
+
+// recoverError turns what a failing run panicked with into its error, and brings the VM back to
+// where it was when the run started.
+func (vm *VM) recoverError(err *error, start entry) {
+	if e := recover(); e != nil {
+		var re *RuntimeError
+		switch e := e.(type) {
+		case *RuntimeError:
+			re = e
+		case *thrown:
+			re = e.uncaught()
+		default:
+			panic(fmt.Errorf("unexpected error during execution: %v", e))
+		}
+		vm.closeUpvalues(start.sp)
+		vm.base, vm.frameCount, vm.sp = start.base, start.frameCount, start.sp
+		vm.handlers = vm.handlers[:start.handlers]
+		*err = re
+	}
+}
+
+// trace returns the frames on the stack, innermost first, given the line the innermost one
+// reached. The outer frames are at the line of the call they are making.
+func (vm *VM) trace(line int) []builtin.Frame {
+	var trace []builtin.Frame
+	for index := vm.frameCount - 1; index >= 0; index-- {
+		f := &vm.frames[index]
+		if index < vm.frameCount-1 {
+			line = f.closure.function.Chunk.Line(f.ip - 1)
+		}
+		name := builtin.FunctionName(f.closure.function.Name)
+		if index < vm.outerFrames {
+			name = "script"
+		}
+		trace = append(trace, builtin.Frame{Function: name, Line: line})
+	}
+	return trace
+}
+
+// Global returns the value of a global variable, if it is defined.
+func (vm *VM) Global(name string) (interface{}, bool) {
+	value, ok := vm.globals[name]
+	return value, ok
+}
+
+// SetGlobal defines a global variable, or assigns it if it is already defined.
+func (vm *VM) SetGlobal(name string, value interface{}) {
+	vm.globals[name] = value
+}
+
+func (vm *VM) Done() bool {
+	return vm.done
+}
+
+func (vm *VM) error(message string) {
+	vm.fail(message, nil)
+}
+
+// fail raises a runtime error at the span of the current instruction, wrapping err if not nil.
+func (vm *VM) fail(message string, err error) {
+	span := vm.span()
+	panic(&RuntimeError{span: span, message: message, err: err, trace: vm.trace(span.Start.Line)})
+}
+
+// throw throws a value from the current instruction. Errors thrown again keep their line.
+func (vm *VM) throw(value interface{}) {
+	span := vm.span()
+	line := span.Start.Line
+	if e, ok := value.(*builtin.Error); ok {
+		line = e.Line
+	}
+	panic(&thrown{value: value, span: span, trace: vm.trace(line)})
+}
+
+// span returns the span of the current instruction. Errors happening outside of any frame come
+// from calls made by the host, and have no span.
+func (vm *VM) span() token.Span {
+	if vm.frameCount == 0 {
+		return token.Span{}
+	}
+	f := &vm.frames[vm.frameCount-1]
+	return f.closure.function.Chunk.Spans[f.ip-1]
+}
+
+func (vm *VM) push(value interface{}) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, value)
+	} else {
+		vm.stack[vm.sp] = value
+	}
+	vm.sp++
+}
+
+func (vm *VM) pop() interface{} {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) peek(distance int) interface{} {
+	return vm.stack[vm.sp-1-distance]
+}
+
+// run executes the current frame until the outermost one of the run returns, resuming at the
+// closest handler of the run whenever a runtime error happens or a value is thrown inside a try
+// statement.
+func (vm *VM) run() interface{} {
+	for {
+		if result, done := vm.execute(); done {
+			return result
+		}
+	}
+}
+
+func (vm *VM) execute() (result interface{}, done bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].frameCount <= vm.base {
+				panic(e)
+			}
+			if vm.handlers[len(vm.handlers)-1].finally {
+				vm.catch(e)
+				return
+			}
+			switch e := e.(type) {
+			case *RuntimeError:
+				vm.catch(&builtin.Error{Message: e.message, Line: e.Line(), Span: e.span})
+			case *thrown:
+				vm.catch(e.value)
+			default:
+				panic(e)
+			}
+		}
+	}()
+	return vm.dispatch(), true
+}
+
+// catch unwinds the stack to the closest handler, and pushes the value caught by it.
+func (vm *VM) catch(value interface{}) {
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.closeUpvalues(h.sp)
+	vm.frameCount = h.frameCount
+	vm.sp = h.sp
+	vm.push(value)
+	vm.frames[vm.frameCount-1].ip = h.ip
+}
+
+func (vm *VM) dispatch() interface{} {
+	f := &vm.frames[vm.frameCount-1]
+	for {
+		op := compiler.OpCode(f.readByte())
+		if err := vm.budget.Step(); err != nil {
+			vm.fail(err.Error(), err)
+		}
+		switch op {
+		case compiler.CONSTANT:
+			vm.push(f.constants[f.readShort()])
+		case compiler.NIL:
+			vm.push(nil)
+		case compiler.TRUE:
+			vm.push(true)
+		case compiler.FALSE:
+			vm.push(false)
+		case compiler.POP:
+			vm.sp--
+		case compiler.GET_LOCAL:
+			vm.push(vm.stack[f.base+int(f.readByte())])
+		case compiler.SET_LOCAL:
+			vm.stack[f.base+int(f.readByte())] = vm.peek(0)
+		case compiler.GET_GLOBAL:
+			value, ok := vm.globals[f.readString()]
+			if !ok {
+				vm.error("variable not defined")
+			}
+			vm.push(value)
+		case compiler.DEFINE_GLOBAL:
+			name := f.readString()
+			if _, ok := vm.globals[name]; ok {
+				vm.error("variable already declared")
+			}
+			vm.globals[name] = vm.pop()
+		case compiler.SET_GLOBAL:
+			name := f.readString()
+			if _, ok := vm.globals[name]; !ok {
+				vm.error("variable not declared")
+			}
+			vm.globals[name] = vm.peek(0)
+		case compiler.GET_UPVALUE:
+			vm.push(vm.getUpvalue(f.closure.upvalues[f.readByte()]))
+		case compiler.SET_UPVALUE:
+			vm.setUpvalue(f.closure.upvalues[f.readByte()], vm.peek(0))
+		case compiler.GET_PROPERTY:
+			name := f.readString()
+			switch object := vm.peek(0).(type) {
+			case *instance:
+				if value, ok := object.fields[name]; ok {
+					vm.stack[vm.sp-1] = value
+				} else {
+					vm.bindMethod(object.class, name)
+				}
+			case *builtin.Error:
+				vm.stack[vm.sp-1] = vm.errorProperty(object, name)
+			default:
+				vm.error("only instances have properties")
+			}
+		case compiler.SET_PROPERTY:
+			name := f.readString()
+			instance, ok := vm.peek(1).(*instance)
+			if !ok {
+				vm.error("only instances have fields")
+			}
+			value := vm.pop()
+			instance.fields[name] = value
+			vm.stack[vm.sp-1] = value
+		case compiler.GET_SUPER:
+			name := f.readString()
+			vm.bindMethod(vm.pop().(*class), name)
+		case compiler.BUILD_LIST:
+			count := f.readShort()
+			elements := make([]interface{}, count)
+			copy(elements, vm.stack[vm.sp-count:vm.sp])
+			vm.sp -= count
+			vm.push(builtin.NewList(elements))
+		case compiler.BUILD_MAP:
+			count := f.readShort()
+			m := builtin.NewMap()
+			for entry := vm.sp - 2*count; entry < vm.sp; entry += 2 {
+				if err := m.Set(vm.stack[entry], vm.stack[entry+1]); err != nil {
+					vm.error(err.Error())
+				}
+			}
+			vm.sp -= 2 * count
+			vm.push(m)
+		case compiler.GET_INDEX:
+			index := vm.pop()
+			value, err := builtin.Index(vm.peek(0), index)
+			if err != nil {
+				vm.error(err.Error())
+			}
+			vm.stack[vm.sp-1] = value
+		case compiler.SET_INDEX:
+			value := vm.pop()
+			index := vm.pop()
+			if err := builtin.SetIndex(vm.peek(0), index, value); err != nil {
+				vm.error(err.Error())
+			}
+			vm.stack[vm.sp-1] = value
+		case compiler.EQUAL:
+			right := vm.pop()
+			vm.stack[vm.sp-1] = vm.stack[vm.sp-1] == right
+		case compiler.NOT_EQUAL:
+			right := vm.pop()
+			vm.stack[vm.sp-1] = vm.stack[vm.sp-1] != right
+		case compiler.GREATER:
+			left, right := vm.numberOperands()
+			vm.stack[vm.sp-1] = left > right
+		case compiler.GREATER_EQUAL:
+			left, right := vm.numberOperands()
+			vm.stack[vm.sp-1] = left >= right
+		case compiler.LESS:
+			left, right := vm.numberOperands()
+			vm.stack[vm.sp-1] = left < right
+		case compiler.LESS_EQUAL:
+			left, right := vm.numberOperands()
+			vm.stack[vm.sp-1] = left <= right
+		case compiler.ADD:
+			vm.add()
+		case compiler.SUBTRACT:
+			left, right := vm.numberOperands()
+			vm.stack[vm.sp-1] = left - right
+		case compiler.MULTIPLY:
+			left, right := vm.numberOperands()
+			vm.stack[vm.sp-1] = left * right
+		case compiler.DIVIDE:
+			left, right := vm.numberOperands()
+			vm.stack[vm.sp-1] = left / right
+		case compiler.NOT:
+			vm.stack[vm.sp-1] = !truthy(vm.stack[vm.sp-1])
+		case compiler.NEGATE:
+			x, ok := vm.peek(0).(float64)
+			if !ok {
+				vm.error("operand must be a number")
+			}
+			vm.stack[vm.sp-1] = -x
+		case compiler.PRINT:
+			fmt.Fprintln(vm.streams.Stdout, vm.pop())
+		case compiler.ASSERT:
+			if !truthy(vm.pop()) {
+				vm.error("assertion failed")
+			}
+		case compiler.JUMP:
+			offset := f.readShort()
+			f.ip += offset
+		case compiler.JUMP_IF_FALSE:
+			offset := f.readShort()
+			if !truthy(vm.peek(0)) {
+				f.ip += offset
+			}
+		case compiler.LOOP:
+			offset := f.readShort()
+			f.ip -= offset
+		case compiler.TRY, compiler.FINALLY:
+			offset := f.readShort()
+			vm.handlers = append(vm.handlers, handler{frameCount: vm.frameCount, sp: vm.sp, ip: f.ip + offset, finally: op == compiler.FINALLY})
+		case compiler.END_TRY:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case compiler.THROW:
+			vm.throw(vm.pop())
+		case compiler.RETHROW:
+			panic(vm.pop())
+		case compiler.CALL:
+			argCount := int(f.readByte())
+			vm.callValue(vm.peek(argCount), argCount)
+			f = &vm.frames[vm.frameCount-1]
+		case compiler.INVOKE:
+			name := f.readString()
+			argCount := int(f.readByte())
+			vm.invoke(name, argCount)
+			f = &vm.frames[vm.frameCount-1]
+		case compiler.SUPER_INVOKE:
+			name := f.readString()
+			argCount := int(f.readByte())
+			vm.invokeFromClass(vm.pop().(*class), name, argCount)
+			f = &vm.frames[vm.frameCount-1]
+		case compiler.CLOSURE:
+			function := f.constants[f.readShort()].(*compiler.Function)
+			closure := &closure{function: function, upvalues: make([]*upvalue, function.UpvalueCount)}
+			for i := range closure.upvalues {
+				isLocal := f.readByte() == 1
+				index := int(f.readByte())
+				if isLocal {
+					closure.upvalues[i] = vm.captureUpvalue(f.base + index)
+				} else {
+					closure.upvalues[i] = f.closure.upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case compiler.CLOSE_UPVALUE:
+			vm.closeUpvalues(vm.sp - 1)
+			vm.sp--
+		case compiler.RETURN:
+			result := vm.pop()
+			vm.closeUpvalues(f.base)
+			vm.frameCount--
+			vm.sp = f.base
+			if vm.frameCount == vm.base {
+				return result
+			}
+			vm.push(result)
+			f = &vm.frames[vm.frameCount-1]
+		case compiler.CLASS:
+			vm.push(&class{name: f.readString(), methods: make(map[string]*closure)})
+		case compiler.INHERIT:
+			superclass, ok := vm.peek(1).(*class)
+			if !ok {
+				vm.error("superclass must be a class")
+			}
+			subclass := vm.peek(0).(*class)
+			for name, method := range superclass.methods {
+				subclass.methods[name] = method
+			}
+			vm.sp--
+		case compiler.METHOD:
+			name := f.readString()
+			vm.peek(1).(*class).methods[name] = vm.peek(0).(*closure)
+			vm.sp--
+		default:
+			panic(fmt.Errorf("unexpected opcode: %v", compiler.OpCode(f.code[f.ip-1])))
+		}
+	}
+}
+
+// numberOperands pops the right operand of a binary operation, leaving the left one in
+// place for the result to overwrite.
+func (vm *VM) numberOperands() (float64, float64) {
+	left, ok := vm.peek(1).(float64)
+	if !ok {
+		vm.error("left operand must be a number")
+	}
+	right, ok := vm.peek(0).(float64)
+	if !ok {
+		vm.error("right operand must be a number")
+	}
+	vm.sp--
+	return left, right
+}
+
+func (vm *VM) add() {
+	switch left := vm.peek(1).(type) {
+	case float64:
+		right, ok := vm.peek(0).(float64)
+		if !ok {
+			vm.error("right operand must be a number")
+		}
+		vm.sp--
+		vm.stack[vm.sp-1] = left + right
+	case string:
+		right, ok := vm.peek(0).(string)
+		if !ok {
+			vm.error("right operand must be a string")
+		}
+		vm.sp--
+		vm.stack[vm.sp-1] = left + right
+	default:
+		vm.error("left operand must be a number or a string")
+	}
+}
+
+func (vm *VM) call(closure *closure, argCount int) {
+	if argCount != closure.function.Arity {
+		vm.error(fmt.Sprintf("expected %d arguments but got %d", closure.function.Arity, argCount))
+	}
+	if vm.frameCount-vm.outerFrames >= vm.maxDepth {
+		vm.fail(builtin.ErrCallDepth.Error(), builtin.ErrCallDepth)
+	}
+	chunk := &closure.function.Chunk
+	if vm.frameCount == len(vm.frames) {
+		vm.frames = append(vm.frames, frame{})
+	}
+	vm.frames[vm.frameCount] = frame{closure: closure, code: chunk.Code, constants: chunk.Constants, base: vm.sp - argCount - 1}
+	vm.frameCount++
+}
+
+func (vm *VM) callValue(callee interface{}, argCount int) {
+	switch callee := callee.(type) {
+	case *closure:
+		vm.call(callee, argCount)
+	case *native:
+		if err := callee.CheckArity(argCount); err != nil {
+			vm.error(err.Error())
+		}
+		result, err := callee.Call(vm.stack[vm.sp-argCount : vm.sp])
+		if err != nil {
+			vm.error(err.Error())
+		}
+		vm.sp -= argCount + 1
+		vm.push(result)
+	case *class:
+		vm.stack[vm.sp-argCount-1] = &instance{class: callee, fields: make(map[string]interface{})}
+		if initializer, ok := callee.methods["init"]; ok {
+			vm.call(initializer, argCount)
+		} else if argCount != 0 {
+			vm.error(fmt.Sprintf("expected 0 arguments but got %d", argCount))
+		}
+	case *boundMethod:
+		vm.stack[vm.sp-argCount-1] = callee.receiver
+		vm.call(callee.method, argCount)
+	default:
+		vm.error("can only call functions and classes")
+	}
+}
+
+func (vm *VM) invoke(name string, argCount int) {
+	if e, ok := vm.peek(argCount).(*builtin.Error); ok {
+		value := vm.errorProperty(e, name)
+		vm.stack[vm.sp-argCount-1] = value
+		vm.callValue(value, argCount)
+		return
+	}
+	instance, ok := vm.peek(argCount).(*instance)
+	if !ok {
+		vm.error("only instances have properties")
+	}
+	if value, ok := instance.fields[name]; ok {
+		vm.stack[vm.sp-argCount-1] = value
+		vm.callValue(value, argCount)
+		return
+	}
+	vm.invokeFromClass(instance.class, name, argCount)
+}
+
+func (vm *VM) errorProperty(e *builtin.Error, name string) interface{} {
+	value, err := e.Get(name)
+	if err != nil {
+		vm.error(err.Error())
+	}
+	return value
+}
+
+func (vm *VM) invokeFromClass(class *class, name string, argCount int) {
+	method, ok := class.methods[name]
+	if !ok {
+		vm.error(fmt.Sprintf("undefined property '%s'", name))
+	}
+	vm.call(method, argCount)
+}
+
+// bindMethod replaces the instance on top of the stack with its method with the given name.
+func (vm *VM) bindMethod(class *class, name string) {
+	method, ok := class.methods[name]
+	if !ok {
+		vm.error(fmt.Sprintf("undefined property '%s'", name))
+	}
+	vm.stack[vm.sp-1] = &boundMethod{receiver: vm.peek(0), method: method}
+}
+
+// captureUpvalue returns the open upvalue for the given stack slot, creating it if needed.
+// Open upvalues are kept in a list sorted by slot, from the top of the stack down.
+func (vm *VM) captureUpvalue(slot int) *upvalue {
+	var previous *upvalue
+	current := vm.openUpvalues
+	for current != nil && current.slot > slot {
+		previous = current
+		current = current.next
+	}
+	if current != nil && current.slot == slot {
+		return current
+	}
+	created := &upvalue{open: true, slot: slot, next: current}
+	if previous == nil {
+		vm.openUpvalues = created
+	} else {
+		previous.next = created
+	}
+	return created
+}
+
+// closeUpvalues closes every open upvalue pointing at the given stack slot or above it.
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = vm.stack[upvalue.slot]
+		upvalue.open = false
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+func (vm *VM) getUpvalue(upvalue *upvalue) interface{} {
+	if upvalue.open {
+		return vm.stack[upvalue.slot]
+	}
+	return upvalue.closed
+}
+
+func (vm *VM) setUpvalue(upvalue *upvalue, value interface{}) {
+	if upvalue.open {
+		vm.stack[upvalue.slot] = value
+	} else {
+		upvalue.closed = value
+	}
+}
@@ -0,0 +1,205 @@
+package vm
+
+import (
+	"bufio"
+	"lox/ast"
+	"lox/builtin"
+	"lox/parser"
+	"lox/resolver"
+	"lox/scanner"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestVMSimpleExpr(t *testing.T) {
+	expectResult(t, "1 + 2;", 3.0)
+	expectResult(t, "-(1 * (2 + 3) / (4 - 5));", 5.0)
+	expectResult(t, "\"foot\" + \"ball\";", "football")
+	expectResult(t, "!nil;", true)
+	expectResult(t, "nil == nil;", true)
+	expectResult(t, "1 <= 2 and 2 >= 3 or \"x\";", "x")
+}
+
+func TestVMTypeErrors(t *testing.T) {
+	expectRuntimeError(t, "1 + \"hi\";", "right operand must be a number")
+	expectRuntimeError(t, "\"hi\" + 1;", "right operand must be a string")
+	expectRuntimeError(t, "true + 1;", "left operand must be a number or a string")
+	expectRuntimeError(t, "\"hi\" < 1;", "left operand must be a number")
+	expectRuntimeError(t, "-true;", "operand must be a number")
+}
+
+func TestVMEnv(t *testing.T) {
+	expectRuntimeError(t, "x + 1;", "variable not defined")
+	expectRuntimeError(t, "x = 1;", "variable not declared")
+	expectRuntimeError(t, "var x = 1; var x = 2;", "variable already declared")
+}
+
+func TestVMClosure(t *testing.T) {
+	expectResult(t, "fun f() { var x = 1; fun g() { x = x + 1; return x; } return g; } var g = f(); g(); g();", 3.0)
+	expectResult(t, "var a; var b; { var x = 1; fun get() { return x; } fun set(v) { x = v; } a = get; b = set; } b(2); a();", 2.0)
+}
+
+func TestVMLambda(t *testing.T) {
+	expectResult(t, "var add = fun (a, b) { return a + b; }; add(1, 2);", 3.0)
+	expectResult(t, "fun adder(n) { return fun (x) { return x + n; }; } adder(1)(2);", 3.0)
+	expectResult(t, "{ var n = 2; var double = fun (x) { return x * n; }; n = 3; var r = double(2); assert r == 6; }", nil)
+}
+
+func TestVMClass(t *testing.T) {
+	expectResult(t, "class Foo { init(x) { this.x = x; } get() { return this.x; } } Foo(42).get();", 42.0)
+	expectResult(t, "class Foo { init() { this.n = 1; } } var foo = Foo(); foo.n = 2; foo.init().n;", 1.0)
+	expectResult(t, "class Foo {} var foo = Foo(); fun f() { return 3; } foo.f = f; foo.f();", 3.0)
+	expectRuntimeError(t, "class Foo {} Foo(1);", "expected 0 arguments but got 1")
+	expectRuntimeError(t, "class Foo {} Foo().bar();", "undefined property 'bar'")
+}
+
+func TestVMSuper(t *testing.T) {
+	expectResult(t, "class A { m() { return \"A\"; } } class B < A { m() { return \"B\" + super.m(); } } B().m();", "BA")
+	expectResult(t, "class A { m() { return \"A\"; } } class B < A { m() { return super.m; } } B().m()();", "A")
+	expectRuntimeError(t, "var A = 1; class B < A {}", "superclass must be a class")
+}
+
+func TestVMList(t *testing.T) {
+	expectResult(t, "var xs = [1, 2, 3]; xs[1];", 2.0)
+	expectResult(t, "{ var xs = [[1], [2]]; xs[1][0] = 3; assert xs[1][0] == 3; }", nil)
+	expectResult(t, "var xs = []; push(xs, 1); push(xs, 2); insert(xs, 2, 3); xs[2];", 3.0)
+	expectResult(t, "var xs = [1, 2, 3]; pop(xs) + len(xs);", 5.0)
+	expectRuntimeError(t, "[1, 2][-1];", "index -1 out of bounds for list of length 2")
+	expectRuntimeError(t, "nil[0];", "only lists and maps can be indexed")
+	expectRuntimeError(t, "len(1);", "argument to 'len' must be a list, a map or a string")
+	expectRuntimeError(t, "push(1, 2);", "first argument to 'push' must be a list")
+}
+
+func TestVMMap(t *testing.T) {
+	expectResult(t, "var m = {\"a\": 1, \"b\": 2}; m[\"a\"] + m[\"b\"];", 3.0)
+	expectResult(t, "{ var m = {1: 1}; m[1] = 2; assert len(m) + m[1] == 3; }", nil)
+	expectResult(t, "var m = {\"b\": 1, \"a\": 2}; delete(m, \"b\"); has(m, \"a\") and keys(m)[0] == \"a\";", true)
+	expectRuntimeError(t, "var m = {0: 1}; m[-1];", "key -1 not found in map")
+	expectRuntimeError(t, "var m = {1: 1, fun () {}: 2};", "map keys must be numbers, strings, booleans or nil")
+}
+
+func TestVMBreakContinue(t *testing.T) {
+	expectResult(t, "var n = 0; while (true) { n = n + 1; if (n == 3) break; } n;", 3.0)
+	expectResult(t, "var n = 0; for (var i = 0; i < 5; i = i + 1) { var x = i; if (x == 2) continue; n = n + x; } n;", 8.0)
+	expectResult(t, "var f; for (var i = 0; i < 3; i = i + 1) { var j = i; if (i == 2) break; fun g() { return j; } f = g; } f();", 1.0)
+	expectResult(t, "fun f() { var n = 0; for (var i = 0; ; i = i + 1) { var a = i; { var b = a; if (b > 3) break; } n = n + a; } return n; } f();", 6.0)
+}
+
+func TestVMTry(t *testing.T) {
+	expectResult(t, "var r; try { throw 42; } catch (e) { r = e; } r;", 42.0)
+	expectResult(t, "var r; try {\n\n[][0]; } catch (e) { r = e.line; } r;", 3.0)
+	expectResult(t, "fun f(n) { if (n == 0) 1 + nil; f(n - 1); } var r; try { f(3); } catch (e) { r = e.message; } r;", "right operand must be a number")
+	expectResult(t, "var r = \"\"; try { try { throw 1; } finally { r = r + \"f\"; } } catch (e) { r = r + \"c\"; } r;", "fc")
+	expectResult(t, "var r = 0; fun f() { var a = 1; try { var b = 2; return a + b; } finally { r = 2; } } f() + r;", 5.0)
+	expectResult(t, "fun f() { try { throw 1; } finally { return 2; } } f();", 2.0)
+	expectResult(t, "fun f() { var n = 0; for (var i = 0; i < 5; i = i + 1) { try { if (i == 3) break; continue; } finally { n = n + 1; } } return n; } f();", 4.0)
+	expectResult(t, "fun f() { fun g() { return 1; } return g; } var r; try { throw f; } catch (e) { r = e()(); } r;", 1.0)
+	expectRuntimeError(t, "throw \"oops\";", "line 1: uncaught exception: \"oops\"")
+	expectRuntimeError(t, "try {\nnil();\n} catch (e) { throw e; }", "line 2: can only call functions and classes")
+	expectRuntimeError(t, "try { nil(); } catch (e) { e.message(); }", "can only call functions and classes")
+}
+
+func TestVMCatchStackOverflow(t *testing.T) {
+	expectResult(t, "fun f() { f(); } var r; try { f(); } catch (e) { r = e.message; } r;", "stack overflow")
+}
+
+func TestVMStackOverflow(t *testing.T) {
+	expectRuntimeError(t, "fun f() { f(); } f();", "stack overflow")
+}
+
+func TestVMRecoversAfterError(t *testing.T) {
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader("fun f() { var x = 1; { var y = -\"y\"; } } f(); 1 + 2;"))))
+	vm := NewVM()
+	for _, expectError := range []bool{false, true, false} {
+		stmt, err := p.NextStatement()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := vm.Interpret(stmt); (err != nil) != expectError {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if vm.sp != 0 || vm.frameCount != 0 {
+		t.Errorf("expected empty stack, got %d values and %d frames", vm.sp, vm.frameCount)
+	}
+}
+
+func TestVMTrace(t *testing.T) {
+	src := `fun fail() {
+  return -nil;
+}
+fun f(n) {
+  if (n == 0) return fail();
+  return f(n - 1);
+}
+try { f(3); } catch (e) {}
+f(1);`
+	expectTrace(t, src, []builtin.Frame{{Function: "fail", Line: 2}, {Function: "f", Line: 5}, {Function: "f", Line: 6}, {Function: "script", Line: 9}})
+	expectTrace(t, "\nassert false;", []builtin.Frame{{Function: "script", Line: 2}})
+	expectTrace(t, "var g = fun() { undefined; };\ng();", []builtin.Frame{{Function: "<fn>", Line: 1}, {Function: "script", Line: 2}})
+}
+
+func expectTrace(t *testing.T, src string, expected []builtin.Frame) {
+	t.Helper()
+	_, err := interpret(t, src)
+	re, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("expected runtime error, got '%v'", err)
+	}
+	if !reflect.DeepEqual(re.Trace(), expected) {
+		t.Errorf("expected trace %v, got %v", expected, re.Trace())
+	}
+}
+
+func expectRuntimeError(t *testing.T, src string, regex string) {
+	t.Helper()
+	if _, err := interpret(t, src); err == nil {
+		t.Errorf("expected runtime error matching '%s', got none", regex)
+	} else if re, ok := err.(*RuntimeError); ok {
+		if !regexp.MustCompile(regex).MatchString(re.Error()) {
+			t.Errorf("expected runtime error matching '%s', got '%v'", regex, re.Error())
+		}
+	} else {
+		t.Errorf("expected runtime error, got '%v'", err)
+	}
+}
+
+func expectResult(t *testing.T, src string, expected interface{}) {
+	t.Helper()
+	if result, err := interpret(t, src); err != nil {
+		t.Error(err)
+	} else if result != expected {
+		t.Errorf("expected '%v', got '%v'", expected, result)
+	}
+}
+
+type locals struct{}
+
+func (l locals) Resolve(expr ast.Expr, depth int, slot int) {
+}
+
+func interpret(t *testing.T, src string) (interface{}, error) {
+	p := parser.NewParser(scanner.NewScanner(bufio.NewReader(strings.NewReader(src))))
+	vm := NewVM()
+	r := resolver.NewResolver(locals{})
+	var result interface{}
+	for {
+		stmt, err := p.NextStatement()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Resolve(stmt); err != nil {
+			return nil, err
+		}
+		res, err := vm.Interpret(stmt)
+		if err != nil {
+			return nil, err
+		}
+		if vm.Done() {
+			return result, nil
+		}
+		result = res
+	}
+}